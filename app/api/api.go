@@ -17,7 +17,9 @@ func New(
 	logger log.Logger,
 	externalEndpointRegister EndpointRegister,
 ) API {
-	router := mux.NewRouter().StrictSlash(false)
+	// paths are forwarded as the client sent them, so they
+	// are neither cleaned nor decoded before matching them
+	router := mux.NewRouter().StrictSlash(false).SkipClean(true).UseEncodedPath()
 	router.Use(
 		middlewares.Logger(logger),
 	)
@@ -1,10 +1,10 @@
 package transport
 
 import (
-	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"strings"
 
@@ -18,7 +18,7 @@ type forwardRequestHTTPProvider interface {
 	Forward(
 		ctx context.Context,
 		request *values.Request,
-	) ([]byte, int, error)
+	) (*values.Response, error)
 }
 
 type forwardRequestHTTPHandler struct {
@@ -35,55 +35,112 @@ func NewForwardRequest(
 	return &forwardRequestHTTPHandler{
 		logger:      logger,
 		provider:    provider,
-		routePrefix: routePrefix,
+		routePrefix: normalizeRoutePrefix(routePrefix),
 	}
 }
 
+// normalizeRoutePrefix makes the route prefix start and end with a slash,
+// so that an empty prefix or "/" serves every path transparently
+func normalizeRoutePrefix(routePrefix string) string {
+	routePrefix = strings.Trim(routePrefix, "/")
+	if routePrefix == "" {
+		return "/"
+	}
+
+	return "/" + routePrefix + "/"
+}
+
 // ServeHTTP receives a http request, calls the Proxy provider and serves the response
 func (c *forwardRequestHTTPHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	// get the path of the service to be accessed
-	pathSplit := strings.Split(req.URL.Path, c.routePrefix)
+	// get the path of the service to be accessed, only the leading prefix
+	// is removed since the same string may appear again later in the path,
+	// and the path is kept escaped so that encoded characters such as %2F
+	// reach the service as the client sent them
+	path := req.URL.EscapedPath()
 
 	var endpoint string
-	if len(pathSplit) > 1 {
-		endpoint = pathSplit[1]
-	}
-
-	// read payload from buffer
-	payload, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		c.logger.Log("transport", "proxyRequest/HTTP", "error", err.Error())
-		http.Error(
-			w,
-			err.Error(),
-			http.StatusBadRequest,
-		)
-		return
+	if strings.HasPrefix(path, c.routePrefix) {
+		endpoint = strings.TrimPrefix(path, c.routePrefix)
 	}
 
 	// execute proxy forwarding
-	response, statusCode, err := c.provider.Forward(
+	response, err := c.provider.Forward(
 		req.Context(),
 		&values.Request{
+			ID:         requestID(req),
 			Method:     req.Method,
 			Endpoint:   endpoint,
 			Header:     req.Header,
 			HostHeader: req.Host,
 			Parameters: req.URL.RawQuery,
-			Payload:    payload,
+			Body:       req.Body,
+			RemoteAddr: req.RemoteAddr,
+			Scheme:     scheme(req),
 		},
 	)
 	if err != nil {
+		response.Close()
 		c.logger.Log("transport", "proxyRequest/HTTP", "error", err.Error())
-		encoder.Encode(req.Context(), &encoder.Error{Code: statusCode, Message: err.Error()}, w)
+		encoder.Encode(req.Context(), &encoder.Error{Code: response.StatusCode, Message: err.Error()}, w)
 		return
 	}
+	defer response.Close()
 
-	w.WriteHeader(statusCode)
-	_, err = io.Copy(w, bytes.NewReader(response))
-	if err != nil {
-		c.logger.Log("transport", "proxyRequest/HTTP", "error", err.Error())
-		encoder.Encode(req.Context(), &encoder.Error{Code: http.StatusInternalServerError, Message: err.Error()}, w)
+	for name, header := range response.Header {
+		w.Header()[name] = header
+	}
+
+	w.WriteHeader(response.StatusCode)
+
+	if response.Body == nil {
 		return
 	}
+
+	// the body is streamed to the client as it arrives, flushing each
+	// chunk so that slow streams reach the client without delay
+	var writer io.Writer = w
+	if flusher, ok := w.(http.Flusher); ok {
+		writer = &flushWriter{w: w, flusher: flusher}
+	}
+
+	if _, err = io.Copy(writer, response.Body); err != nil {
+		// the status was already sent, so the only way to tell the
+		// client that the body is incomplete is to abort the response
+		c.logger.Log("transport", "proxyRequest/HTTP", "error", err.Error())
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// flushWriter flushes every write to the client
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.flusher.Flush()
+	return n, err
+}
+
+// requestID returns the ID that the client sent for a request,
+// or a new random one when the client didn't send any
+func requestID(req *http.Request) string {
+	if id := req.Header.Get(values.RequestIDHeader); id != "" {
+		return id
+	}
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// scheme returns the protocol that the peer used to send a request
+func scheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+
+	return "http"
 }
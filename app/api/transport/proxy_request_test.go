@@ -2,13 +2,17 @@ package transport_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"go-reverse-proxy/app/api"
 	"go-reverse-proxy/app/api/transport"
 	"go-reverse-proxy/app/common/log"
 	"go-reverse-proxy/app/values"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	proxyMock "go-reverse-proxy/mocks/app/handlers/proxy"
@@ -22,8 +26,8 @@ func TestProxyRequest(t *testing.T) {
   "message": "Hello World!", 
 }`
 	forwardRequestProviderMock := &proxyMock.HandlerMock{
-		ForwardFunc: func(ctx context.Context, request *values.Request) ([]byte, int, error) {
-			return []byte(responseBody), http.StatusOK, nil
+		ForwardFunc: func(ctx context.Context, request *values.Request) (*values.Response, error) {
+			return &values.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(responseBody))}, nil
 		},
 	}
 
@@ -47,6 +51,8 @@ func TestProxyRequest(t *testing.T) {
 	assert.Equal(t, "service.com", forwardRequestProviderMock.ForwardCalls()[0].Request.HostHeader)
 	assert.Equal(t, "api/v1/users", forwardRequestProviderMock.ForwardCalls()[0].Request.Endpoint)
 	assert.Equal(t, "parameter-key=test", forwardRequestProviderMock.ForwardCalls()[0].Request.Parameters)
+	assert.Equal(t, req.RemoteAddr, forwardRequestProviderMock.ForwardCalls()[0].Request.RemoteAddr)
+	assert.Equal(t, "http", forwardRequestProviderMock.ForwardCalls()[0].Request.Scheme)
 	assert.Len(t, forwardRequestProviderMock.ForwardCalls(), 1)
 
 	body, err := ioutil.ReadAll(resp.Body)
@@ -54,12 +60,65 @@ func TestProxyRequest(t *testing.T) {
 	assert.Equal(t, responseBody, string(body))
 }
 
+func TestProxyRequestResponseHeaders(t *testing.T) {
+	forwardRequestProviderMock := &proxyMock.HandlerMock{
+		ForwardFunc: func(ctx context.Context, request *values.Request) (*values.Response, error) {
+			return &values.Response{
+				StatusCode: http.StatusMovedPermanently,
+				Header:     http.Header{"Location": []string{"https://service.com/users"}},
+			}, nil
+		},
+	}
+
+	handler := transport.NewForwardRequest(
+		log.NewNopLogger(),
+		forwardRequestProviderMock,
+		"proxy/",
+	)
+
+	req := httptest.NewRequest("GET", "http://127.0.0.1:5000/proxy/users", nil)
+	req.Host = "service.com"
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "https://service.com/users", resp.Header.Get("Location"))
+}
+
+func TestProxyRequestID(t *testing.T) {
+	forwardRequestProviderMock := &proxyMock.HandlerMock{
+		ForwardFunc: func(ctx context.Context, request *values.Request) (*values.Response, error) {
+			return &values.Response{StatusCode: http.StatusOK}, nil
+		},
+	}
+
+	handler := transport.NewForwardRequest(
+		log.NewNopLogger(),
+		forwardRequestProviderMock,
+		"proxy/",
+	)
+
+	req := httptest.NewRequest("GET", "http://127.0.0.1:5000/proxy/users", nil)
+	req.Header.Set("X-Request-Id", "abc")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "http://127.0.0.1:5000/proxy/users", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Len(t, forwardRequestProviderMock.ForwardCalls(), 2)
+	// the ID sent by the client is kept, otherwise a new one is generated
+	assert.Equal(t, "abc", forwardRequestProviderMock.ForwardCalls()[0].Request.ID)
+	assert.Len(t, forwardRequestProviderMock.ForwardCalls()[1].Request.ID, 32)
+}
+
 func TestProxyRequestError(t *testing.T) {
 	url := "http://127.0.0.1:5000/proxy/"
 
 	forwardRequestProviderMock := &proxyMock.HandlerMock{
-		ForwardFunc: func(ctx context.Context, request *values.Request) ([]byte, int, error) {
-			return []byte{}, http.StatusInternalServerError, fmt.Errorf("error")
+		ForwardFunc: func(ctx context.Context, request *values.Request) (*values.Response, error) {
+			return &values.Response{StatusCode: http.StatusInternalServerError}, fmt.Errorf("error")
 		},
 	}
 
@@ -79,3 +138,220 @@ func TestProxyRequestError(t *testing.T) {
 
 	assert.Len(t, forwardRequestProviderMock.ForwardCalls(), 1)
 }
+
+func TestProxyRequestEndpoint(t *testing.T) {
+	testCases := []struct {
+		name        string
+		routePrefix string
+		path        string
+		expected    string
+	}{
+		{
+			name:        "legacy prefix",
+			routePrefix: "/proxy/",
+			path:        "/proxy/api/v1/users",
+			expected:    "api/v1/users",
+		},
+		{
+			name:        "prefix repeated later in the path",
+			routePrefix: "proxy/",
+			path:        "/proxy/api/proxy/v1/proxy/",
+			expected:    "api/proxy/v1/proxy/",
+		},
+		{
+			name:        "transparent",
+			routePrefix: "/",
+			path:        "/api/v1/users",
+			expected:    "api/v1/users",
+		},
+		{
+			name:        "transparent with empty prefix",
+			routePrefix: "",
+			path:        "/proxy/api/v1",
+			expected:    "proxy/api/v1",
+		},
+		{
+			name:        "custom prefix",
+			routePrefix: "/gateway",
+			path:        "/gateway/api/v1",
+			expected:    "api/v1",
+		},
+		{
+			name:        "encoded characters",
+			routePrefix: "/proxy/",
+			path:        "/proxy/files/a%2Fb/c%20d",
+			expected:    "files/a%2Fb/c%20d",
+		},
+		{
+			name:        "double slashes",
+			routePrefix: "/",
+			path:        "/api//v1//users",
+			expected:    "api//v1//users",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			forwardRequestProviderMock := &proxyMock.HandlerMock{
+				ForwardFunc: func(ctx context.Context, request *values.Request) (*values.Response, error) {
+					return &values.Response{StatusCode: http.StatusOK}, nil
+				},
+			}
+
+			handler := transport.NewForwardRequest(
+				log.NewNopLogger(),
+				forwardRequestProviderMock,
+				tc.routePrefix,
+			)
+
+			req := httptest.NewRequest("GET", "http://127.0.0.1:5000"+tc.path, nil)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Len(t, forwardRequestProviderMock.ForwardCalls(), 1)
+			assert.Equal(t, tc.expected, forwardRequestProviderMock.ForwardCalls()[0].Request.Endpoint)
+		})
+	}
+}
+
+func TestBuildEndpointRegister(t *testing.T) {
+	testCases := []struct {
+		name       string
+		pathPrefix string
+		path       string
+		status     int
+	}{
+		{
+			name:       "legacy prefix",
+			pathPrefix: "/proxy/",
+			path:       "/proxy/api/v1",
+			status:     http.StatusOK,
+		},
+		{
+			name:       "path outside the legacy prefix",
+			pathPrefix: "/proxy/",
+			path:       "/api/v1",
+			status:     http.StatusNotFound,
+		},
+		{
+			name:       "transparent",
+			pathPrefix: "/",
+			path:       "/api/v1",
+			status:     http.StatusOK,
+		},
+		{
+			name:       "transparent root",
+			pathPrefix: "/",
+			path:       "/",
+			status:     http.StatusOK,
+		},
+		{
+			name:       "double slashes aren't redirected",
+			pathPrefix: "/",
+			path:       "/api//v1",
+			status:     http.StatusOK,
+		},
+		{
+			name:       "encoded slash in the legacy prefix",
+			pathPrefix: "/proxy/",
+			path:       "/proxy%2Fapi",
+			status:     http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			forwardRequestProviderMock := &proxyMock.HandlerMock{
+				ForwardFunc: func(ctx context.Context, request *values.Request) (*values.Response, error) {
+					return &values.Response{StatusCode: http.StatusOK}, nil
+				},
+			}
+
+			handler := api.New(
+				log.NewNopLogger(),
+				transport.BuildEndpointRegister(
+					log.NewNopLogger(),
+					forwardRequestProviderMock,
+					tc.pathPrefix,
+				),
+			)
+
+			req := httptest.NewRequest("GET", "http://127.0.0.1:5000"+tc.path, nil)
+			req.Host = "service.com"
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.status, w.Result().StatusCode)
+		})
+	}
+}
+
+// trackedBody is a response body that remembers if it was closed
+type trackedBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestProxyRequestStreamsBodies(t *testing.T) {
+	var received string
+	responseBody := &trackedBody{Reader: strings.NewReader("streamed response")}
+	forwardRequestProviderMock := &proxyMock.HandlerMock{
+		ForwardFunc: func(ctx context.Context, request *values.Request) (*values.Response, error) {
+			body, _ := ioutil.ReadAll(request.Body)
+			received = string(body)
+			return &values.Response{StatusCode: http.StatusOK, Body: responseBody}, nil
+		},
+	}
+
+	handler := transport.NewForwardRequest(
+		log.NewNopLogger(),
+		forwardRequestProviderMock,
+		"proxy/",
+	)
+
+	req := httptest.NewRequest("POST", "http://127.0.0.1:5000/proxy/upload", strings.NewReader("streamed request"))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, "streamed request", received)
+	assert.Equal(t, "streamed response", w.Body.String())
+	assert.True(t, w.Flushed)
+	assert.True(t, responseBody.closed)
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset by host")
+}
+
+func TestProxyRequestAbortsIncompleteBody(t *testing.T) {
+	responseBody := &trackedBody{Reader: io.MultiReader(strings.NewReader("partial"), failingReader{})}
+	forwardRequestProviderMock := &proxyMock.HandlerMock{
+		ForwardFunc: func(ctx context.Context, request *values.Request) (*values.Response, error) {
+			return &values.Response{StatusCode: http.StatusOK, Body: responseBody}, nil
+		},
+	}
+
+	handler := transport.NewForwardRequest(
+		log.NewNopLogger(),
+		forwardRequestProviderMock,
+		"proxy/",
+	)
+
+	req := httptest.NewRequest("GET", "http://127.0.0.1:5000/proxy/download", nil)
+	w := httptest.NewRecorder()
+
+	// the server closes the connection instead of ending the response
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(w, req)
+	})
+	assert.True(t, responseBody.closed)
+}
@@ -12,10 +12,11 @@ import (
 func BuildEndpointRegister(
 	logger log.Logger,
 	svc proxy.Handler,
+	pathPrefix string,
 ) api.EndpointRegister {
 	return func(router *mux.Router, options ...httpkit.ServerOption) {
-		router.PathPrefix("/proxy/").Handler(
-			NewForwardRequest(logger, svc, "proxy/"),
-		)
+		forwardRequest := NewForwardRequest(logger, svc, pathPrefix)
+
+		router.PathPrefix(forwardRequest.routePrefix).Handler(forwardRequest)
 	}
 }
@@ -0,0 +1,115 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OnClose returns a body that calls a function once it is closed, such
+// as the cancellation of the context of the request that it belongs to
+func OnClose(body io.ReadCloser, fn func()) io.ReadCloser {
+	return &closeNotifier{ReadCloser: body, fn: fn}
+}
+
+type closeNotifier struct {
+	io.ReadCloser
+	once sync.Once
+	fn   func()
+}
+
+func (b *closeNotifier) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.fn)
+	return err
+}
+
+// inMemory checks if a body is held in memory, in which case it can be
+// read again by the retries, the same bodies for which net/http knows
+// the length in advance
+func inMemory(body io.Reader) bool {
+	switch body.(type) {
+	case nil, *bytes.Reader, *bytes.Buffer, *strings.Reader:
+		return true
+	default:
+		return false
+	}
+}
+
+// contentLength returns the length of a streamed body announced by the
+// client, or -1 when it is unknown and the body is sent in chunks
+func contentLength(header http.Header) int64 {
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return -1
+	}
+
+	return length
+}
+
+// eofReader calls a function once the whole body was read
+type eofReader struct {
+	io.Reader
+	onEOF func()
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		r.onEOF()
+	}
+
+	return n, err
+}
+
+// responseTimeout cancels a request when the host doesn't send the
+// response headers in time, counting from when it was started
+type responseTimeout struct {
+	duration time.Duration
+	cancel   context.CancelFunc
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool
+	expired bool
+}
+
+func (t *responseTimeout) start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timer == nil && !t.stopped {
+		t.timer = time.AfterFunc(t.duration, t.expire)
+	}
+}
+
+func (t *responseTimeout) expire() {
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return
+	}
+	t.expired = true
+	t.mu.Unlock()
+
+	t.cancel()
+}
+
+// stop stops the timeout once the response headers arrived,
+// returning false if it had already expired by then
+func (t *responseTimeout) stop() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stopped = true
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+
+	return !t.expired
+}
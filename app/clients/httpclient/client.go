@@ -2,13 +2,14 @@
 package httpclient
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"time"
 
+	"go-reverse-proxy/app/values"
+
 	"github.com/go-kit/kit/log"
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/pkg/errors"
@@ -16,13 +17,20 @@ import (
 
 type HttpClient interface {
 	// Request can be used to send an HTTP request to any given destination.
+	// The body is streamed to the destination and, unless it is held in
+	// memory, such as a *bytes.Reader, it is sent once without retries.
+	// The response carries the status code, which is set even when an error
+	// is returned, and the headers and body sent by the destination. The
+	// body is streamed from the destination and must be closed, and it is
+	// nil when an error is returned. The hop-by-hop headers are removed
+	// from the request and the response.
 	Request(
 		ctx context.Context,
 		method string,
 		address string,
 		header http.Header,
 		parameters string,
-		payload []byte) ([]byte, int, error)
+		body io.Reader) (*values.Response, error)
 	// GetHttpCLient is a getter for the base *net.http struct so that
 	// it can be wrapper in other modules
 	GetHttpClient() *http.Client
@@ -31,6 +39,7 @@ type HttpClient interface {
 type defaultClient struct {
 	requestTimeout time.Duration
 	httpClient     *http.Client
+	streamClient   *http.Client // sends the streamed bodies, without retries
 	logger         log.Logger
 }
 
@@ -39,6 +48,19 @@ const (
 	defaultRetryMax      = 3
 )
 
+type contextKey string
+
+const preConnectionRetriesKey contextKey = "pre_connection_retries"
+
+// WithPreConnectionRetries returns a context that makes the client retry
+// the requests made with it when they failed before connecting to the host,
+// which is always safe since the host never received them. Requests made
+// with any other context are sent once, leaving every other retry to the
+// retry policy of the service, which sends them to a different host.
+func WithPreConnectionRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, preConnectionRetriesKey, true)
+}
+
 func New(
 	logger log.Logger,
 	timeout time.Duration,
@@ -49,18 +71,24 @@ func New(
 	retryableClient := retryablehttp.NewClient()
 	retryableClient.RetryWaitMin = defaultRetryDelayMin
 	retryableClient.RetryMax = defaultRetryMax
+	retryableClient.CheckRetry = checkRetry
+	// the response and the error of the last attempt are returned as is,
+	// so that the callers see the status code and headers of the host
+	retryableClient.ErrorHandler = retryablehttp.PassthroughErrorHandler
 
 	// This detail is used to inject a Mockable HTTP client during tests
 	if httpClient != nil {
 		retryableClient.HTTPClient = httpClient
 	}
 
+	streamClient := retryableClient.HTTPClient
 	httpClient = retryableClient.StandardClient()
 
 	var svc HttpClient
 	svc = &defaultClient{
 		requestTimeout: timeout,
 		httpClient:     httpClient,
+		streamClient:   streamClient,
 		logger:         logger,
 	}
 	return svc
@@ -72,40 +100,72 @@ func (c *defaultClient) Request(
 	address string,
 	header http.Header,
 	parameters string,
-	payload []byte,
-) ([]byte, int, error) {
-	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout)
-	defer cancel()
+	body io.Reader,
+) (*values.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
 
 	url := c.buildURL(address, parameters)
 
-	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
+	// the timeout waits for the response headers once the request body
+	// is sent, so that streaming a large body to the host isn't cut short
+	timeout := &responseTimeout{duration: c.requestTimeout, cancel: cancel}
+
+	// bodies held in memory can be sent again by the retries of the
+	// inner client, any other body is streamed once to the host
+	httpClient := c.httpClient
+	streamed := !inMemory(body)
+	if streamed {
+		httpClient = c.streamClient
+		body = &eofReader{Reader: body, onEOF: timeout.start}
+	} else {
+		timeout.start()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
-		c.logger.Log("module", "httpclient", "payload", payload, "err", err, "step", "http.NewRequest")
-		return nil, http.StatusInternalServerError, err
+		cancel()
+		c.logger.Log("module", "httpclient", "url", url, "err", err, "step", "http.NewRequest")
+		return &values.Response{StatusCode: http.StatusInternalServerError}, err
 	}
 
-	req.Header = header
+	req.Header = RemoveHopByHopHeaders(header)
+	if streamed {
+		req.ContentLength = contentLength(header)
+	}
 
-	res, err := c.httpClient.Do(req)
-	if err != nil {
-		c.logger.Log("module", "httpclient", "payload", payload, "err", err, "step", "http.Do")
-		return nil,
-			http.StatusInternalServerError,
-			errors.Wrapf(err, "failed to request service url: /%s", url)
+	res, err := httpClient.Do(req)
+	if !timeout.stop() {
+		if err == nil {
+			res.Body.Close()
+		}
+		err = context.DeadlineExceeded
 	}
-	defer res.Body.Close()
 
-	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		c.logger.Log("module", "httpclient", "payload", payload, "err", err, "step", "ioutil.ReadAll")
-		return nil,
-			res.StatusCode,
-			errors.Wrapf(err, "failed to decode response from service url: /%s", url)
+		cancel()
+		c.logger.Log("module", "httpclient", "url", url, "err", err, "step", "http.Do")
+		return &values.Response{StatusCode: http.StatusInternalServerError},
+			errors.Wrapf(err, "failed to request service url: /%s", url)
 	}
 
 	c.logger.Log("module", "httpclient", "request", url)
-	return body, res.StatusCode, nil
+	return &values.Response{
+		StatusCode: res.StatusCode,
+		Header:     RemoveHopByHopHeaders(res.Header),
+		Body:       OnClose(res.Body, cancel),
+	}, nil
+}
+
+// checkRetry only retries the requests that failed before connecting, and
+// only when they were made with pre-connection retries. Failed responses
+// are never retried to the same host, since the retry policy of the
+// service decides which status codes are retried and where.
+func checkRetry(ctx context.Context, res *http.Response, err error) (bool, error) {
+	if ctx.Value(preConnectionRetriesKey) == nil || ctx.Err() != nil {
+		return false, nil
+	}
+
+	return err != nil && IsConnectFailure(err), nil
 }
 
 func (c *defaultClient) buildURL(address string, parameters string) string {
@@ -8,8 +8,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"go-reverse-proxy/app/clients/httpclient"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -75,19 +79,18 @@ func TestRequest(t *testing.T) {
 
 	httpClient := httpclient.New(log.NewNopLogger(), 5*time.Second, mockHTTPClient)
 
-	var reqPayload []byte
 	header := http.Header{}
 	header.Add("Content-Type", "application/json")
-	resp, statusCode, err := httpClient.Request(
+	resp, err := httpClient.Request(
 		context.TODO(),
 		"GET",
 		"127.0.0.1:8080",
 		header,
 		"",
-		reqPayload)
+		strings.NewReader(""))
 
 	assert.NotNil(t, resp)
-	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	assert.Nil(t, err)
 }
 
@@ -119,22 +122,79 @@ func TestRequestWithParameters(t *testing.T) {
 
 	httpClient := httpclient.New(log.NewNopLogger(), 5*time.Second, mockHTTPClient)
 
-	var reqPayload []byte
 	header := http.Header{}
 	header.Add("Content-Type", "application/json")
-	resp, statusCode, err := httpClient.Request(
+	resp, err := httpClient.Request(
 		context.TODO(),
 		"GET",
 		"127.0.0.1:8080",
 		header,
 		"par1=test&par2=test",
-		reqPayload)
+		strings.NewReader(""))
 
 	assert.NotNil(t, resp)
-	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	assert.Nil(t, err)
 }
 
+func TestRequestKeepsThePathAsIs(t *testing.T) {
+	testCases := []struct {
+		name       string
+		address    string
+		parameters string
+		expected   string
+	}{
+		{
+			name:       "rewritten path with parameters",
+			address:    "127.0.0.1:8080/v2/users",
+			parameters: "page=2&size=10",
+			expected:   "http://127.0.0.1:8080/v2/users?page=2&size=10",
+		},
+		{
+			name:       "encoded characters",
+			address:    "127.0.0.1:8080/files/a%2Fb/c%20d",
+			parameters: "name=a%26b",
+			expected:   "http://127.0.0.1:8080/files/a%2Fb/c%20d?name=a%26b",
+		},
+		{
+			name:     "double slashes",
+			address:  "127.0.0.1:8080/api//v1//users",
+			expected: "http://127.0.0.1:8080/api//v1//users",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var requested string
+			mockHTTPClient := newHTTPClient(
+				func(req *http.Request) *http.Response {
+					requested = req.URL.String()
+					return &http.Response{
+						StatusCode: 200,
+						Body:       ioutil.NopCloser(bytes.NewBufferString("")),
+						Header:     make(http.Header),
+					}
+				},
+				false,
+			)
+
+			httpClient := httpclient.New(log.NewNopLogger(), 5*time.Second, mockHTTPClient)
+
+			resp, err := httpClient.Request(
+				context.TODO(),
+				"GET",
+				tc.address,
+				http.Header{},
+				tc.parameters,
+				nil)
+
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, tc.expected, requested)
+		})
+	}
+}
+
 func TestRequestAddressIsDown(t *testing.T) {
 	// replace the *http.Client w/ one with overriden Transport
 	mockHTTPClient := newHTTPClient(
@@ -144,18 +204,337 @@ func TestRequestAddressIsDown(t *testing.T) {
 
 	httpClient := httpclient.New(log.NewNopLogger(), 5*time.Second, mockHTTPClient)
 
-	var reqPayload []byte
 	header := http.Header{}
 	header.Add("Content-Type", "application/json")
-	resp, statusCode, err := httpClient.Request(
+	resp, err := httpClient.Request(
 		context.TODO(),
 		"GET",
 		"127.0.0.1:8080",
 		header,
 		"",
-		reqPayload)
+		nil)
 
-	assert.Nil(t, resp)
-	assert.Equal(t, http.StatusInternalServerError, statusCode)
+	assert.Nil(t, resp.Body)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
 	assert.NotNil(t, err)
 }
+
+type countingRoundTripper struct {
+	calls int
+	res   func() (*http.Response, error)
+}
+
+func (r *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.calls++
+	return r.res()
+}
+
+func TestRequestWithPreConnectionRetries(t *testing.T) {
+	testCases := []struct {
+		name     string
+		res      func() (*http.Response, error)
+		expected int
+	}{
+		{
+			name: "server error is not retried",
+			res: func() (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Body:       ioutil.NopCloser(bytes.NewBufferString("")),
+					Header:     make(http.Header),
+				}, nil
+			},
+			expected: 1,
+		},
+		{
+			name: "connection reset is not retried",
+			res: func() (*http.Response, error) {
+				return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+			},
+			expected: 1,
+		},
+		{
+			name: "connect failure is retried",
+			res: func() (*http.Response, error) {
+				return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+			},
+			expected: 4,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transport := &countingRoundTripper{res: tc.res}
+			httpClient := httpclient.New(log.NewNopLogger(), 5*time.Second, &http.Client{Transport: transport})
+
+			_, _ = httpClient.Request(
+				httpclient.WithPreConnectionRetries(context.TODO()),
+				"POST",
+				"127.0.0.1:8080",
+				http.Header{},
+				"",
+				bytes.NewReader([]byte(`{"amount": 10}`)))
+
+			assert.Equal(t, tc.expected, transport.calls)
+		})
+	}
+}
+
+func TestRequestIsSentOnce(t *testing.T) {
+	testCases := []struct {
+		name       string
+		res        func() (*http.Response, error)
+		statusCode int
+		failed     bool
+	}{
+		{
+			name: "server error",
+			res: func() (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Body:       ioutil.NopCloser(bytes.NewBufferString("unavailable")),
+					Header:     http.Header{"Retry-After": []string{"1"}},
+				}, nil
+			},
+			statusCode: http.StatusServiceUnavailable,
+		},
+		{
+			name: "connect failure",
+			res: func() (*http.Response, error) {
+				return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+			},
+			statusCode: http.StatusInternalServerError,
+			failed:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transport := &countingRoundTripper{res: tc.res}
+			httpClient := httpclient.New(log.NewNopLogger(), 5*time.Second, &http.Client{Transport: transport})
+
+			resp, err := httpClient.Request(
+				context.TODO(),
+				"GET",
+				"127.0.0.1:8080",
+				http.Header{},
+				"",
+				nil)
+
+			// the retries are left to the retry policy of the service
+			assert.Equal(t, 1, transport.calls)
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+			if tc.failed {
+				assert.True(t, httpclient.IsConnectFailure(err))
+				return
+			}
+
+			// the response of the host is returned as it was sent
+			assert.Nil(t, err)
+			assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.Nil(t, err)
+			assert.Equal(t, "unavailable", string(body))
+		})
+	}
+}
+
+func TestRequestRemovesHopByHopHeaders(t *testing.T) {
+	mockHTTPClient := newHTTPClient(
+		func(req *http.Request) *http.Response {
+			assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+			assert.Empty(t, req.Header.Get("Connection"))
+			assert.Empty(t, req.Header.Get("Keep-Alive"))
+			assert.Empty(t, req.Header.Get("Te"))
+			assert.Empty(t, req.Header.Get("Upgrade"))
+			assert.Empty(t, req.Header.Get("X-Client-Only"))
+
+			header := http.Header{}
+			header.Set("Content-Type", "text/plain")
+			header.Add("Set-Cookie", "a=1")
+			header.Add("Set-Cookie", "b=2")
+			header.Set("ETag", `"v1"`)
+			header.Set("Connection", "close, X-Host-Only")
+			header.Set("X-Host-Only", "value")
+			header.Set("Transfer-Encoding", "chunked")
+			header.Set("Keep-Alive", "timeout=5")
+
+			return &http.Response{
+				StatusCode: http.StatusCreated,
+				Body:       ioutil.NopCloser(bytes.NewBufferString("created")),
+				Header:     header,
+			}
+		},
+		false,
+	)
+
+	httpClient := httpclient.New(log.NewNopLogger(), 5*time.Second, mockHTTPClient)
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Connection", "keep-alive, X-Client-Only")
+	header.Set("X-Client-Only", "value")
+	header.Set("Keep-Alive", "timeout=5")
+	header.Set("TE", "trailers")
+	header.Set("Upgrade", "websocket")
+
+	resp, err := httpClient.Request(
+		context.TODO(),
+		"POST",
+		"127.0.0.1:8080",
+		header,
+		"",
+		bytes.NewReader([]byte(`{}`)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Nil(t, resp.Body.Close())
+	assert.Equal(t, "created", string(body))
+	assert.Equal(t, http.Header{
+		"Content-Type": []string{"text/plain"},
+		"Set-Cookie":   []string{"a=1", "b=2"},
+		"Etag":         []string{`"v1"`},
+	}, resp.Header)
+
+	// the headers of the request are left untouched for the retries
+	assert.Equal(t, "websocket", header.Get("Upgrade"))
+}
+
+// slowReader returns its data after a delay, like a client that
+// takes its time to upload a body
+type slowReader struct {
+	data  []byte
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+
+	time.Sleep(r.delay)
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestRequestStreamsBody(t *testing.T) {
+	t.Run("sent once with its length", func(t *testing.T) {
+		transport := &countingRoundTripper{res: func() (*http.Response, error) {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+		}}
+		httpClient := httpclient.New(log.NewNopLogger(), 5*time.Second, &http.Client{Transport: transport})
+
+		header := http.Header{}
+		header.Set("Content-Length", "5")
+
+		resp, err := httpClient.Request(
+			context.TODO(),
+			"POST",
+			"127.0.0.1:8080",
+			header,
+			"",
+			io.MultiReader(strings.NewReader("hel"), strings.NewReader("lo")))
+
+		assert.NotNil(t, err)
+		assert.Nil(t, resp.Body)
+		// a streamed body can't be read again, so it is never retried
+		assert.Equal(t, 1, transport.calls)
+	})
+
+	t.Run("timeout starts once the body is sent", func(t *testing.T) {
+		var received []byte
+		var length int64
+		mockHTTPClient := newHTTPClient(
+			func(req *http.Request) *http.Response {
+				length = req.ContentLength
+				received, _ = ioutil.ReadAll(req.Body)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(bytes.NewBufferString("")),
+					Header:     make(http.Header),
+				}
+			},
+			false,
+		)
+
+		httpClient := httpclient.New(log.NewNopLogger(), 20*time.Millisecond, mockHTTPClient)
+
+		header := http.Header{}
+		header.Set("Content-Length", "5")
+
+		// the upload takes longer than the timeout
+		resp, err := httpClient.Request(
+			context.TODO(),
+			"POST",
+			"127.0.0.1:8080",
+			header,
+			"",
+			&slowReader{data: []byte("hello"), delay: 50 * time.Millisecond})
+
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello", string(received))
+		assert.Equal(t, int64(5), length)
+	})
+}
+
+func TestRequestTimeout(t *testing.T) {
+	mockHTTPClient := &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}),
+	}
+
+	httpClient := httpclient.New(log.NewNopLogger(), 20*time.Millisecond, mockHTTPClient)
+
+	resp, err := httpClient.Request(
+		httpclient.WithPreConnectionRetries(context.TODO()),
+		"GET",
+		"127.0.0.1:8080",
+		http.Header{},
+		"",
+		nil)
+
+	assert.True(t, httpclient.IsTimeout(err))
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRequestBodyCloseCancelsRequest(t *testing.T) {
+	var requestCtx context.Context
+	mockHTTPClient := newHTTPClient(
+		func(req *http.Request) *http.Response {
+			requestCtx = req.Context()
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString("streamed")),
+				Header:     make(http.Header),
+			}
+		},
+		false,
+	)
+
+	httpClient := httpclient.New(log.NewNopLogger(), 5*time.Second, mockHTTPClient)
+
+	resp, err := httpClient.Request(context.TODO(), "GET", "127.0.0.1:8080", http.Header{}, "", nil)
+	assert.Nil(t, err)
+
+	// the body is still being streamed from the host
+	assert.Nil(t, requestCtx.Err())
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "streamed", string(body))
+
+	assert.Nil(t, resp.Body.Close())
+	assert.NotNil(t, requestCtx.Err())
+}
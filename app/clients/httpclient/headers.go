@@ -0,0 +1,40 @@
+package httpclient
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hopByHopHeaders are the headers that only apply to a single connection,
+// as defined by RFC 7230, and must not be forwarded by proxies
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"TE",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopByHopHeaders returns a copy of the headers without the hop-by-hop
+// headers, including the ones that the Connection header lists
+func RemoveHopByHopHeaders(header http.Header) http.Header {
+	if header == nil {
+		return http.Header{}
+	}
+
+	header = header.Clone()
+
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+
+	return header
+}
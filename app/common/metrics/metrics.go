@@ -18,6 +18,7 @@ const metricsContextKey = "metrics_context"
 type MetricsContext struct {
 	Counters   map[string]metrics.Counter
 	Histograms map[string]metrics.Histogram
+	Gauges     map[string]metrics.Gauge
 	Namespace  string
 	Logger     gokitlog.Logger
 	mu         sync.Mutex
@@ -35,6 +36,7 @@ func New(logger gokitlog.Logger, namespace string) *MetricsContext {
 		Namespace:  namespace,
 		Counters:   make(map[string]metrics.Counter),
 		Histograms: make(map[string]metrics.Histogram),
+		Gauges:     make(map[string]metrics.Gauge),
 		Logger:     logger,
 	}
 }
@@ -129,6 +131,44 @@ func (c *MetricsContext) RecordTiming(name string, duration time.Duration, label
 	return nil
 }
 
+/** The Set function sets the current value of a gauge using the provided name and list of labels and
+ * values. Even positions on the list represent the labels and the remains the values. Any error that occurs
+ * is recovered and logged to avoid interrupting the main computation
+ */
+func Set(ctx context.Context, name string, value float64, labelValues ...string) error {
+	metrics, err := FromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to extract the MetricsContext from context.Context")
+	}
+
+	return metrics.Set(name, value, labelValues...)
+}
+
+func (c *MetricsContext) Set(name string, value float64, labelValues ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.recoverFromPanic(name)
+
+	if c.Gauges == nil {
+		c.Gauges = make(map[string]metrics.Gauge)
+	}
+
+	metric, ok := c.Gauges[name]
+	if !ok {
+		ops := stdprometheus.GaugeOpts{
+			Namespace: c.Namespace,
+			Name:      name,
+			Help:      name + " is a gauge",
+		}
+
+		metric = prometheus.NewGaugeFrom(ops, getLabels(labelValues))
+		c.Gauges[name] = metric
+	}
+
+	metric.With(labelValues...).Set(value)
+	return nil
+}
+
 type void struct{}
 
 func (c *MetricsContext) CounterNames() map[string]void {
@@ -153,6 +193,17 @@ func (c *MetricsContext) TimingNames() map[string]void {
 	return names
 }
 
+func (c *MetricsContext) GaugeNames() map[string]void {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names := make(map[string]void, len(c.Gauges))
+	for k := range c.Gauges {
+		names[k] = void{}
+	}
+	return names
+}
+
 func getLabels(lvs []string) []string {
 	length := len(lvs)
 	if length < 1 {
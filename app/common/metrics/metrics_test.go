@@ -187,6 +187,25 @@ func RecordTimingWhenErrorOccursDoNotCrash(t *testing.T) {
 	assert.NotNil(t, metricsCtx.Histograms[metricName])
 }
 
+func TestSetWhenMetricDoesntExist(t *testing.T) {
+	metricName := RandStringBytes(t, 10)
+	lvs := []string{"label_1", "1", "label_2", "2"}
+
+	metricsCtx := &metrics.MetricsContext{
+		Namespace:  "reverseproxy",
+		Counters:   make(map[string]gokitmetrics.Counter),
+		Histograms: make(map[string]gokitmetrics.Histogram),
+		Logger:     log.NewLogger(),
+	}
+
+	assert.Nil(t, metricsCtx.Gauges[metricName])
+	ctx := metrics.IntoContext(context.Background(), metricsCtx)
+	err := metrics.Set(ctx, metricName, 2, lvs...)
+	assert.Nil(t, err)
+	assert.NotNil(t, metricsCtx.Gauges[metricName])
+	assert.Contains(t, metricsCtx.GaugeNames(), metricName)
+}
+
 func RandStringBytes(t *testing.T, n int) string {
 	value, err := randStringBytes(n)
 	if err != nil {
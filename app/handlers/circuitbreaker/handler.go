@@ -0,0 +1,282 @@
+// Package circuitbreaker contains the logic that stops sending requests
+// to the downstream service hosts that fail too many of them, letting a
+// few probe requests through once in a while to find out whether the
+// hosts recovered.
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"go-reverse-proxy/app/common/metrics"
+	"go-reverse-proxy/app/values"
+)
+
+// CircuitBreakerState is the gauge with the state of the circuit of each host
+const CircuitBreakerState = "circuit_breaker_state"
+
+// States of a circuit, which are also the values of the state gauge
+const (
+	Closed   int32 = 0 // requests are sent to the host
+	HalfOpen int32 = 1 // a limited number of probe requests are sent to the host
+	Open     int32 = 2 // no requests are sent to the host
+)
+
+// number of buckets that the rolling window is split into
+const windowBuckets = 10
+
+type Handler interface {
+	// Allow checks if a request can be sent to a host of a service,
+	// taking one of the probe slots of the host when its circuit is
+	// half-open. Every allowed request must be followed by a Record.
+	Allow(
+		ctx context.Context,
+		service *values.Service,
+		host *values.Host,
+	) bool
+	// Record receives the result of a request sent to a host of a service
+	// and opens the circuit of the host when the failure rate gets too
+	// high, or closes it when the probes of a half-open circuit succeed.
+	Record(
+		ctx context.Context,
+		service *values.Service,
+		host *values.Host,
+		statusCode int,
+		err error,
+	)
+}
+
+type DefaultHandler struct {
+	logger     log.Logger
+	metricsCtx *metrics.MetricsContext
+
+	// map of *values.Host to the *breaker of the host
+	breakers sync.Map
+}
+
+// breaker keeps the state of the circuit of a host. The state is read
+// atomically so that closed circuits, which are the common case, let
+// requests through without taking the lock.
+type breaker struct {
+	state int32
+
+	mu        sync.Mutex
+	buckets   [windowBuckets]bucket
+	probes    int // probes let through since the circuit became half-open
+	successes int // probes that succeeded since then
+}
+
+// bucket counts the requests of a slice of the rolling window
+type bucket struct {
+	slot     int64 // slice of time that the counts belong to
+	requests int
+	failures int
+}
+
+func New(
+	logger log.Logger,
+	metricsCtx *metrics.MetricsContext,
+) Handler {
+	var svc Handler
+	svc = &DefaultHandler{
+		logger:     logger,
+		metricsCtx: metricsCtx,
+	}
+
+	return svc
+}
+
+func (h *DefaultHandler) Allow(
+	ctx context.Context,
+	service *values.Service,
+	host *values.Host,
+) bool {
+	circuitBreaker := service.CircuitBreaker
+	if circuitBreaker == nil {
+		return true
+	}
+
+	breaker := h.breaker(host)
+
+	switch atomic.LoadInt32(&breaker.state) {
+	case Closed:
+		return true
+	case Open:
+		return false
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	// the circuit may have changed while waiting for the lock
+	state := atomic.LoadInt32(&breaker.state)
+	if state != HalfOpen {
+		return state == Closed
+	}
+
+	if breaker.probes >= circuitBreaker.HalfOpenRequests {
+		return false
+	}
+
+	breaker.probes++
+	if breaker.probes == circuitBreaker.HalfOpenRequests {
+		// keep the load balancer away until the probes report back
+		host.SetCircuitOpen(true)
+	}
+
+	return true
+}
+
+func (h *DefaultHandler) Record(
+	ctx context.Context,
+	service *values.Service,
+	host *values.Host,
+	statusCode int,
+	err error,
+) {
+	circuitBreaker := service.CircuitBreaker
+	if circuitBreaker == nil {
+		return
+	}
+
+	breaker := h.breaker(host)
+	failed := err != nil || statusCode >= http.StatusInternalServerError
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	// a cancelled request says nothing about the host, but if it
+	// was a probe its slot is given back to the next request
+	if errors.Is(err, context.Canceled) {
+		if atomic.LoadInt32(&breaker.state) == HalfOpen && breaker.probes > 0 {
+			breaker.probes--
+			host.SetCircuitOpen(false)
+		}
+		return
+	}
+
+	switch atomic.LoadInt32(&breaker.state) {
+	case Closed:
+		requests, failures := breaker.add(circuitBreaker.Window, failed)
+		if requests >= circuitBreaker.MinimumRequests && failures*100 >= circuitBreaker.FailureRateThreshold*requests {
+			h.open(service, host, breaker)
+		}
+	case HalfOpen:
+		if failed {
+			h.open(service, host, breaker)
+			return
+		}
+
+		breaker.successes++
+		if breaker.successes >= circuitBreaker.HalfOpenRequests {
+			h.close(service, host, breaker)
+		}
+	}
+
+	// requests that were in flight when the circuit opened are ignored
+}
+
+// open stops the requests to the host until the open time passes,
+// the breaker lock must be held
+func (h *DefaultHandler) open(
+	service *values.Service,
+	host *values.Host,
+	breaker *breaker,
+) {
+	atomic.StoreInt32(&breaker.state, Open)
+	host.SetCircuitOpen(true)
+	breaker.buckets = [windowBuckets]bucket{}
+
+	h.logger.Log("module", "circuitbreaker", "service", service.Name, "host", host.ToURL(), "circuit", "open")
+	h.set(service, host, Open)
+
+	time.AfterFunc(service.CircuitBreaker.OpenTime, func() {
+		h.halfOpen(service, host, breaker)
+	})
+}
+
+// halfOpen lets the probes through to find out if the host recovered
+func (h *DefaultHandler) halfOpen(
+	service *values.Service,
+	host *values.Host,
+	breaker *breaker,
+) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	atomic.StoreInt32(&breaker.state, HalfOpen)
+	breaker.probes = 0
+	breaker.successes = 0
+	host.SetCircuitOpen(false)
+
+	h.logger.Log("module", "circuitbreaker", "service", service.Name, "host", host.ToURL(), "circuit", "half-open")
+	h.set(service, host, HalfOpen)
+}
+
+// close resumes the requests to the host, the breaker lock must be held
+func (h *DefaultHandler) close(
+	service *values.Service,
+	host *values.Host,
+	breaker *breaker,
+) {
+	atomic.StoreInt32(&breaker.state, Closed)
+	host.SetCircuitOpen(false)
+
+	h.logger.Log("module", "circuitbreaker", "service", service.Name, "host", host.ToURL(), "circuit", "closed")
+	h.set(service, host, Closed)
+}
+
+// add counts a request in the rolling window and returns the requests
+// and failures within the window, the breaker lock must be held
+func (b *breaker) add(window time.Duration, failed bool) (int, int) {
+	width := int64(window) / windowBuckets
+	if width == 0 {
+		width = 1
+	}
+
+	slot := time.Now().UnixNano() / width
+
+	current := &b.buckets[slot%windowBuckets]
+	if current.slot != slot {
+		*current = bucket{slot: slot}
+	}
+
+	current.requests++
+	if failed {
+		current.failures++
+	}
+
+	var requests, failures int
+	for _, counts := range b.buckets {
+		if counts.slot > slot-windowBuckets {
+			requests += counts.requests
+			failures += counts.failures
+		}
+	}
+
+	return requests, failures
+}
+
+// breaker returns the circuit breaker of a host, creating
+// it the first time that it is needed
+func (h *DefaultHandler) breaker(host *values.Host) *breaker {
+	if b, ok := h.breakers.Load(host); ok {
+		return b.(*breaker)
+	}
+
+	b, _ := h.breakers.LoadOrStore(host, &breaker{})
+	return b.(*breaker)
+}
+
+func (h *DefaultHandler) set(service *values.Service, host *values.Host, state int32) {
+	lvs := []string{"service", service.Name, "host", host.ToURL()}
+	if err := h.metricsCtx.Set(CircuitBreakerState, float64(state), lvs...); err != nil {
+		h.logger.Log("metrics", CircuitBreakerState, "service", service.Name, "err", err)
+	}
+}
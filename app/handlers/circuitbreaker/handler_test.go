@@ -0,0 +1,165 @@
+package circuitbreaker_test
+
+import (
+	"context"
+	"fmt"
+	"go-reverse-proxy/app/common/log"
+	"go-reverse-proxy/app/common/metrics"
+	"go-reverse-proxy/app/handlers/circuitbreaker"
+	"go-reverse-proxy/app/values"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newService(circuitBreaker *values.CircuitBreaker) *values.Service {
+	return &values.Service{
+		Name:           "my-service",
+		Domain:         "my-domain.com",
+		CircuitBreaker: circuitBreaker,
+		Hosts: []*values.Host{
+			{
+				Address: "127.0.0.1",
+				Port:    5000,
+				Weight:  1,
+			},
+		},
+	}
+}
+
+func newCircuitBreaker(openTime time.Duration) *values.CircuitBreaker {
+	return &values.CircuitBreaker{
+		FailureRateThreshold: 50,
+		MinimumRequests:      4,
+		Window:               time.Minute,
+		OpenTime:             openTime,
+		HalfOpenRequests:     2,
+	}
+}
+
+func record(handler circuitbreaker.Handler, service *values.Service, statusCode int, count int) {
+	for i := 0; i < count; i++ {
+		handler.Record(context.Background(), service, service.Hosts[0], statusCode, nil)
+	}
+}
+
+func TestRecordOpensCircuitAboveFailureRate(t *testing.T) {
+	metricsCtx := metrics.New(log.NewNopLogger(), "test")
+	handler := circuitbreaker.New(log.NewNopLogger(), metricsCtx)
+	service := newService(newCircuitBreaker(time.Minute))
+	host := service.Hosts[0]
+
+	record(handler, service, http.StatusOK, 2)
+	record(handler, service, http.StatusBadGateway, 1)
+	assert.True(t, handler.Allow(context.Background(), service, host))
+
+	handler.Record(context.Background(), service, host, 0, fmt.Errorf("connection refused"))
+
+	assert.True(t, host.IsCircuitOpen())
+	assert.False(t, handler.Allow(context.Background(), service, host))
+	assert.Contains(t, metricsCtx.GaugeNames(), circuitbreaker.CircuitBreakerState)
+}
+
+func TestRecordNeedsMinimumRequests(t *testing.T) {
+	handler := circuitbreaker.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(newCircuitBreaker(time.Minute))
+
+	record(handler, service, http.StatusInternalServerError, 3)
+
+	assert.False(t, service.Hosts[0].IsCircuitOpen())
+	assert.True(t, handler.Allow(context.Background(), service, service.Hosts[0]))
+}
+
+func TestRecordForgetsRequestsOutsideWindow(t *testing.T) {
+	circuitBreaker := newCircuitBreaker(time.Minute)
+	circuitBreaker.Window = 50 * time.Millisecond
+
+	handler := circuitbreaker.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(circuitBreaker)
+
+	record(handler, service, http.StatusInternalServerError, 3)
+	time.Sleep(100 * time.Millisecond)
+	record(handler, service, http.StatusInternalServerError, 1)
+
+	assert.False(t, service.Hosts[0].IsCircuitOpen())
+}
+
+func TestHalfOpenLimitsProbes(t *testing.T) {
+	handler := circuitbreaker.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(newCircuitBreaker(20 * time.Millisecond))
+	host := service.Hosts[0]
+
+	record(handler, service, http.StatusInternalServerError, 4)
+	assert.Eventually(t, func() bool { return !host.IsCircuitOpen() }, time.Second, time.Millisecond)
+
+	assert.True(t, handler.Allow(context.Background(), service, host))
+	assert.True(t, handler.Allow(context.Background(), service, host))
+	assert.False(t, handler.Allow(context.Background(), service, host))
+
+	// the load balancer skips the host while the probes are in flight
+	assert.True(t, host.IsCircuitOpen())
+}
+
+func TestHalfOpenClosesAfterSuccessfulProbes(t *testing.T) {
+	handler := circuitbreaker.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(newCircuitBreaker(20 * time.Millisecond))
+	host := service.Hosts[0]
+
+	record(handler, service, http.StatusInternalServerError, 4)
+	assert.Eventually(t, func() bool { return !host.IsCircuitOpen() }, time.Second, time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		assert.True(t, handler.Allow(context.Background(), service, host))
+		handler.Record(context.Background(), service, host, http.StatusOK, nil)
+	}
+
+	assert.False(t, host.IsCircuitOpen())
+	for i := 0; i < 10; i++ {
+		assert.True(t, handler.Allow(context.Background(), service, host))
+	}
+}
+
+func TestHalfOpenReopensAfterFailedProbe(t *testing.T) {
+	handler := circuitbreaker.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(newCircuitBreaker(50 * time.Millisecond))
+	host := service.Hosts[0]
+
+	record(handler, service, http.StatusInternalServerError, 4)
+	assert.Eventually(t, func() bool { return !host.IsCircuitOpen() }, time.Second, time.Millisecond)
+
+	assert.True(t, handler.Allow(context.Background(), service, host))
+	handler.Record(context.Background(), service, host, http.StatusServiceUnavailable, nil)
+
+	assert.True(t, host.IsCircuitOpen())
+	assert.False(t, handler.Allow(context.Background(), service, host))
+}
+
+func TestAllowWithoutCircuitBreaker(t *testing.T) {
+	handler := circuitbreaker.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(nil)
+
+	record(handler, service, http.StatusInternalServerError, 100)
+
+	assert.False(t, service.Hosts[0].IsCircuitOpen())
+	assert.True(t, handler.Allow(context.Background(), service, service.Hosts[0]))
+}
+
+func TestHalfOpenCancelledProbeGivesBackItsSlot(t *testing.T) {
+	handler := circuitbreaker.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(newCircuitBreaker(20 * time.Millisecond))
+	host := service.Hosts[0]
+
+	record(handler, service, http.StatusInternalServerError, 4)
+	assert.Eventually(t, func() bool { return !host.IsCircuitOpen() }, time.Second, time.Millisecond)
+
+	assert.True(t, handler.Allow(context.Background(), service, host))
+	assert.True(t, handler.Allow(context.Background(), service, host))
+	assert.True(t, host.IsCircuitOpen())
+
+	handler.Record(context.Background(), service, host, http.StatusInternalServerError, context.Canceled)
+
+	assert.False(t, host.IsCircuitOpen())
+	assert.True(t, handler.Allow(context.Background(), service, host))
+}
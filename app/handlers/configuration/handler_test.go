@@ -32,14 +32,18 @@ func TestParseYamlData(t *testing.T) {
 					{
 						Address: "10.0.0.1",
 						Port:    9090,
+						Weight:  1,
 					},
 					{
 						Address: "10.0.0.2",
 						Port:    9090,
+						Weight:  1,
 					},
 				},
+				LoadBalancer: values.RoundRobin,
 			},
 		},
+		PathPrefix: values.DefaultPathPrefix,
 	}
 
 	config, _ := config.ParseYamlData(mockFiledata)
@@ -0,0 +1,154 @@
+// Package healthcheck contains the logic that actively probes the hosts
+// of the downstream services, so that the load balancer stops sending
+// requests to an unhealthy instance before a client request fails on it.
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"go-reverse-proxy/app/values"
+)
+
+type Handler interface {
+	// Run periodically checks the health of the hosts of every service
+	// that has a health check configured, marking them as healthy or
+	// unhealthy. It blocks until the context is cancelled.
+	Run(ctx context.Context) error
+}
+
+type DefaultHandler struct {
+	logger        log.Logger
+	configuration values.Configuration
+	httpClient    *http.Client
+}
+
+func New(
+	logger log.Logger,
+	configuration values.Configuration,
+	httpClient *http.Client,
+) Handler {
+	// This detail is used to inject a Mockable HTTP client during tests
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+
+	var svc Handler
+	svc = &DefaultHandler{
+		logger:        logger,
+		configuration: configuration,
+		httpClient:    httpClient,
+	}
+
+	return svc
+}
+
+func (h *DefaultHandler) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	for _, service := range h.configuration.Services {
+		if service.HealthCheck == nil {
+			continue
+		}
+
+		for _, part := range append([]*values.Service{service}, service.Pools...) {
+			for _, host := range part.Hosts {
+				wg.Add(1)
+				go func(service *values.Service, host *values.Host) {
+					defer wg.Done()
+					h.checkHost(ctx, service, host)
+				}(part, host)
+			}
+		}
+	}
+
+	<-ctx.Done()
+	wg.Wait()
+
+	return nil
+}
+
+// checkHost probes a host on every interval of the service health check.
+// The health of the host only changes after the configured number of
+// consecutive probes agree, so a single slow reply doesn't flap it.
+func (h *DefaultHandler) checkHost(
+	ctx context.Context,
+	service *values.Service,
+	host *values.Host,
+) {
+	healthCheck := service.HealthCheck
+
+	ticker := time.NewTicker(healthCheck.Interval)
+	defer ticker.Stop()
+
+	var successes, failures int
+	for {
+		err := h.probe(ctx, healthCheck, host)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			successes++
+			failures = 0
+		} else {
+			failures++
+			successes = 0
+		}
+
+		switch {
+		case !host.IsHealthy() && successes >= healthCheck.HealthyThreshold:
+			host.SetHealthy(true)
+			h.logger.Log("module", "healthcheck", "service", service.Name, "host", host.ToURL(), "healthy", true)
+		case host.IsHealthy() && failures >= healthCheck.UnhealthyThreshold:
+			host.SetHealthy(false)
+			h.logger.Log("module", "healthcheck", "service", service.Name, "host", host.ToURL(), "healthy", false, "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe requests the health check path of a host, returning an error
+// when the host doesn't reply in time or replies with an unexpected status
+func (h *DefaultHandler) probe(
+	ctx context.Context,
+	healthCheck *values.HealthCheck,
+	host *values.Host,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheck.Timeout)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s%s", host.ToURL(), healthCheck.Path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// drain the body so that the connection can be reused by the next probe
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+
+	if !healthCheck.IsExpectedStatus(res.StatusCode) {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package healthcheck_test
+
+import (
+	"context"
+	"go-reverse-proxy/app/common/log"
+	"go-reverse-proxy/app/handlers/healthcheck"
+	"go-reverse-proxy/app/values"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newHealthCheck() *values.HealthCheck {
+	return &values.HealthCheck{
+		Path:               "/health",
+		Interval:           5 * time.Millisecond,
+		Timeout:            50 * time.Millisecond,
+		ExpectedStatusMin:  200,
+		ExpectedStatusMax:  299,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	}
+}
+
+func newHost(t *testing.T, address string) *values.Host {
+	host, port, err := net.SplitHostPort(address)
+	assert.Nil(t, err)
+
+	portNumber, err := strconv.Atoi(port)
+	assert.Nil(t, err)
+
+	return &values.Host{
+		Address: host,
+		Port:    int32(portNumber),
+		Weight:  1,
+	}
+}
+
+// runHealthCheck starts the health checker for a service with the given
+// host, returning a function that stops it and waits for it to return
+func runHealthCheck(t *testing.T, host *values.Host) func() {
+	configuration := values.Configuration{
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:        "my-service",
+				Domain:      "my-domain.com",
+				Hosts:       []*values.Host{host},
+				HealthCheck: newHealthCheck(),
+			},
+		},
+	}
+
+	handler := healthcheck.New(log.NewNopLogger(), configuration, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+
+	return func() {
+		cancel()
+		select {
+		case err := <-done:
+			assert.Nil(t, err)
+		case <-time.After(time.Second):
+			assert.Fail(t, "health checker did not stop")
+		}
+	}
+}
+
+func TestRunMarksHostUnhealthyAndRecovers(t *testing.T) {
+	var statusCode int32 = http.StatusInternalServerError
+	var paths atomic.Value
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths.Store(r.URL.Path)
+		w.WriteHeader(int(atomic.LoadInt32(&statusCode)))
+	}))
+	defer server.Close()
+
+	host := newHost(t, server.Listener.Addr().String())
+	stop := runHealthCheck(t, host)
+	defer stop()
+
+	assert.Eventually(t, func() bool { return !host.IsHealthy() }, time.Second, time.Millisecond)
+	assert.Equal(t, "/health", paths.Load())
+
+	atomic.StoreInt32(&statusCode, http.StatusOK)
+
+	assert.Eventually(t, host.IsHealthy, time.Second, time.Millisecond)
+}
+
+func TestRunUnreachableHost(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	host := newHost(t, address)
+	stop := runHealthCheck(t, host)
+	defer stop()
+
+	assert.Eventually(t, func() bool { return !host.IsHealthy() }, time.Second, time.Millisecond)
+}
+
+func TestRunKeepsHealthyHost(t *testing.T) {
+	var probes int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	host := newHost(t, server.Listener.Addr().String())
+	stop := runHealthCheck(t, host)
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&probes) >= 5 }, time.Second, time.Millisecond)
+	stop()
+
+	assert.True(t, host.IsHealthy())
+}
+
+func TestRunWithoutHealthChecks(t *testing.T) {
+	configuration := values.Configuration{
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+				},
+			},
+		},
+	}
+
+	handler := healthcheck.New(log.NewNopLogger(), configuration, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	assert.Nil(t, handler.Run(ctx))
+	assert.True(t, configuration.Services["my-domain.com"].Hosts[0].IsHealthy())
+}
@@ -0,0 +1,188 @@
+// Package hedging contains the logic that decides when a copy of a slow
+// request is sent to a second host of a downstream service, to cut the
+// tail latency of the requests without doubling the load of the hosts.
+package hedging
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"go-reverse-proxy/app/common/metrics"
+	"go-reverse-proxy/app/values"
+)
+
+const HedgedRequests = "hedged_requests"
+
+const (
+	// latencies kept to compute the percentile delay
+	latencySamples = 200
+	// latencies observed before the percentile delay is trusted, and
+	// between two computations of it
+	latencyRefresh = 50
+	// hedges that can be sent in a row after a period without any
+	hedgeBurst = 10
+)
+
+type Handler interface {
+	// Delay returns how long to wait for a host of a service to answer
+	// before hedging the request, and counts the request towards the
+	// hedging budget of the service. It returns false when the service
+	// doesn't hedge requests.
+	Delay(ctx context.Context, service *values.Service) (time.Duration, bool)
+	// Allow takes a hedge from the budget of a service,
+	// returning false when the budget is exhausted
+	Allow(ctx context.Context, service *values.Service) bool
+	// Observe records the latency of a request answered by a host of
+	// a service, from which the percentile delay is derived
+	Observe(ctx context.Context, service *values.Service, latency time.Duration)
+}
+
+type DefaultHandler struct {
+	logger     log.Logger
+	metricsCtx *metrics.MetricsContext
+
+	// map of *values.Service to the *serviceHedging of the service
+	states sync.Map
+}
+
+// serviceHedging keeps the budget and latencies of a service. The budget
+// is a token bucket where each request adds a share of a hedge and each
+// hedge takes a whole one, which is counted in hundredths of a hedge so
+// that it can be updated atomically.
+type serviceHedging struct {
+	tokens int64 // hundredths of a hedge, accessed atomically
+	delay  int64 // percentile delay in nanoseconds, accessed atomically
+
+	mu        sync.Mutex
+	latencies [latencySamples]time.Duration
+	observed  int
+}
+
+func New(
+	logger log.Logger,
+	metricsCtx *metrics.MetricsContext,
+) Handler {
+	var svc Handler
+	svc = &DefaultHandler{
+		logger:     logger,
+		metricsCtx: metricsCtx,
+	}
+
+	return svc
+}
+
+func (h *DefaultHandler) Delay(
+	ctx context.Context,
+	service *values.Service,
+) (time.Duration, bool) {
+	hedging := service.Hedging
+	if hedging == nil {
+		return 0, false
+	}
+
+	state := h.serviceHedging(service)
+
+	for {
+		tokens := atomic.LoadInt64(&state.tokens)
+		if tokens >= hedgeBurst*100 {
+			break
+		}
+
+		if atomic.CompareAndSwapInt64(&state.tokens, tokens, tokens+int64(hedging.BudgetPercent)) {
+			break
+		}
+	}
+
+	if delay := atomic.LoadInt64(&state.delay); hedging.DelayPercentile > 0 && delay > 0 {
+		return time.Duration(delay), true
+	}
+
+	return hedging.Delay, true
+}
+
+func (h *DefaultHandler) Allow(
+	ctx context.Context,
+	service *values.Service,
+) bool {
+	if service.Hedging == nil {
+		return false
+	}
+
+	state := h.serviceHedging(service)
+
+	for {
+		tokens := atomic.LoadInt64(&state.tokens)
+		if tokens < 100 {
+			return false
+		}
+
+		if atomic.CompareAndSwapInt64(&state.tokens, tokens, tokens-100) {
+			break
+		}
+	}
+
+	if err := h.metricsCtx.Record(HedgedRequests, 1, "service", service.Name); err != nil {
+		h.logger.Log("metrics", HedgedRequests, "service", service.Name, "err", err)
+	}
+
+	return true
+}
+
+func (h *DefaultHandler) Observe(
+	ctx context.Context,
+	service *values.Service,
+	latency time.Duration,
+) {
+	hedging := service.Hedging
+	if hedging == nil || hedging.DelayPercentile == 0 {
+		return
+	}
+
+	state := h.serviceHedging(service)
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.latencies[state.observed%latencySamples] = latency
+	state.observed++
+
+	if state.observed%latencyRefresh == 0 {
+		atomic.StoreInt64(&state.delay, int64(state.percentile(hedging.DelayPercentile)))
+	}
+}
+
+// percentile returns the latency below which the given percentage
+// of the kept latencies fall, the state lock must be held
+func (s *serviceHedging) percentile(percentile int) time.Duration {
+	count := s.observed
+	if count > latencySamples {
+		count = latencySamples
+	}
+
+	sorted := make([]time.Duration, count)
+	copy(sorted, s.latencies[:count])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	index := (count*percentile+99)/100 - 1
+	if index < 0 {
+		index = 0
+	}
+
+	return sorted[index]
+}
+
+// serviceHedging returns the hedging state of a service,
+// creating it the first time that it is needed
+func (h *DefaultHandler) serviceHedging(service *values.Service) *serviceHedging {
+	if state, ok := h.states.Load(service); ok {
+		return state.(*serviceHedging)
+	}
+
+	state, _ := h.states.LoadOrStore(service, &serviceHedging{})
+	return state.(*serviceHedging)
+}
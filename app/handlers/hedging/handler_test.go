@@ -0,0 +1,108 @@
+package hedging_test
+
+import (
+	"context"
+	"go-reverse-proxy/app/common/log"
+	"go-reverse-proxy/app/common/metrics"
+	"go-reverse-proxy/app/handlers/hedging"
+	"go-reverse-proxy/app/values"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newService(hedging *values.Hedging) *values.Service {
+	return &values.Service{
+		Name:    "my-service",
+		Domain:  "my-domain.com",
+		Hedging: hedging,
+	}
+}
+
+func TestDelayWithoutHedging(t *testing.T) {
+	handler := hedging.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(nil)
+
+	_, ok := handler.Delay(context.Background(), service)
+
+	assert.False(t, ok)
+	assert.False(t, handler.Allow(context.Background(), service))
+}
+
+func TestDelayFixed(t *testing.T) {
+	handler := hedging.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(&values.Hedging{
+		Delay:         20 * time.Millisecond,
+		BudgetPercent: 10,
+	})
+
+	for i := 0; i < 100; i++ {
+		handler.Observe(context.Background(), service, time.Second)
+	}
+
+	delay, ok := handler.Delay(context.Background(), service)
+
+	assert.True(t, ok)
+	assert.Equal(t, 20*time.Millisecond, delay)
+}
+
+func TestDelayPercentile(t *testing.T) {
+	handler := hedging.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(&values.Hedging{
+		Delay:           20 * time.Millisecond,
+		DelayPercentile: 95,
+		BudgetPercent:   10,
+	})
+
+	// the fixed delay is used until enough latencies are observed
+	delay, _ := handler.Delay(context.Background(), service)
+	assert.Equal(t, 20*time.Millisecond, delay)
+
+	for i := 1; i <= 100; i++ {
+		handler.Observe(context.Background(), service, time.Duration(i)*time.Millisecond)
+	}
+
+	delay, _ = handler.Delay(context.Background(), service)
+	assert.Equal(t, 95*time.Millisecond, delay)
+}
+
+func TestAllowBudget(t *testing.T) {
+	metricsCtx := metrics.New(log.NewNopLogger(), "test")
+	handler := hedging.New(log.NewNopLogger(), metricsCtx)
+	service := newService(&values.Hedging{
+		Delay:         20 * time.Millisecond,
+		BudgetPercent: 25,
+	})
+
+	var hedges int
+	for i := 0; i < 40; i++ {
+		_, _ = handler.Delay(context.Background(), service)
+		if handler.Allow(context.Background(), service) {
+			hedges++
+		}
+	}
+
+	assert.Equal(t, 10, hedges)
+	assert.Contains(t, metricsCtx.CounterNames(), hedging.HedgedRequests)
+}
+
+func TestAllowBudgetBurst(t *testing.T) {
+	handler := hedging.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(&values.Hedging{
+		Delay:         20 * time.Millisecond,
+		BudgetPercent: 100,
+	})
+
+	// a long period without hedges only saves a few of them
+	for i := 0; i < 1000; i++ {
+		_, _ = handler.Delay(context.Background(), service)
+	}
+
+	var hedges int
+	for handler.Allow(context.Background(), service) {
+		hedges++
+	}
+
+	assert.Equal(t, 10, hedges)
+}
@@ -0,0 +1,107 @@
+package loadbalancing
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	"go-reverse-proxy/app/values"
+)
+
+// number of points that each unit of host weight places on the hash ring,
+// more points give a more even distribution of the keys between hosts
+const ringPointsPerWeight = 160
+
+// ring is a consistent hash ring where every host owns the keys that hash
+// between its points and the points of the previous host. Adding or
+// removing one of N hosts only moves the keys of that host, roughly 1/N
+// of the total, while the remaining keys keep their host.
+type ring struct {
+	points []uint64       // sorted hashes of the ring points
+	hosts  []*values.Host // host that owns the point at the same index
+}
+
+func newRing(hosts []*values.Host) *ring {
+	type point struct {
+		hash uint64
+		host *values.Host
+	}
+
+	var points []point
+	for _, host := range hosts {
+		weight := int(host.Weight)
+		if weight < 1 {
+			weight = 1
+		}
+
+		for i := 0; i < weight*ringPointsPerWeight; i++ {
+			points = append(points, point{
+				hash: hash(host.ToURL() + "#" + strconv.Itoa(i)),
+				host: host,
+			})
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	r := &ring{
+		points: make([]uint64, len(points)),
+		hosts:  make([]*values.Host, len(points)),
+	}
+	for i, point := range points {
+		r.points[i] = point.hash
+		r.hosts[i] = point.host
+	}
+
+	return r
+}
+
+// get returns the host owning the first point at or after the key hash.
+// When that host is rejected by the filter, the ring is walked clockwise
+// until a host that is accepted is found, so only the keys of rejected
+// hosts move while the others keep their host.
+func (r *ring) get(key string, filter hostFilter) *values.Host {
+	keyHash := hash(key)
+
+	start := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= keyHash
+	})
+
+	for offset := 0; offset < len(r.points); offset++ {
+		host := r.hosts[(start+offset)%len(r.points)]
+		if filter(host) {
+			return host
+		}
+	}
+
+	return nil
+}
+
+// nextHashed returns the host that owns the hash key of the request.
+// Requests without a hash key can go to any host, so they are balanced
+// with Round-Robin instead of all landing on the same host.
+func (s *serviceState) nextHashed(request *values.Request, filter hostFilter) *values.Host {
+	key := s.hashKey.Value(request)
+	if key == "" {
+		return s.nextRoundRobin(filter)
+	}
+
+	return s.ring.get(key, filter)
+}
+
+// hash computes the FNV-1a hash of a key and applies the SplitMix64
+// finalizer, since FNV alone spreads similar keys poorly along the ring
+func hash(key string) uint64 {
+	hasher := fnv.New64a()
+	_, _ = hasher.Write([]byte(key))
+
+	x := hasher.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,192 @@
+package loadbalancing_test
+
+import (
+	"context"
+	"fmt"
+	"go-reverse-proxy/app/common/log"
+	"go-reverse-proxy/app/handlers/loadbalancing"
+	"go-reverse-proxy/app/values"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const totalHashKeys = 30000
+
+func newHashedService(hashKey values.HashKey, ports ...int32) *values.Service {
+	var hosts []*values.Host
+	for _, port := range ports {
+		hosts = append(hosts, &values.Host{
+			Address: "10.0.0.1",
+			Port:    port,
+			Weight:  1,
+		})
+	}
+
+	return &values.Service{
+		Name:         "my-service",
+		Domain:       "my-domain.com",
+		LoadBalancer: values.ConsistentHash,
+		HashKey:      hashKey,
+		Hosts:        hosts,
+	}
+}
+
+func newUserRequest(user int) *values.Request {
+	header := http.Header{}
+	header.Set("X-User-Id", fmt.Sprintf("user-%d", user))
+
+	return &values.Request{
+		Method: "GET",
+		Header: header,
+	}
+}
+
+// hashedPorts returns the port of the host chosen for each user
+func hashedPorts(handler loadbalancing.Handler, service *values.Service) []int32 {
+	ports := make([]int32, totalHashKeys)
+	for user := 0; user < totalHashKeys; user++ {
+		host := handler.NextHost(context.Background(), service, newUserRequest(user))
+		ports[user] = host.Port
+	}
+
+	return ports
+}
+
+func TestNextHostHashedIsSticky(t *testing.T) {
+	handler := loadbalancing.New(log.NewNopLogger())
+	service := newHashedService(
+		values.HashKey{Source: values.HashKeyHeader, Name: "X-User-Id"},
+		5000, 5001, 5002,
+	)
+
+	first := handler.NextHost(context.Background(), service, newUserRequest(42))
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, first, handler.NextHost(context.Background(), service, newUserRequest(42)))
+	}
+}
+
+func TestNextHostHashedKeySources(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-User-Id", "user-1")
+	header.Set("Cookie", "session=user-1")
+
+	request := &values.Request{
+		Method:     "GET",
+		Header:     header,
+		Parameters: "user=user-1&page=2",
+		RemoteAddr: "192.168.1.10:52341",
+	}
+
+	testCases := []struct {
+		name    string
+		hashKey values.HashKey
+		other   *values.Request
+	}{
+		{
+			name:    "client ip",
+			hashKey: values.HashKey{Source: values.HashKeyClientIP},
+			other:   &values.Request{RemoteAddr: "192.168.1.10:60000"},
+		},
+		{
+			name:    "header",
+			hashKey: values.HashKey{Source: values.HashKeyHeader, Name: "X-User-Id"},
+			other:   &values.Request{Header: http.Header{"X-User-Id": []string{"user-1"}}},
+		},
+		{
+			name:    "cookie",
+			hashKey: values.HashKey{Source: values.HashKeyCookie, Name: "session"},
+			other:   &values.Request{Header: http.Header{"Cookie": []string{"theme=dark; session=user-1"}}},
+		},
+		{
+			name:    "query",
+			hashKey: values.HashKey{Source: values.HashKeyQuery, Name: "user"},
+			other:   &values.Request{Parameters: "user=user-1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := loadbalancing.New(log.NewNopLogger())
+			service := newHashedService(tc.hashKey, 5000, 5001, 5002, 5003, 5004)
+
+			expected := handler.NextHost(context.Background(), service, request)
+			for i := 0; i < 10; i++ {
+				assert.Equal(t, expected, handler.NextHost(context.Background(), service, tc.other))
+			}
+		})
+	}
+}
+
+func TestNextHostHashedWithoutKeyUsesRoundRobin(t *testing.T) {
+	handler := loadbalancing.New(log.NewNopLogger())
+	service := newHashedService(
+		values.HashKey{Source: values.HashKeyHeader, Name: "X-User-Id"},
+		5000, 5001, 5002,
+	)
+
+	var ports []int32
+	for i := 0; i < 4; i++ {
+		host := handler.NextHost(context.Background(), service, &values.Request{Header: http.Header{}})
+		ports = append(ports, host.Port)
+	}
+
+	assert.Equal(t, []int32{5000, 5001, 5002, 5000}, ports)
+}
+
+func TestNextHostHashedDistribution(t *testing.T) {
+	handler := loadbalancing.New(log.NewNopLogger())
+	service := newHashedService(
+		values.HashKey{Source: values.HashKeyHeader, Name: "X-User-Id"},
+		5000, 5001, 5002,
+	)
+
+	counts := make(map[int32]int)
+	for _, port := range hashedPorts(handler, service) {
+		counts[port]++
+	}
+
+	for _, port := range []int32{5000, 5001, 5002} {
+		assert.InDelta(t, totalHashKeys/3, counts[port], totalHashKeys/3*0.15)
+	}
+}
+
+func TestNextHostHashedAddingHostRemapsFewKeys(t *testing.T) {
+	handler := loadbalancing.New(log.NewNopLogger())
+	hashKey := values.HashKey{Source: values.HashKeyHeader, Name: "X-User-Id"}
+
+	before := hashedPorts(handler, newHashedService(hashKey, 5000, 5001, 5002))
+	after := hashedPorts(handler, newHashedService(hashKey, 5000, 5001, 5002, 5003))
+
+	var remapped int
+	for user := range before {
+		if before[user] != after[user] {
+			remapped++
+			// keys may only move to the new host
+			assert.Equal(t, int32(5003), after[user])
+		}
+	}
+
+	// roughly 1/4 of the keys should move to the new host
+	assert.InDelta(t, 0.25, float64(remapped)/totalHashKeys, 0.05)
+}
+
+func TestNextHostHashedRemovingHostRemapsFewKeys(t *testing.T) {
+	handler := loadbalancing.New(log.NewNopLogger())
+	hashKey := values.HashKey{Source: values.HashKeyHeader, Name: "X-User-Id"}
+
+	before := hashedPorts(handler, newHashedService(hashKey, 5000, 5001, 5002, 5003))
+	after := hashedPorts(handler, newHashedService(hashKey, 5000, 5001, 5002))
+
+	var remapped int
+	for user := range before {
+		if before[user] != after[user] {
+			remapped++
+			// only the keys of the removed host may move
+			assert.Equal(t, int32(5003), before[user])
+		}
+	}
+
+	assert.InDelta(t, 0.25, float64(remapped)/totalHashKeys, 0.05)
+}
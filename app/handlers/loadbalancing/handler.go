@@ -5,18 +5,39 @@ package loadbalancing
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"go-reverse-proxy/app/values"
 )
 
 type Handler interface {
-	// SetNextHost chooses the next host to request to
-	SetNextHost(ctx context.Context, service *values.Service)
+	// NextHost chooses the host of the service that should receive
+	// the request, returning nil when the service has no hosts
+	NextHost(ctx context.Context, service *values.Service, request *values.Request) *values.Host
+	// NextHostExcluding chooses a host of the service other than the
+	// excluded one, returning nil when no other host is available
+	NextHostExcluding(ctx context.Context, service *values.Service, request *values.Request, excluded *values.Host) *values.Host
+	// RequestStarted must be called right before a request is sent to
+	// one of the service hosts, so that load aware algorithms can keep
+	// track of the requests in flight
+	RequestStarted(ctx context.Context, service *values.Service, host *values.Host)
+	// RequestFinished must be called once a request started with
+	// RequestStarted is completed, whether it succeeded or not, along
+	// with the time that the host took to reply
+	RequestFinished(ctx context.Context, service *values.Service, host *values.Host, latency time.Duration)
 }
 
+// DefaultHandler is safe for concurrent use. The state of each service is
+// created the first time the service is balanced and is only updated with
+// atomic operations afterwards, so concurrent requests never wait on a
+// shared lock to choose a host.
 type DefaultHandler struct {
 	logger log.Logger
+
+	// map of *values.Service to the *serviceState of the service
+	states sync.Map
 }
 
 func New(
@@ -30,16 +51,85 @@ func New(
 	return svc
 }
 
-func (h *DefaultHandler) SetNextHost(
+func (h *DefaultHandler) NextHost(
+	ctx context.Context,
+	service *values.Service,
+	request *values.Request,
+) *values.Host {
+	if len(service.Hosts) == 0 {
+		return nil
+	}
+
+	state := h.serviceState(service)
+
+	host := state.next(service.LoadBalancer, request, isAvailable)
+	if host == nil {
+		// when every host is unhealthy or ejected, trying one of them
+		// is better than failing the request without trying at all
+		host = state.next(service.LoadBalancer, request, anyHost)
+	}
+
+	return host
+}
+
+func (h *DefaultHandler) NextHostExcluding(
+	ctx context.Context,
+	service *values.Service,
+	request *values.Request,
+	excluded *values.Host,
+) *values.Host {
+	if len(service.Hosts) < 2 {
+		return nil
+	}
+
+	state := h.serviceState(service)
+
+	// unlike NextHost there is no fallback, since the caller
+	// already has a host to send the request to
+	return state.next(service.LoadBalancer, request, func(host *values.Host) bool {
+		return host != excluded && host.IsAvailable()
+	})
+}
+
+func (h *DefaultHandler) RequestStarted(
 	ctx context.Context,
 	service *values.Service,
+	host *values.Host,
 ) {
-	nextHostIndex := service.NextHostIndex + 1
+	state := h.serviceState(service)
+
+	if index, ok := state.indexes[host]; ok {
+		state.outstanding[index].add(1)
+	}
+}
+
+func (h *DefaultHandler) RequestFinished(
+	ctx context.Context,
+	service *values.Service,
+	host *values.Host,
+	latency time.Duration,
+) {
+	state := h.serviceState(service)
+
+	index, ok := state.indexes[host]
+	if !ok {
+		return
+	}
+
+	state.outstanding[index].add(-1)
+
+	if state.ewmas != nil {
+		state.ewmas[index].observe(latency)
+	}
+}
 
-	if int(nextHostIndex) >= len(service.Hosts) {
-		nextHostIndex = 0
+// serviceState returns the load balancing state of a service,
+// creating it the first time that it is needed
+func (h *DefaultHandler) serviceState(service *values.Service) *serviceState {
+	if state, ok := h.states.Load(service); ok {
+		return state.(*serviceState)
 	}
 
-	service.NextHostIndex = nextHostIndex
-	return
+	state, _ := h.states.LoadOrStore(service, newServiceState(service))
+	return state.(*serviceState)
 }
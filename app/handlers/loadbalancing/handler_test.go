@@ -2,84 +2,336 @@ package loadbalancing_test
 
 import (
 	"context"
+	"fmt"
 	"go-reverse-proxy/app/common/log"
 	"go-reverse-proxy/app/handlers/loadbalancing"
 	"go-reverse-proxy/app/values"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func TestSetNextHost(t *testing.T) {
+func newService(loadBalancer string, weights ...int32) *values.Service {
+	var hosts []*values.Host
+	for index, weight := range weights {
+		hosts = append(hosts, &values.Host{
+			Address: "127.0.0.1",
+			Port:    5000 + int32(index),
+			Weight:  weight,
+		})
+	}
+
+	return &values.Service{
+		Name:         "my-service",
+		Domain:       "my-domain.com",
+		LoadBalancer: loadBalancer,
+		Hosts:        hosts,
+	}
+}
+
+// startRequests simulates requests in flight to a host
+func startRequests(
+	handler loadbalancing.Handler,
+	service *values.Service,
+	host *values.Host,
+	count int,
+) {
+	for i := 0; i < count; i++ {
+		handler.RequestStarted(context.Background(), service, host)
+	}
+}
+
+func TestNextHost(t *testing.T) {
+	logger := log.NewLogger()
+	handler := loadbalancing.New(logger)
+
+	service := newService(values.RoundRobin, 1, 1, 1)
+
+	host := handler.NextHost(context.Background(), service, &values.Request{})
+
+	assert.Equal(t, service.Hosts[0], host)
+}
+
+func TestNextHostIsFirst(t *testing.T) {
+	logger := log.NewLogger()
+	handler := loadbalancing.New(logger)
+
+	service := newService(values.RoundRobin, 1, 1, 1)
+
+	var hosts []*values.Host
+	for i := 0; i < 4; i++ {
+		hosts = append(hosts, handler.NextHost(context.Background(), service, &values.Request{}))
+	}
+
+	assert.Equal(t, []*values.Host{
+		service.Hosts[0],
+		service.Hosts[1],
+		service.Hosts[2],
+		service.Hosts[0],
+	}, hosts)
+}
+
+func TestNextHostNoHosts(t *testing.T) {
 	logger := log.NewLogger()
 	handler := loadbalancing.New(logger)
 
 	service := &values.Service{
 		Name:   "my-service",
 		Domain: "my-domain.com",
-		Hosts: []*values.Host{
-			{
-				Address: "127.0.0.1",
-				Port:    5000,
-			},
-			{
-				Address: "127.0.0.1",
-				Port:    5000,
-			},
-			{
-				Address: "127.0.0.1",
-				Port:    5000,
-			},
-		},
-		NextHostIndex: 0,
+		Hosts:  []*values.Host{},
 	}
 
-	handler.SetNextHost(context.Background(), service)
+	host := handler.NextHost(context.Background(), service, &values.Request{})
 
-	assert.Equal(t, int32(1), service.NextHostIndex)
+	assert.Nil(t, host)
 }
 
-func TestSetNextHostIsFirst(t *testing.T) {
+func TestNextHostWeighted(t *testing.T) {
 	logger := log.NewLogger()
 	handler := loadbalancing.New(logger)
 
-	service := &values.Service{
-		Name:   "my-service",
-		Domain: "my-domain.com",
-		Hosts: []*values.Host{
-			{
-				Address: "127.0.0.1",
-				Port:    5000,
-			},
-			{
-				Address: "127.0.0.1",
-				Port:    5000,
-			},
-			{
-				Address: "127.0.0.1",
-				Port:    5000,
-			},
-		},
-		NextHostIndex: 2,
+	service := newService(values.WeightedRoundRobin, 5, 1, 1)
+
+	var ports []int32
+	for i := 0; i < 14; i++ {
+		host := handler.NextHost(context.Background(), service, &values.Request{})
+		ports = append(ports, host.Port)
+	}
+
+	assert.Equal(t, []int32{
+		5000, 5000, 5001, 5000, 5002, 5000, 5000,
+		5000, 5000, 5001, 5000, 5002, 5000, 5000,
+	}, ports)
+}
+
+func TestNextHostWeightedDistribution(t *testing.T) {
+	logger := log.NewLogger()
+	handler := loadbalancing.New(logger)
+
+	service := newService(values.WeightedRoundRobin, 30, 20, 10)
+
+	counts := make(map[int32]int)
+	for i := 0; i < 600; i++ {
+		host := handler.NextHost(context.Background(), service, &values.Request{})
+		counts[host.Port]++
 	}
 
-	handler.SetNextHost(context.Background(), service)
+	assert.Equal(t, map[int32]int{5000: 300, 5001: 200, 5002: 100}, counts)
+}
+
+func TestNextHostLeastOutstanding(t *testing.T) {
+	logger := log.NewLogger()
+	handler := loadbalancing.New(logger)
+
+	service := newService(values.LeastOutstandingRequests, 1, 1, 1)
+	startRequests(handler, service, service.Hosts[0], 3)
+	startRequests(handler, service, service.Hosts[1], 1)
+	startRequests(handler, service, service.Hosts[2], 2)
 
-	assert.Equal(t, int32(0), service.NextHostIndex)
+	host := handler.NextHost(context.Background(), service, &values.Request{})
+
+	assert.Equal(t, service.Hosts[1], host)
 }
 
-func TestSetNoHosts(t *testing.T) {
+func TestRequestFinishedLeastOutstanding(t *testing.T) {
 	logger := log.NewLogger()
 	handler := loadbalancing.New(logger)
 
-	service := &values.Service{
-		Name:          "my-service",
-		Domain:        "my-domain.com",
-		Hosts:         []*values.Host{},
-		NextHostIndex: 0,
+	service := newService(values.LeastOutstandingRequests, 1, 1)
+	startRequests(handler, service, service.Hosts[0], 2)
+	startRequests(handler, service, service.Hosts[1], 1)
+
+	assert.Equal(t, service.Hosts[1], handler.NextHost(context.Background(), service, &values.Request{}))
+
+	handler.RequestFinished(context.Background(), service, service.Hosts[0], time.Millisecond)
+	handler.RequestFinished(context.Background(), service, service.Hosts[0], time.Millisecond)
+
+	assert.Equal(t, service.Hosts[0], handler.NextHost(context.Background(), service, &values.Request{}))
+}
+
+func TestNextHostLeastOutstandingBreaksTiesRandomly(t *testing.T) {
+	logger := log.NewLogger()
+	handler := loadbalancing.New(logger)
+
+	service := newService(values.LeastOutstandingRequests, 1, 1, 1)
+	for _, host := range service.Hosts {
+		startRequests(handler, service, host, 1)
+	}
+
+	counts := make(map[int32]int)
+	for i := 0; i < 3000; i++ {
+		host := handler.NextHost(context.Background(), service, &values.Request{})
+		counts[host.Port]++
+	}
+
+	for _, port := range []int32{5000, 5001, 5002} {
+		assert.InDelta(t, 1000, counts[port], 200)
+	}
+}
+
+func TestNextHostConcurrent(t *testing.T) {
+	testCases := []struct {
+		loadBalancer string
+		weights      []int32
+		expected     map[int32]int
+	}{
+		{
+			loadBalancer: values.RoundRobin,
+			weights:      []int32{1, 1, 1},
+			expected:     map[int32]int{5000: 4000, 5001: 4000, 5002: 4000},
+		},
+		{
+			loadBalancer: values.WeightedRoundRobin,
+			weights:      []int32{3, 2, 1},
+			expected:     map[int32]int{5000: 6000, 5001: 4000, 5002: 2000},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.loadBalancer, func(t *testing.T) {
+			handler := loadbalancing.New(log.NewNopLogger())
+			service := newService(tc.loadBalancer, tc.weights...)
+
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			counts := make(map[int32]int)
+
+			for worker := 0; worker < 24; worker++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					local := make(map[int32]int)
+					for i := 0; i < 500; i++ {
+						host := handler.NextHost(context.Background(), service, &values.Request{})
+						handler.RequestStarted(context.Background(), service, host)
+						local[host.Port]++
+						handler.RequestFinished(context.Background(), service, host, time.Millisecond)
+					}
+
+					mu.Lock()
+					defer mu.Unlock()
+					for port, count := range local {
+						counts[port] += count
+					}
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, tc.expected, counts)
+		})
+	}
+}
+
+func BenchmarkNextHostParallel(b *testing.B) {
+	loadBalancers := []string{
+		values.RoundRobin,
+		values.WeightedRoundRobin,
+		values.LeastOutstandingRequests,
+		values.ConsistentHash,
+		values.PeakEWMA,
+	}
+
+	for _, loadBalancer := range loadBalancers {
+		b.Run(loadBalancer, func(b *testing.B) {
+			handler := loadbalancing.New(log.NewNopLogger())
+			service := newService(loadBalancer, 3, 2, 1, 1, 1, 1, 1, 1)
+			service.HashKey = values.HashKey{Source: values.HashKeyClientIP}
+			request := &values.Request{RemoteAddr: "192.168.1.10:52341"}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					host := handler.NextHost(context.Background(), service, request)
+					handler.RequestStarted(context.Background(), service, host)
+					handler.RequestFinished(context.Background(), service, host, time.Millisecond)
+				}
+			})
+		})
+	}
+}
+
+func TestNextHostSkipsUnhealthyHosts(t *testing.T) {
+	loadBalancers := []string{
+		values.RoundRobin,
+		values.WeightedRoundRobin,
+		values.LeastOutstandingRequests,
+		values.ConsistentHash,
+		values.PeakEWMA,
+	}
+
+	for _, loadBalancer := range loadBalancers {
+		t.Run(loadBalancer, func(t *testing.T) {
+			handler := loadbalancing.New(log.NewNopLogger())
+			service := newService(loadBalancer, 1, 1, 1)
+			service.HashKey = values.HashKey{Source: values.HashKeyClientIP}
+			service.Hosts[1].SetHealthy(false)
+
+			counts := make(map[int32]int)
+			for i := 0; i < 300; i++ {
+				request := &values.Request{RemoteAddr: fmt.Sprintf("10.0.0.%d:5000", i)}
+				host := handler.NextHost(context.Background(), service, request)
+				counts[host.Port]++
+			}
+
+			assert.Equal(t, 0, counts[5001])
+			assert.Greater(t, counts[5000], 0)
+			assert.Greater(t, counts[5002], 0)
+		})
+	}
+}
+
+func TestNextHostAllHostsUnhealthy(t *testing.T) {
+	handler := loadbalancing.New(log.NewNopLogger())
+	service := newService(values.RoundRobin, 1, 1)
+	for _, host := range service.Hosts {
+		host.SetHealthy(false)
 	}
 
-	handler.SetNextHost(context.Background(), service)
+	var hosts []*values.Host
+	for i := 0; i < 2; i++ {
+		hosts = append(hosts, handler.NextHost(context.Background(), service, &values.Request{}))
+	}
+
+	assert.Equal(t, []*values.Host{service.Hosts[0], service.Hosts[1]}, hosts)
+}
+
+func TestNextHostExcluding(t *testing.T) {
+	loadBalancers := []string{
+		values.RoundRobin,
+		values.WeightedRoundRobin,
+		values.LeastOutstandingRequests,
+		values.ConsistentHash,
+		values.PeakEWMA,
+	}
+
+	for _, loadBalancer := range loadBalancers {
+		t.Run(loadBalancer, func(t *testing.T) {
+			handler := loadbalancing.New(log.NewNopLogger())
+			service := newService(loadBalancer, 1, 1, 1)
+			service.HashKey = values.HashKey{Source: values.HashKeyClientIP}
+
+			for i := 0; i < 100; i++ {
+				request := &values.Request{RemoteAddr: fmt.Sprintf("10.0.0.%d:5000", i)}
+				host := handler.NextHost(context.Background(), service, request)
+				other := handler.NextHostExcluding(context.Background(), service, request, host)
+
+				assert.NotNil(t, other)
+				assert.NotEqual(t, host, other)
+			}
+		})
+	}
+}
+
+func TestNextHostExcludingWithoutOtherHosts(t *testing.T) {
+	handler := loadbalancing.New(log.NewNopLogger())
+	service := newService(values.RoundRobin, 1, 1)
+	service.Hosts[1].SetHealthy(false)
+
+	host := handler.NextHostExcluding(context.Background(), service, &values.Request{}, service.Hosts[0])
 
-	assert.Equal(t, int32(0), service.NextHostIndex)
+	assert.Nil(t, host)
 }
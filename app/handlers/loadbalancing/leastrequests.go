@@ -0,0 +1,40 @@
+package loadbalancing
+
+import (
+	"math/rand"
+
+	"go-reverse-proxy/app/values"
+)
+
+// nextLeastOutstanding returns the host accepted by the filter with the
+// fewest requests in flight. When several hosts are equally loaded one of
+// them is chosen at random, so that idle services don't always favour the
+// first host.
+func (s *serviceState) nextLeastOutstanding(filter hostFilter) *values.Host {
+	var leastOutstanding int64
+	var ties int
+	var next *values.Host
+
+	for index, host := range s.hosts {
+		if !filter(host) {
+			continue
+		}
+
+		outstanding := s.outstanding[index].load()
+
+		switch {
+		case ties == 0 || outstanding < leastOutstanding:
+			leastOutstanding = outstanding
+			next = host
+			ties = 1
+		case outstanding == leastOutstanding:
+			// reservoir sampling gives every tied host the same chance
+			ties++
+			if rand.Intn(ties) == 0 {
+				next = host
+			}
+		}
+	}
+
+	return next
+}
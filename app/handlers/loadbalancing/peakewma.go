@@ -0,0 +1,115 @@
+package loadbalancing
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go-reverse-proxy/app/values"
+)
+
+// cost given to hosts that have requests in flight but haven't replied
+// yet, so that a new host isn't flooded before its latency is known
+const peakEWMAPenalty = float64(math.MaxInt32)
+
+// peakEWMA is an exponentially weighted moving average of the latency of a
+// host that jumps straight to any latency above the average. Spikes are
+// noticed immediately, while recoveries are only trusted as they decay.
+// Each host has its own average, so the lock is only shared by the
+// requests that compare the same host.
+type peakEWMA struct {
+	mu    sync.Mutex
+	decay float64   // decay time in nanoseconds
+	value float64   // average latency in nanoseconds
+	stamp time.Time // time of the last update
+}
+
+func newPeakEWMA(decay time.Duration) *peakEWMA {
+	if decay <= 0 {
+		decay = values.DefaultDecayTime
+	}
+
+	return &peakEWMA{
+		decay: float64(decay),
+		stamp: time.Now(),
+	}
+}
+
+// observe adds the latency of a request to the average
+func (e *peakEWMA) observe(latency time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.update(float64(latency))
+}
+
+// cost returns the average latency of the host weighted by the number of
+// requests in flight. Reading the cost decays the average towards zero,
+// so hosts that were slow in the past are eventually tried again.
+func (e *peakEWMA) cost(outstanding int64) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.update(0)
+
+	if e.value == 0 && outstanding != 0 {
+		return peakEWMAPenalty + float64(outstanding)
+	}
+
+	return e.value * float64(outstanding+1)
+}
+
+func (e *peakEWMA) update(latency float64) {
+	now := time.Now()
+
+	if latency > e.value {
+		e.value = latency
+	} else {
+		elapsed := math.Max(float64(now.Sub(e.stamp)), 0)
+		weight := math.Exp(-elapsed / e.decay)
+		e.value = e.value*weight + latency*(1-weight)
+	}
+
+	e.stamp = now
+}
+
+// nextPeakEWMA applies the power of two choices: it picks two distinct
+// hosts accepted by the filter at random and returns the one with the
+// lowest cost. Comparing only two hosts avoids herding every request onto
+// the single fastest host.
+func (s *serviceState) nextPeakEWMA(filter hostFilter) *values.Host {
+	// the hosts are filtered once, since their flags may change
+	// while the request is choosing between them
+	var accepted []int
+	for index, host := range s.hosts {
+		if filter(host) {
+			accepted = append(accepted, index)
+		}
+	}
+
+	switch len(accepted) {
+	case 0:
+		return nil
+	case 1:
+		return s.hosts[accepted[0]]
+	}
+
+	firstPick := rand.Intn(len(accepted))
+	secondPick := rand.Intn(len(accepted) - 1)
+	if secondPick >= firstPick {
+		secondPick++
+	}
+
+	first := accepted[firstPick]
+	second := accepted[secondPick]
+
+	costFirst := s.ewmas[first].cost(s.outstanding[first].load())
+	costSecond := s.ewmas[second].cost(s.outstanding[second].load())
+
+	if costSecond < costFirst {
+		return s.hosts[second]
+	}
+
+	return s.hosts[first]
+}
@@ -0,0 +1,159 @@
+package loadbalancing_test
+
+import (
+	"context"
+	"go-reverse-proxy/app/common/log"
+	"go-reverse-proxy/app/handlers/loadbalancing"
+	"go-reverse-proxy/app/values"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newPeakEWMAService(decayTime time.Duration, ports ...int32) *values.Service {
+	var hosts []*values.Host
+	for _, port := range ports {
+		hosts = append(hosts, &values.Host{
+			Address: "10.0.0.1",
+			Port:    port,
+			Weight:  1,
+		})
+	}
+
+	return &values.Service{
+		Name:         "my-service",
+		Domain:       "my-domain.com",
+		LoadBalancer: values.PeakEWMA,
+		DecayTime:    decayTime,
+		Hosts:        hosts,
+	}
+}
+
+// observe simulates a request to the host that took the given latency
+func observe(
+	handler loadbalancing.Handler,
+	service *values.Service,
+	host *values.Host,
+	latency time.Duration,
+) {
+	handler.RequestStarted(context.Background(), service, host)
+	handler.RequestFinished(context.Background(), service, host, latency)
+}
+
+func TestNextHostPeakEWMAPrefersFasterHost(t *testing.T) {
+	handler := loadbalancing.New(log.NewNopLogger())
+	service := newPeakEWMAService(10*time.Second, 5000, 5001)
+
+	observe(handler, service, service.Hosts[0], 200*time.Millisecond)
+	observe(handler, service, service.Hosts[1], 10*time.Millisecond)
+
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, service.Hosts[1], handler.NextHost(context.Background(), service, &values.Request{}))
+	}
+}
+
+func TestNextHostPeakEWMAWeighsOutstandingRequests(t *testing.T) {
+	handler := loadbalancing.New(log.NewNopLogger())
+	service := newPeakEWMAService(10*time.Second, 5000, 5001)
+
+	observe(handler, service, service.Hosts[0], 10*time.Millisecond)
+	observe(handler, service, service.Hosts[1], 15*time.Millisecond)
+
+	// the faster host is busy with requests that are still in flight
+	for i := 0; i < 3; i++ {
+		handler.RequestStarted(context.Background(), service, service.Hosts[0])
+	}
+
+	assert.Equal(t, service.Hosts[1], handler.NextHost(context.Background(), service, &values.Request{}))
+}
+
+func TestNextHostPeakEWMAReactsToLatencySpikes(t *testing.T) {
+	handler := loadbalancing.New(log.NewNopLogger())
+	service := newPeakEWMAService(10*time.Second, 5000, 5001)
+
+	observe(handler, service, service.Hosts[0], 10*time.Millisecond)
+	observe(handler, service, service.Hosts[1], 20*time.Millisecond)
+
+	assert.Equal(t, service.Hosts[0], handler.NextHost(context.Background(), service, &values.Request{}))
+
+	// a single slow response is enough to route away from the host
+	observe(handler, service, service.Hosts[0], 500*time.Millisecond)
+
+	assert.Equal(t, service.Hosts[1], handler.NextHost(context.Background(), service, &values.Request{}))
+}
+
+func TestNextHostPeakEWMALatencyDecays(t *testing.T) {
+	handler := loadbalancing.New(log.NewNopLogger())
+	service := newPeakEWMAService(10*time.Millisecond, 5000, 5001)
+
+	observe(handler, service, service.Hosts[0], 500*time.Millisecond)
+	observe(handler, service, service.Hosts[1], 50*time.Millisecond)
+
+	assert.Equal(t, service.Hosts[1], handler.NextHost(context.Background(), service, &values.Request{}))
+
+	// after many decay periods the spike of the first host is forgotten
+	time.Sleep(200 * time.Millisecond)
+	observe(handler, service, service.Hosts[1], 50*time.Millisecond)
+
+	assert.Equal(t, service.Hosts[0], handler.NextHost(context.Background(), service, &values.Request{}))
+}
+
+func TestNextHostPeakEWMADistribution(t *testing.T) {
+	handler := loadbalancing.New(log.NewNopLogger())
+	service := newPeakEWMAService(10*time.Second, 5000, 5001, 5002)
+
+	observe(handler, service, service.Hosts[0], 300*time.Millisecond)
+	observe(handler, service, service.Hosts[1], 10*time.Millisecond)
+	observe(handler, service, service.Hosts[2], 10*time.Millisecond)
+
+	counts := make(map[int32]int)
+	for i := 0; i < 3000; i++ {
+		host := handler.NextHost(context.Background(), service, &values.Request{})
+		counts[host.Port]++
+	}
+
+	assert.Equal(t, 0, counts[5000])
+	assert.Greater(t, counts[5001], 0)
+	assert.Greater(t, counts[5002], 0)
+}
+
+func TestNextHostPeakEWMAWithChangingHosts(t *testing.T) {
+	handler := loadbalancing.New(log.NewNopLogger())
+
+	service := newPeakEWMAService(10*time.Second, 5000, 5001, 5002)
+
+	// the flags must change in the middle of the choices
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := worker; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				// the hosts are ejected and readmitted while they are chosen
+				service.Hosts[i%len(service.Hosts)].SetEjected(rand.Intn(2) == 0)
+			}
+		}(worker)
+	}
+
+	for i := 0; i < 300000; i++ {
+		assert.NotPanics(t, func() {
+			handler.NextHost(context.Background(), service, &values.Request{})
+		})
+	}
+
+	close(done)
+	wg.Wait()
+}
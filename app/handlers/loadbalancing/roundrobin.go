@@ -0,0 +1,23 @@
+package loadbalancing
+
+import (
+	"sync/atomic"
+
+	"go-reverse-proxy/app/values"
+)
+
+// nextRoundRobin returns each host of the service in turn, wrapping
+// around at the end of the host list. Hosts rejected by the filter
+// are skipped and their turn goes to the next host.
+func (s *serviceState) nextRoundRobin(filter hostFilter) *values.Host {
+	for attempt := 0; attempt < len(s.hosts); attempt++ {
+		pick := atomic.AddUint64(&s.picks, 1) - 1
+		host := s.hosts[pick%uint64(len(s.hosts))]
+
+		if filter(host) {
+			return host
+		}
+	}
+
+	return nil
+}
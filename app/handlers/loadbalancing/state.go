@@ -0,0 +1,100 @@
+package loadbalancing
+
+import (
+	"sync/atomic"
+
+	"go-reverse-proxy/app/values"
+)
+
+// serviceState holds everything that the load balancing algorithms need
+// to remember about a service between requests. The fields that depend
+// only on the configuration are computed once when the state is created
+// and are never written again, while the ones updated by every request
+// are counters accessed atomically.
+type serviceState struct {
+	hosts   []*values.Host
+	indexes map[*values.Host]int // position of each host in hosts
+
+	picks       uint64    // number of round-robin picks so far
+	outstanding []counter // requests in flight to each host
+
+	schedule []int          // order of the weighted round-robin picks
+	ring     *ring          // hash ring of the consistent hashing
+	hashKey  values.HashKey // request attribute hashed onto the ring
+	ewmas    []*peakEWMA    // latency average of each host
+}
+
+func newServiceState(service *values.Service) *serviceState {
+	state := &serviceState{
+		hosts:       service.Hosts,
+		indexes:     make(map[*values.Host]int, len(service.Hosts)),
+		outstanding: make([]counter, len(service.Hosts)),
+	}
+
+	for index, host := range service.Hosts {
+		state.indexes[host] = index
+	}
+
+	switch service.LoadBalancer {
+	case values.WeightedRoundRobin:
+		state.schedule = smoothWeightedSchedule(service.Hosts)
+	case values.ConsistentHash:
+		state.ring = newRing(service.Hosts)
+		state.hashKey = service.HashKey
+	case values.PeakEWMA:
+		state.ewmas = make([]*peakEWMA, len(service.Hosts))
+		for index := range state.ewmas {
+			state.ewmas[index] = newPeakEWMA(service.DecayTime)
+		}
+	}
+
+	return state
+}
+
+// hostFilter decides which hosts of a service may receive a request
+type hostFilter func(host *values.Host) bool
+
+func isAvailable(host *values.Host) bool {
+	return host.IsAvailable()
+}
+
+func anyHost(host *values.Host) bool {
+	return true
+}
+
+// next applies a load balancing algorithm to choose one of the hosts
+// accepted by the filter, returning nil when there is none
+func (s *serviceState) next(
+	loadBalancer string,
+	request *values.Request,
+	filter hostFilter,
+) *values.Host {
+	switch loadBalancer {
+	case values.WeightedRoundRobin:
+		return s.nextWeighted(filter)
+	case values.LeastOutstandingRequests:
+		return s.nextLeastOutstanding(filter)
+	case values.ConsistentHash:
+		return s.nextHashed(request, filter)
+	case values.PeakEWMA:
+		return s.nextPeakEWMA(filter)
+	default:
+		return s.nextRoundRobin(filter)
+	}
+}
+
+// counter is an int64 padded to fill a whole cache line, so that
+// goroutines updating the counters of different hosts don't slow
+// each other down by invalidating the same line
+type counter struct {
+	value int64
+	_     [56]byte
+}
+
+func (c *counter) add(delta int64) {
+	atomic.AddInt64(&c.value, delta)
+}
+
+func (c *counter) load() int64 {
+	return atomic.LoadInt64(&c.value)
+}
@@ -0,0 +1,77 @@
+package loadbalancing
+
+import (
+	"sync/atomic"
+
+	"go-reverse-proxy/app/values"
+)
+
+// nextWeighted returns the hosts in the order of the smooth weighted
+// round-robin schedule of the service, wrapping around at its end.
+// Hosts rejected by the filter are skipped and their turn goes to the
+// next host of the schedule.
+func (s *serviceState) nextWeighted(filter hostFilter) *values.Host {
+	for attempt := 0; attempt < len(s.schedule); attempt++ {
+		pick := atomic.AddUint64(&s.picks, 1) - 1
+		host := s.hosts[s.schedule[pick%uint64(len(s.schedule))]]
+
+		if filter(host) {
+			return host
+		}
+	}
+
+	return nil
+}
+
+// smoothWeightedSchedule computes one cycle of the smooth weighted
+// round-robin used by nginx. On every pick each host earns its weight,
+// the host that is owed the most traffic is chosen and pays back the total
+// weight of the service. Hosts end up chosen in proportion to their weight,
+// and the picks of a heavy host are interleaved with the others instead of
+// being sent in a burst.
+//
+// The picks repeat themselves after as many picks as the sum of the weights
+// divided by their greatest common divisor, so the cycle is computed once
+// and then walked with an atomic counter instead of under a lock.
+func smoothWeightedSchedule(hosts []*values.Host) []int {
+	weights := make([]int64, len(hosts))
+	var divisor int64
+	for index, host := range hosts {
+		weights[index] = int64(host.Weight)
+		if weights[index] < 1 {
+			weights[index] = 1
+		}
+		divisor = gcd(divisor, weights[index])
+	}
+
+	var totalWeight int64
+	for index := range weights {
+		weights[index] /= divisor
+		totalWeight += weights[index]
+	}
+
+	currentWeights := make([]int64, len(hosts))
+	schedule := make([]int, totalWeight)
+
+	for pick := range schedule {
+		next := 0
+		for index := range weights {
+			currentWeights[index] += weights[index]
+			if currentWeights[index] > currentWeights[next] {
+				next = index
+			}
+		}
+
+		currentWeights[next] -= totalWeight
+		schedule[pick] = next
+	}
+
+	return schedule
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
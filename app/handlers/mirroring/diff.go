@@ -0,0 +1,205 @@
+package mirroring
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go-reverse-proxy/app/values"
+)
+
+// differences listed for a response pair, the
+// rest are only counted so that the logs stay short
+const maxDifferences = 10
+
+// response is a response received from a host
+type response struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	truncated  bool // the body is longer than the compared part
+	err        error
+}
+
+// differences collects the differences between two responses
+type differences struct {
+	listed  []string
+	omitted int
+}
+
+func (d *differences) add(format string, args ...interface{}) {
+	if len(d.listed) == maxDifferences {
+		d.omitted++
+		return
+	}
+
+	d.listed = append(d.listed, fmt.Sprintf(format, args...))
+}
+
+func (d *differences) String() string {
+	if d.omitted > 0 {
+		return fmt.Sprintf("%s; and %d more", strings.Join(d.listed, "; "), d.omitted)
+	}
+
+	return strings.Join(d.listed, "; ")
+}
+
+// diff compares the response of a mirror host with the response sent
+// to the client, on their status code, the configured headers and their
+// bodies. Bodies that are both JSON are compared field by field, leaving
+// out the ignored fields, while any other bodies must be equal. Bodies
+// too long to be held in memory are left out of the comparison.
+func diff(config *values.ShadowDiff, primary, shadow *response) *differences {
+	d := &differences{}
+
+	if shadow.err != nil {
+		d.add("shadow error: %v", shadow.err)
+		return d
+	}
+
+	if primary.statusCode != shadow.statusCode {
+		d.add("status: %d != %d", primary.statusCode, shadow.statusCode)
+	}
+
+	for _, name := range config.Headers {
+		primaryValue := strings.Join(primary.header.Values(name), ", ")
+		shadowValue := strings.Join(shadow.header.Values(name), ", ")
+		if primaryValue != shadowValue {
+			d.add("header %s: %q != %q", http.CanonicalHeaderKey(name), primaryValue, shadowValue)
+		}
+	}
+
+	if primary.truncated || shadow.truncated {
+		return d
+	}
+
+	primaryJSON, primaryErr := decodeJSON(primary.body)
+	shadowJSON, shadowErr := decodeJSON(shadow.body)
+	if primaryErr != nil || shadowErr != nil {
+		if !bytes.Equal(primary.body, shadow.body) {
+			d.add("body: %d bytes != %d bytes", len(primary.body), len(shadow.body))
+		}
+		return d
+	}
+
+	for _, field := range config.IgnoredFields {
+		path := strings.Split(field, ".")
+		removeField(primaryJSON, path)
+		removeField(shadowJSON, path)
+	}
+
+	diffJSON(d, "body", primaryJSON, shadowJSON)
+
+	return d
+}
+
+// decodeJSON decodes a JSON body, keeping the numbers as they
+// were written so that large integers are compared exactly
+func decodeJSON(body []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	// a second value means that the body isn't a single JSON document
+	if decoder.More() {
+		return nil, fmt.Errorf("trailing data after the JSON value")
+	}
+
+	return value, nil
+}
+
+// removeField deletes the field at a dotted path from a decoded JSON
+// value, applying the rest of the path to each element of the arrays
+func removeField(value interface{}, path []string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(v, path[0])
+			return
+		}
+
+		removeField(v[path[0]], path[1:])
+	case []interface{}:
+		for _, element := range v {
+			removeField(element, path)
+		}
+	}
+}
+
+// diffJSON adds the differences between two decoded JSON values,
+// naming each one by the path of the field where it was found
+func diffJSON(d *differences, path string, primary, shadow interface{}) {
+	switch p := primary.(type) {
+	case map[string]interface{}:
+		s, ok := shadow.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		for _, key := range unionKeys(p, s) {
+			primaryValue, inPrimary := p[key]
+			shadowValue, inShadow := s[key]
+
+			switch {
+			case !inShadow:
+				d.add("%s.%s: missing in shadow", path, key)
+			case !inPrimary:
+				d.add("%s.%s: only in shadow", path, key)
+			default:
+				diffJSON(d, path+"."+key, primaryValue, shadowValue)
+			}
+		}
+		return
+	case []interface{}:
+		s, ok := shadow.([]interface{})
+		if !ok {
+			break
+		}
+
+		if len(p) != len(s) {
+			d.add("%s: %d elements != %d elements", path, len(p), len(s))
+		}
+
+		for i := 0; i < len(p) && i < len(s); i++ {
+			diffJSON(d, fmt.Sprintf("%s[%d]", path, i), p[i], s[i])
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(primary, shadow) {
+		d.add("%s: %s != %s", path, encodeJSON(primary), encodeJSON(shadow))
+	}
+}
+
+// unionKeys returns the keys of two JSON objects, sorted
+func unionKeys(a, b map[string]interface{}) []string {
+	var keys []string
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func encodeJSON(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+
+	return string(encoded)
+}
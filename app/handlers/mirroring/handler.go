@@ -0,0 +1,305 @@
+// Package mirroring contains the logic that sends copies of the requests
+// of a downstream service to a separate list of hosts, so that a new
+// version of the service can be tested with real traffic without its
+// responses ever reaching the clients.
+package mirroring
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	client "go-reverse-proxy/app/clients/httpclient"
+	"go-reverse-proxy/app/common/metrics"
+	lb "go-reverse-proxy/app/handlers/loadbalancing"
+	"go-reverse-proxy/app/values"
+)
+
+const (
+	MirroredRequests        = "mirrored_requests"
+	MirroredRequestsDropped = "mirrored_requests_dropped"
+	ShadowComparisons       = "shadow_comparisons"
+	ShadowMismatches        = "shadow_mismatches"
+)
+
+// copies in flight at the same time, beyond which new copies are dropped
+// so that a slow mirror can't pile up goroutines in the proxy
+const maxInFlight = 256
+
+// bytes of a response body held in memory to be compared, the bodies
+// that are longer are streamed without being compared
+const maxComparedBody = 1 << 20
+
+type Handler interface {
+	// Mirror sends a copy of a sampled share of the requests of a service
+	// to the hosts of its mirror. The copy is sent in the background, so
+	// it never delays the request, and its response is discarded unless
+	// the mirror compares it with the response to the client, in which
+	// case the returned Shadow must capture that response. The body of the
+	// request must be one that can be read while it is forwarded, such as
+	// a copy held in memory. The route is the one matched by the request,
+	// nil when it matched none.
+	Mirror(ctx context.Context, service *values.Service, route *values.Route, request *values.Request) *Shadow
+}
+
+// Shadow receives the response sent to the client for a request whose
+// copy is compared with it. A nil Shadow ignores the response.
+type Shadow struct {
+	primary chan *response
+}
+
+// Capture returns the response to send to the client, whose body is
+// kept as it is streamed so that it is handed over to the comparison
+// once closed. The comparison happens in the background once the copy
+// is answered as well. Responses to requests that failed are not compared.
+func (s *Shadow) Capture(res *values.Response, err error) *values.Response {
+	if s == nil {
+		return res
+	}
+
+	primary := &response{statusCode: res.StatusCode, header: res.Header, err: err}
+	if err != nil || res.Body == nil {
+		s.primary <- primary
+		return res
+	}
+
+	captured := *res
+	captured.Body = &capturedBody{ReadCloser: res.Body, primary: primary, shadow: s}
+
+	return &captured
+}
+
+// capturedBody keeps the start of a response body as it is read
+type capturedBody struct {
+	io.ReadCloser
+	primary *response
+	shadow  *Shadow
+
+	buffer   bytes.Buffer
+	complete bool
+	once     sync.Once
+}
+
+func (b *capturedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	kept := n
+	if room := maxComparedBody - b.buffer.Len(); kept > room {
+		kept = room
+		b.primary.truncated = true
+	}
+	b.buffer.Write(p[:kept])
+
+	if err == io.EOF {
+		b.complete = true
+	}
+
+	return n, err
+}
+
+func (b *capturedBody) Close() error {
+	err := b.ReadCloser.Close()
+
+	b.once.Do(func() {
+		// a body that wasn't sent whole to the client can't be compared
+		b.primary.truncated = b.primary.truncated || !b.complete
+		b.primary.body = b.buffer.Bytes()
+		b.shadow.primary <- b.primary
+	})
+
+	return err
+}
+
+type DefaultHandler struct {
+	logger       log.Logger
+	metricsCtx   *metrics.MetricsContext
+	httpClient   client.HttpClient
+	loadBalancer lb.Handler
+
+	inFlight chan struct{}
+}
+
+func New(
+	logger log.Logger,
+	metricsCtx *metrics.MetricsContext,
+	httpClient client.HttpClient,
+	loadBalancer lb.Handler,
+) Handler {
+	var svc Handler
+	svc = &DefaultHandler{
+		logger:       logger,
+		metricsCtx:   metricsCtx,
+		httpClient:   httpClient,
+		loadBalancer: loadBalancer,
+		inFlight:     make(chan struct{}, maxInFlight),
+	}
+
+	return svc
+}
+
+func (h *DefaultHandler) Mirror(
+	ctx context.Context,
+	service *values.Service,
+	route *values.Route,
+	request *values.Request,
+) *Shadow {
+	mirror := service.Mirror
+	if mirror == nil || rand.Intn(100) >= mirror.Percent {
+		return nil
+	}
+
+	select {
+	case h.inFlight <- struct{}{}:
+	default:
+		h.record(MirroredRequestsDropped, service)
+		return nil
+	}
+
+	header := request.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set(mirror.Header, "true")
+
+	mirrored := *request
+	mirrored.Header = header
+
+	var shadow *Shadow
+	if mirror.Diff != nil {
+		shadow = &Shadow{primary: make(chan *response, 1)}
+	}
+
+	go func() {
+		defer func() { <-h.inFlight }()
+		h.send(service, mirror, route, &mirrored, shadow)
+	}()
+
+	return shadow
+}
+
+// send forwards a copy of a request to a host of the mirror, and compares
+// its response with the one sent to the client when there is a shadow.
+// The copy doesn't use the context of the request, so that it isn't
+// cancelled when the response is sent to the client.
+func (h *DefaultHandler) send(
+	service *values.Service,
+	mirror *values.Mirror,
+	route *values.Route,
+	request *values.Request,
+	shadow *Shadow,
+) {
+	ctx, cancel := context.WithTimeout(context.Background(), mirror.Timeout)
+	defer cancel()
+
+	// copies are only sent again when they never reached the host
+	ctx = client.WithPreConnectionRetries(ctx)
+
+	host := h.loadBalancer.NextHost(ctx, mirror.Service, request)
+	if host == nil {
+		return
+	}
+
+	url := fmt.Sprintf("%s/%s", host.ToURL(), request.Endpoint)
+
+	h.loadBalancer.RequestStarted(ctx, mirror.Service, host)
+	begin := time.Now()
+	mirrored, err := h.httpClient.Request(
+		ctx,
+		request.Method,
+		url,
+		request.Header,
+		request.Parameters,
+		request.Body,
+	)
+	h.loadBalancer.RequestFinished(ctx, mirror.Service, host, time.Since(begin))
+
+	if err != nil {
+		h.logger.Log("mirror", mirror.Service.Name, "host", host.ToURL(), "err", err)
+	}
+
+	h.record(MirroredRequests, service)
+
+	shadowResponse := &response{
+		statusCode: mirrored.StatusCode,
+		header:     mirrored.Header,
+		err:        err,
+	}
+
+	if mirrored.Body != nil {
+		defer mirrored.Body.Close()
+
+		// only the start of the body is compared
+		if shadow != nil {
+			body, err := ioutil.ReadAll(io.LimitReader(mirrored.Body, maxComparedBody+1))
+			shadowResponse.body = body
+			shadowResponse.truncated = len(body) > maxComparedBody
+			if err != nil {
+				shadowResponse.err = err
+			}
+		}
+	}
+
+	if shadow == nil {
+		return
+	}
+
+	// the client may still be waiting for its response
+	select {
+	case primary := <-shadow.primary:
+		if primary.err == nil {
+			h.compare(service, mirror, route, request, primary, shadowResponse)
+		}
+	case <-ctx.Done():
+	}
+}
+
+// compare diffs the response of the mirror host with the response sent
+// to the client, counting the mismatches and logging a sample of them
+func (h *DefaultHandler) compare(
+	service *values.Service,
+	mirror *values.Mirror,
+	route *values.Route,
+	request *values.Request,
+	primary *response,
+	shadow *response,
+) {
+	routeName := values.RouteName(route)
+	lvs := []string{"service", service.Name, "route", routeName}
+
+	if err := h.metricsCtx.Record(ShadowComparisons, 1, lvs...); err != nil {
+		h.logger.Log("metrics", ShadowComparisons, "service", service.Name, "err", err)
+	}
+
+	differences := diff(mirror.Diff, primary, shadow)
+	if len(differences.listed) == 0 {
+		return
+	}
+
+	if err := h.metricsCtx.Record(ShadowMismatches, 1, lvs...); err != nil {
+		h.logger.Log("metrics", ShadowMismatches, "service", service.Name, "err", err)
+	}
+
+	if rand.Intn(100) < mirror.Diff.LogPercent {
+		h.logger.Log(
+			"shadow_diff", service.Name,
+			"route", routeName,
+			"method", request.Method,
+			"endpoint", request.Endpoint,
+			"diff", differences.String(),
+		)
+	}
+}
+
+func (h *DefaultHandler) record(name string, service *values.Service) {
+	if err := h.metricsCtx.Record(name, 1, "service", service.Name); err != nil {
+		h.logger.Log("metrics", name, "service", service.Name, "err", err)
+	}
+}
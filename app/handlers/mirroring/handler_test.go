@@ -0,0 +1,467 @@
+package mirroring_test
+
+import (
+	"context"
+	"fmt"
+	"go-reverse-proxy/app/common/log"
+	"go-reverse-proxy/app/common/metrics"
+	"go-reverse-proxy/app/handlers/loadbalancing"
+	"go-reverse-proxy/app/handlers/mirroring"
+	"go-reverse-proxy/app/values"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	http_mock "go-reverse-proxy/mocks/app/clients/httpclient"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newMirrorHandler(httpClient *http_mock.HttpClientMock) mirroring.Handler {
+	return mirroring.New(
+		log.NewNopLogger(),
+		metrics.New(log.NewNopLogger(), "test"),
+		httpClient,
+		loadbalancing.New(log.NewNopLogger()),
+	)
+}
+
+func newService(percent int) *values.Service {
+	return &values.Service{
+		Name:   "my-service",
+		Domain: "my-domain.com",
+		Hosts: []*values.Host{
+			{
+				Address: "127.0.0.1",
+				Port:    5000,
+			},
+		},
+		Mirror: &values.Mirror{
+			Service: &values.Service{
+				Name:   "my-service/mirror",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.2",
+						Port:    5000,
+					},
+				},
+				LoadBalancer: values.RoundRobin,
+			},
+			Percent: percent,
+			Header:  values.DefaultMirrorHeader,
+			Timeout: time.Second,
+		},
+	}
+}
+
+func newRequest() *values.Request {
+	return &values.Request{
+		Method:     "POST",
+		Endpoint:   "api/v1",
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		HostHeader: "my-domain.com",
+		Body:       strings.NewReader(`{"message": "Hello World!"}`),
+	}
+}
+
+func TestMirrorWithoutMirror(t *testing.T) {
+	httpClient := &http_mock.HttpClientMock{}
+	handler := newMirrorHandler(httpClient)
+
+	service := newService(100)
+	service.Mirror = nil
+
+	handler.Mirror(context.Background(), service, nil, newRequest())
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Len(t, httpClient.RequestCalls(), 0)
+}
+
+func TestMirrorSendsCopy(t *testing.T) {
+	done := make(chan struct{})
+	httpClient := &http_mock.HttpClientMock{
+		RequestFunc: func(
+			ctx context.Context,
+			method string,
+			address string,
+			header http.Header,
+			parameters string,
+			body io.Reader,
+		) (*values.Response, error) {
+			defer close(done)
+			return &values.Response{StatusCode: http.StatusOK}, nil
+		},
+	}
+	handler := newMirrorHandler(httpClient)
+	request := newRequest()
+
+	handler.Mirror(context.Background(), newService(100), nil, request)
+	<-done
+
+	call := httpClient.RequestCalls()[0]
+	assert.Equal(t, "POST", call.Method)
+	assert.Equal(t, "127.0.0.2:5000/api/v1", call.Address)
+	assert.Equal(t, "true", call.Header.Get(values.DefaultMirrorHeader))
+	assert.Equal(t, "application/json", call.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(call.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"message": "Hello World!"}`, string(body))
+
+	// the marker is only set on the copy
+	assert.Empty(t, request.Header.Get(values.DefaultMirrorHeader))
+}
+
+func TestMirrorDoesntWaitForTheCopy(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	httpClient := &http_mock.HttpClientMock{
+		RequestFunc: func(
+			ctx context.Context,
+			method string,
+			address string,
+			header http.Header,
+			parameters string,
+			body io.Reader,
+		) (*values.Response, error) {
+			<-release
+			return &values.Response{StatusCode: http.StatusOK}, nil
+		},
+	}
+	handler := newMirrorHandler(httpClient)
+
+	begin := time.Now()
+	handler.Mirror(context.Background(), newService(100), nil, newRequest())
+
+	assert.Less(t, int64(time.Since(begin)), int64(10*time.Millisecond))
+}
+
+func TestMirrorOutlivesTheRequest(t *testing.T) {
+	errs := make(chan error, 1)
+	httpClient := &http_mock.HttpClientMock{
+		RequestFunc: func(
+			ctx context.Context,
+			method string,
+			address string,
+			header http.Header,
+			parameters string,
+			body io.Reader,
+		) (*values.Response, error) {
+			time.Sleep(10 * time.Millisecond)
+			errs <- ctx.Err()
+			return &values.Response{StatusCode: http.StatusOK}, nil
+		},
+	}
+	handler := newMirrorHandler(httpClient)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handler.Mirror(ctx, newService(100), nil, newRequest())
+	cancel()
+
+	assert.Nil(t, <-errs)
+}
+
+func TestMirrorSamplesRequests(t *testing.T) {
+	var calls int64
+	httpClient := &http_mock.HttpClientMock{
+		RequestFunc: func(
+			ctx context.Context,
+			method string,
+			address string,
+			header http.Header,
+			parameters string,
+			body io.Reader,
+		) (*values.Response, error) {
+			atomic.AddInt64(&calls, 1)
+			return &values.Response{StatusCode: http.StatusOK}, nil
+		},
+	}
+	handler := newMirrorHandler(httpClient)
+	service := newService(25)
+
+	for i := 0; i < 400; i++ {
+		handler.Mirror(context.Background(), service, nil, newRequest())
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	assert.InDelta(t, 100, atomic.LoadInt64(&calls), 40)
+}
+
+func TestMirrorDropsCopiesWhenTooManyAreInFlight(t *testing.T) {
+	release := make(chan struct{})
+
+	var calls int64
+	httpClient := &http_mock.HttpClientMock{
+		RequestFunc: func(
+			ctx context.Context,
+			method string,
+			address string,
+			header http.Header,
+			parameters string,
+			body io.Reader,
+		) (*values.Response, error) {
+			atomic.AddInt64(&calls, 1)
+			<-release
+			return &values.Response{StatusCode: http.StatusOK}, nil
+		},
+	}
+	handler := newMirrorHandler(httpClient)
+	service := newService(100)
+
+	for i := 0; i < 300; i++ {
+		handler.Mirror(context.Background(), service, nil, newRequest())
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	assert.Equal(t, int64(256), atomic.LoadInt64(&calls))
+}
+
+// diffLogger keeps the differences written to the log
+type diffLogger struct {
+	diffs chan string
+}
+
+func (l *diffLogger) Log(keyvals ...interface{}) error {
+	if len(keyvals) < 2 || keyvals[0] != "shadow_diff" {
+		return nil
+	}
+
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] == "diff" {
+			l.diffs <- keyvals[i+1].(string)
+		}
+	}
+
+	return nil
+}
+
+func TestMirrorComparesResponses(t *testing.T) {
+	primaryBody := `{"id": 1, "items": [{"price": 10, "updated_at": "a"}], "meta": {"request_id": "x"}}`
+
+	testCases := []struct {
+		name         string
+		statusCode   int
+		header       http.Header
+		shadowStatus int
+		shadowBody   string
+		shadowErr    error
+		expected     string
+	}{
+		{
+			name:         "equal except ignored fields",
+			statusCode:   http.StatusOK,
+			shadowStatus: http.StatusOK,
+			shadowBody:   `{"meta": {"request_id": "y"}, "items": [{"updated_at": "b", "price": 10}], "id": 1}`,
+			expected:     "",
+		},
+		{
+			name:         "different status and fields",
+			statusCode:   http.StatusOK,
+			shadowStatus: http.StatusCreated,
+			shadowBody:   `{"id": "1", "items": [{"price": 12}, {"price": 1}], "extra": true}`,
+			expected: "status: 200 != 201; body.extra: only in shadow; body.id: 1 != \"1\"; " +
+				"body.items: 1 elements != 2 elements; body.items[0].price: 10 != 12; body.meta: missing in shadow",
+		},
+		{
+			name:         "different header",
+			statusCode:   http.StatusOK,
+			header:       http.Header{"Content-Type": {"application/json"}},
+			shadowStatus: http.StatusOK,
+			shadowBody:   primaryBody,
+			expected:     `header Content-Type: "application/json" != ""`,
+		},
+		{
+			name:         "body that isn't JSON",
+			statusCode:   http.StatusOK,
+			shadowStatus: http.StatusOK,
+			shadowBody:   "<html></html>",
+			expected:     fmt.Sprintf("body: %d bytes != 13 bytes", len(primaryBody)),
+		},
+		{
+			name:         "shadow error",
+			statusCode:   http.StatusOK,
+			shadowStatus: http.StatusInternalServerError,
+			shadowErr:    fmt.Errorf("connection refused"),
+			expected:     "shadow error: connection refused",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			httpClient := &http_mock.HttpClientMock{
+				RequestFunc: func(
+					ctx context.Context,
+					method string,
+					address string,
+					header http.Header,
+					parameters string,
+					body io.Reader,
+				) (*values.Response, error) {
+					return &values.Response{StatusCode: tc.shadowStatus, Body: ioutil.NopCloser(strings.NewReader(tc.shadowBody))}, tc.shadowErr
+				},
+			}
+
+			logger := &diffLogger{diffs: make(chan string, 1)}
+			handler := mirroring.New(
+				logger,
+				metrics.New(log.NewNopLogger(), "test"),
+				httpClient,
+				loadbalancing.New(log.NewNopLogger()),
+			)
+
+			service := newService(100)
+			service.Mirror.Diff = &values.ShadowDiff{
+				Headers:       []string{"content-type"},
+				IgnoredFields: []string{"meta.request_id", "items.updated_at"},
+				LogPercent:    100,
+			}
+
+			shadow := handler.Mirror(context.Background(), service, nil, newRequest())
+			captured := shadow.Capture(&values.Response{
+				StatusCode: tc.statusCode,
+				Header:     tc.header,
+				Body:       ioutil.NopCloser(strings.NewReader(primaryBody)),
+			}, nil)
+
+			// the body is compared once it was streamed to the client
+			body, err := ioutil.ReadAll(captured.Body)
+			assert.Nil(t, err)
+			assert.Equal(t, primaryBody, string(body))
+			assert.Nil(t, captured.Body.Close())
+
+			select {
+			case diff := <-logger.diffs:
+				assert.Equal(t, tc.expected, diff)
+			case <-time.After(100 * time.Millisecond):
+				assert.Empty(t, tc.expected)
+			}
+		})
+	}
+}
+
+func TestMirrorDoesntCompareFailedRequests(t *testing.T) {
+	httpClient := &http_mock.HttpClientMock{
+		RequestFunc: func(
+			ctx context.Context,
+			method string,
+			address string,
+			header http.Header,
+			parameters string,
+			body io.Reader,
+		) (*values.Response, error) {
+			return &values.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("shadow"))}, nil
+		},
+	}
+
+	logger := &diffLogger{diffs: make(chan string, 1)}
+	handler := mirroring.New(
+		logger,
+		metrics.New(log.NewNopLogger(), "test"),
+		httpClient,
+		loadbalancing.New(log.NewNopLogger()),
+	)
+
+	service := newService(100)
+	service.Mirror.Diff = &values.ShadowDiff{LogPercent: 100}
+
+	shadow := handler.Mirror(context.Background(), service, nil, newRequest())
+	shadow.Capture(&values.Response{StatusCode: http.StatusServiceUnavailable}, fmt.Errorf("no hosts available"))
+
+	select {
+	case diff := <-logger.diffs:
+		assert.Fail(t, "unexpected comparison", diff)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMirrorWithoutDiffReturnsNoShadow(t *testing.T) {
+	httpClient := &http_mock.HttpClientMock{
+		RequestFunc: func(
+			ctx context.Context,
+			method string,
+			address string,
+			header http.Header,
+			parameters string,
+			body io.Reader,
+		) (*values.Response, error) {
+			return &values.Response{StatusCode: http.StatusOK}, nil
+		},
+	}
+	handler := newMirrorHandler(httpClient)
+
+	shadow := handler.Mirror(context.Background(), newService(100), nil, newRequest())
+
+	assert.Nil(t, shadow)
+	// a nil shadow ignores the response
+	response := &values.Response{StatusCode: http.StatusOK}
+	assert.Same(t, response, shadow.Capture(response, nil))
+}
+
+func TestMirrorDoesntCompareIncompleteBodies(t *testing.T) {
+	testCases := []struct {
+		name        string
+		primaryBody string
+		read        int64
+	}{
+		{
+			name:        "body longer than the compared part",
+			primaryBody: strings.Repeat("a", 2<<20),
+			read:        2 << 20,
+		},
+		{
+			name:        "body not sent whole to the client",
+			primaryBody: "primary",
+			read:        3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			httpClient := &http_mock.HttpClientMock{
+				RequestFunc: func(
+					ctx context.Context,
+					method string,
+					address string,
+					header http.Header,
+					parameters string,
+					body io.Reader,
+				) (*values.Response, error) {
+					return &values.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("shadow"))}, nil
+				},
+			}
+
+			logger := &diffLogger{diffs: make(chan string, 1)}
+			handler := mirroring.New(
+				logger,
+				metrics.New(log.NewNopLogger(), "test"),
+				httpClient,
+				loadbalancing.New(log.NewNopLogger()),
+			)
+
+			service := newService(100)
+			service.Mirror.Diff = &values.ShadowDiff{LogPercent: 100}
+
+			shadow := handler.Mirror(context.Background(), service, nil, newRequest())
+			captured := shadow.Capture(&values.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader(tc.primaryBody)),
+			}, nil)
+
+			_, _ = io.CopyN(ioutil.Discard, captured.Body, tc.read)
+			assert.Nil(t, captured.Body.Close())
+
+			select {
+			case diff := <-logger.diffs:
+				assert.Fail(t, "unexpected difference", diff)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+// Package outlierdetection contains the logic that passively watches the
+// responses of the downstream service hosts, ejecting from the load
+// balancing the hosts that keep failing requests until they had some
+// time to recover.
+package outlierdetection
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"go-reverse-proxy/app/common/metrics"
+	"go-reverse-proxy/app/values"
+)
+
+const (
+	HostEjections    = "host_ejections"
+	HostReadmissions = "host_readmissions"
+)
+
+type Handler interface {
+	// Record receives the result of a request sent to a host of a service
+	// and ejects the host when it failed too many requests in a row.
+	// Ejected hosts are readmitted automatically once their ejection ends.
+	Record(
+		ctx context.Context,
+		service *values.Service,
+		host *values.Host,
+		statusCode int,
+		err error,
+	)
+}
+
+type DefaultHandler struct {
+	logger     log.Logger
+	metricsCtx *metrics.MetricsContext
+
+	// map of *values.Host to the *hostStats of the host
+	stats sync.Map
+
+	// guards the ejections, which are rare, so that the number
+	// of ejected hosts of a service is always consistent
+	mu      sync.Mutex
+	ejected map[*values.Service]int
+}
+
+// hostStats keeps track of the recent failures of a host
+type hostStats struct {
+	// failed requests since the last success, accessed atomically
+	consecutiveErrors int64
+
+	// guarded by the handler mutex
+	ejections    int       // ejections in a row, multiplies the ejection time
+	readmittedAt time.Time // end of the last ejection
+}
+
+func New(
+	logger log.Logger,
+	metricsCtx *metrics.MetricsContext,
+) Handler {
+	var svc Handler
+	svc = &DefaultHandler{
+		logger:     logger,
+		metricsCtx: metricsCtx,
+		ejected:    make(map[*values.Service]int),
+	}
+
+	return svc
+}
+
+func (h *DefaultHandler) Record(
+	ctx context.Context,
+	service *values.Service,
+	host *values.Host,
+	statusCode int,
+	err error,
+) {
+	outlierDetection := service.OutlierDetection
+	if outlierDetection == nil {
+		return
+	}
+
+	// a cancelled request says nothing about the host
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	stats := h.hostStats(host)
+
+	if err == nil && statusCode < http.StatusInternalServerError {
+		// avoid writing to the shared counter when there is nothing to reset
+		if atomic.LoadInt64(&stats.consecutiveErrors) != 0 {
+			atomic.StoreInt64(&stats.consecutiveErrors, 0)
+		}
+		return
+	}
+
+	if atomic.AddInt64(&stats.consecutiveErrors, 1) < int64(outlierDetection.ConsecutiveErrors) {
+		return
+	}
+
+	h.eject(service, host, stats)
+}
+
+// eject removes the host from the load balancing for a time that grows
+// with the number of times in a row that it was ejected. At least one host
+// of a service can always be ejected, but ejecting more is only allowed
+// while the ejected hosts don't exceed the configured percentage.
+func (h *DefaultHandler) eject(
+	service *values.Service,
+	host *values.Host,
+	stats *hostStats,
+) {
+	outlierDetection := service.OutlierDetection
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	atomic.StoreInt64(&stats.consecutiveErrors, 0)
+
+	if host.IsEjected() {
+		return
+	}
+
+	ejected := h.ejected[service]
+	if ejected > 0 && (ejected+1)*100 > outlierDetection.MaxEjectionPercent*len(service.Hosts) {
+		h.logger.Log("module", "outlierdetection", "service", service.Name, "host", host.ToURL(), "message", "max ejection percent reached")
+		return
+	}
+
+	// a host that stayed in rotation for long enough starts over
+	if !stats.readmittedAt.IsZero() && time.Since(stats.readmittedAt) > outlierDetection.MaxEjectionTime {
+		stats.ejections = 0
+	}
+
+	stats.ejections++
+	ejectionTime := outlierDetection.BaseEjectionTime * time.Duration(stats.ejections)
+	if ejectionTime > outlierDetection.MaxEjectionTime {
+		ejectionTime = outlierDetection.MaxEjectionTime
+	}
+
+	host.SetEjected(true)
+	h.ejected[service]++
+
+	h.logger.Log("module", "outlierdetection", "service", service.Name, "host", host.ToURL(), "ejected", true, "duration", ejectionTime)
+	h.record(HostEjections, service, host)
+
+	time.AfterFunc(ejectionTime, func() {
+		h.readmit(service, host, stats)
+	})
+}
+
+// readmit puts an ejected host back into the load balancing
+func (h *DefaultHandler) readmit(
+	service *values.Service,
+	host *values.Host,
+	stats *hostStats,
+) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	host.SetEjected(false)
+	h.ejected[service]--
+	stats.readmittedAt = time.Now()
+
+	h.logger.Log("module", "outlierdetection", "service", service.Name, "host", host.ToURL(), "ejected", false)
+	h.record(HostReadmissions, service, host)
+}
+
+// hostStats returns the failure stats of a host, creating
+// them the first time that they are needed
+func (h *DefaultHandler) hostStats(host *values.Host) *hostStats {
+	if stats, ok := h.stats.Load(host); ok {
+		return stats.(*hostStats)
+	}
+
+	stats, _ := h.stats.LoadOrStore(host, &hostStats{})
+	return stats.(*hostStats)
+}
+
+func (h *DefaultHandler) record(name string, service *values.Service, host *values.Host) {
+	lvs := []string{"service", service.Name, "host", host.ToURL()}
+	if err := h.metricsCtx.Record(name, 1, lvs...); err != nil {
+		h.logger.Log("metrics", name, "service", service.Name, "err", err)
+	}
+}
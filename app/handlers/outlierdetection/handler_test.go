@@ -0,0 +1,160 @@
+package outlierdetection_test
+
+import (
+	"context"
+	"fmt"
+	"go-reverse-proxy/app/common/log"
+	"go-reverse-proxy/app/common/metrics"
+	"go-reverse-proxy/app/handlers/outlierdetection"
+	"go-reverse-proxy/app/values"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newService(hosts int, outlierDetection *values.OutlierDetection) *values.Service {
+	service := &values.Service{
+		Name:             "my-service",
+		Domain:           "my-domain.com",
+		OutlierDetection: outlierDetection,
+	}
+
+	for i := 0; i < hosts; i++ {
+		service.Hosts = append(service.Hosts, &values.Host{
+			Address: "127.0.0.1",
+			Port:    5000 + int32(i),
+			Weight:  1,
+		})
+	}
+
+	return service
+}
+
+func newOutlierDetection(baseEjectionTime time.Duration) *values.OutlierDetection {
+	return &values.OutlierDetection{
+		ConsecutiveErrors:  3,
+		BaseEjectionTime:   baseEjectionTime,
+		MaxEjectionTime:    10 * baseEjectionTime,
+		MaxEjectionPercent: 50,
+	}
+}
+
+func recordErrors(handler outlierdetection.Handler, service *values.Service, host *values.Host, count int) {
+	for i := 0; i < count; i++ {
+		handler.Record(context.Background(), service, host, http.StatusBadGateway, nil)
+	}
+}
+
+func TestRecordEjectsAfterConsecutiveErrors(t *testing.T) {
+	metricsCtx := metrics.New(log.NewNopLogger(), "test")
+	handler := outlierdetection.New(log.NewNopLogger(), metricsCtx)
+	service := newService(2, newOutlierDetection(time.Minute))
+	host := service.Hosts[0]
+
+	recordErrors(handler, service, host, 2)
+	assert.False(t, host.IsEjected())
+
+	handler.Record(context.Background(), service, host, http.StatusInternalServerError, fmt.Errorf("connection refused"))
+	assert.True(t, host.IsEjected())
+	assert.False(t, service.Hosts[1].IsEjected())
+	assert.Contains(t, metricsCtx.CounterNames(), outlierdetection.HostEjections)
+}
+
+func TestRecordSuccessResetsConsecutiveErrors(t *testing.T) {
+	handler := outlierdetection.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(2, newOutlierDetection(time.Minute))
+	host := service.Hosts[0]
+
+	recordErrors(handler, service, host, 2)
+	handler.Record(context.Background(), service, host, http.StatusNotFound, nil)
+	recordErrors(handler, service, host, 2)
+
+	assert.False(t, host.IsEjected())
+}
+
+func TestRecordReadmitsAfterEjectionTime(t *testing.T) {
+	metricsCtx := metrics.New(log.NewNopLogger(), "test")
+	handler := outlierdetection.New(log.NewNopLogger(), metricsCtx)
+	service := newService(2, newOutlierDetection(20*time.Millisecond))
+	host := service.Hosts[0]
+
+	recordErrors(handler, service, host, 3)
+	assert.True(t, host.IsEjected())
+
+	assert.Eventually(t, func() bool { return !host.IsEjected() }, time.Second, time.Millisecond)
+	assert.Contains(t, metricsCtx.CounterNames(), outlierdetection.HostReadmissions)
+}
+
+func TestRecordRepeatedEjectionsLastLonger(t *testing.T) {
+	handler := outlierdetection.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(2, newOutlierDetection(50*time.Millisecond))
+	host := service.Hosts[0]
+
+	recordErrors(handler, service, host, 3)
+	begin := time.Now()
+	assert.Eventually(t, func() bool { return !host.IsEjected() }, time.Second, time.Millisecond)
+	firstEjection := time.Since(begin)
+
+	recordErrors(handler, service, host, 3)
+	begin = time.Now()
+	assert.Eventually(t, func() bool { return !host.IsEjected() }, time.Second, time.Millisecond)
+	secondEjection := time.Since(begin)
+
+	assert.GreaterOrEqual(t, int64(secondEjection), int64(90*time.Millisecond))
+	assert.Greater(t, int64(secondEjection), int64(firstEjection))
+}
+
+func TestRecordMaxEjectionPercent(t *testing.T) {
+	handler := outlierdetection.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(4, newOutlierDetection(time.Minute))
+
+	for _, host := range service.Hosts {
+		recordErrors(handler, service, host, 3)
+	}
+
+	var ejected int
+	for _, host := range service.Hosts {
+		if host.IsEjected() {
+			ejected++
+		}
+	}
+
+	assert.Equal(t, 2, ejected)
+}
+
+func TestRecordAlwaysAllowsOneEjection(t *testing.T) {
+	outlierDetection := newOutlierDetection(time.Minute)
+	outlierDetection.MaxEjectionPercent = 10
+
+	handler := outlierdetection.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(3, outlierDetection)
+
+	recordErrors(handler, service, service.Hosts[0], 3)
+	recordErrors(handler, service, service.Hosts[1], 3)
+
+	assert.True(t, service.Hosts[0].IsEjected())
+	assert.False(t, service.Hosts[1].IsEjected())
+}
+
+func TestRecordWithoutOutlierDetection(t *testing.T) {
+	handler := outlierdetection.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(2, nil)
+
+	recordErrors(handler, service, service.Hosts[0], 100)
+
+	assert.False(t, service.Hosts[0].IsEjected())
+}
+
+func TestRecordIgnoresCancelledRequests(t *testing.T) {
+	handler := outlierdetection.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(2, newOutlierDetection(time.Minute))
+	host := service.Hosts[0]
+
+	for i := 0; i < 10; i++ {
+		handler.Record(context.Background(), service, host, http.StatusInternalServerError, fmt.Errorf("request: %w", context.Canceled))
+	}
+
+	assert.False(t, host.IsEjected())
+}
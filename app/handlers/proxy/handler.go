@@ -5,32 +5,48 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-kit/kit/log"
 
 	client "go-reverse-proxy/app/clients/httpclient"
 	"go-reverse-proxy/app/common/metrics"
+	"go-reverse-proxy/app/handlers/circuitbreaker"
+	"go-reverse-proxy/app/handlers/hedging"
 	lb "go-reverse-proxy/app/handlers/loadbalancing"
+	"go-reverse-proxy/app/handlers/mirroring"
+	"go-reverse-proxy/app/handlers/outlierdetection"
+	"go-reverse-proxy/app/handlers/retrybudget"
 	"go-reverse-proxy/app/values"
 )
 
 type Handler interface {
-	// Forward receives a request payload and headers and forwards them to
+	// Forward receives a request body and headers and forwards them to
 	// a downstream service that matches the requested Host. Since downstream
 	// services can be composed of multiple instances, the proxy executes
 	// a load balancing algorithms to choose which instance will receive
-	// the request.
+	// the request. Routes may also answer the request themselves, with
+	// a redirect or a fixed response, without forwarding it. The response
+	// carries the status code to serve even when an error is returned, and
+	// its body is streamed from the instance and must be closed. Request
+	// bodies that don't fit in the replay buffer are streamed to a single
+	// instance, so those requests are never retried, hedged or mirrored.
 	Forward(
 		ctx context.Context,
 		request *values.Request,
-	) ([]byte, int, error)
+	) (*values.Response, error)
 }
 
 type DefaultHandler struct {
-	logger        log.Logger
-	configuration values.Configuration
-	httpClient    client.HttpClient
-	loadBalancer  lb.Handler
+	logger          log.Logger
+	configuration   values.Configuration
+	httpClient      client.HttpClient
+	loadBalancer    lb.Handler
+	outlierDetector outlierdetection.Handler
+	circuitBreaker  circuitbreaker.Handler
+	retryBudget     retrybudget.Handler
+	hedger          hedging.Handler
+	mirror          mirroring.Handler
 }
 
 func New(
@@ -39,13 +55,23 @@ func New(
 	configuration values.Configuration,
 	httpClient client.HttpClient,
 	loadBalancer lb.Handler,
+	outlierDetector outlierdetection.Handler,
+	circuitBreaker circuitbreaker.Handler,
+	retryBudget retrybudget.Handler,
+	hedger hedging.Handler,
+	mirror mirroring.Handler,
 ) Handler {
 	var svc Handler
 	svc = &DefaultHandler{
-		logger:        logger,
-		configuration: configuration,
-		httpClient:    httpClient,
-		loadBalancer:  loadBalancer,
+		logger:          logger,
+		configuration:   configuration,
+		httpClient:      httpClient,
+		loadBalancer:    loadBalancer,
+		outlierDetector: outlierDetector,
+		circuitBreaker:  circuitBreaker,
+		retryBudget:     retryBudget,
+		hedger:          hedger,
+		mirror:          mirror,
 	}
 
 	svc = InstrumentationMiddleware{Next: svc, MC: metricsCtx}
@@ -56,19 +82,53 @@ func New(
 func (h *DefaultHandler) Forward(
 	ctx context.Context,
 	request *values.Request,
-) ([]byte, int, error) {
+) (*values.Response, error) {
+	// find out who the client is before the request is matched
+	request = h.configuration.TrustedProxies.ResolveClient(request)
+
 	service := h.configuration.GetServiceByDomain(request.HostHeader)
 	if service == nil {
-		return []byte{}, http.StatusNotFound, nil
+		return &values.Response{StatusCode: http.StatusNotFound}, nil
+	}
+
+	route := service.MatchRoute(request)
+	forwarded := service.ApplyRequest(route, route.RewriteRequest(request))
+
+	// redirects and fixed responses never reach a host
+	if response := route.Respond(forwarded); response != nil {
+		service.ApplyResponse(route, request, response)
+		return response, nil
+	}
+
+	// the body is held in memory when it fits in the replay buffer, so
+	// that the request can be sent more than once
+	body, err := values.NewReplayableBody(request.Body, h.configuration.GetReplayBufferSize())
+	if err != nil {
+		return &values.Response{StatusCode: http.StatusBadRequest},
+			fmt.Errorf("failed to read the request body: %w", err)
+	}
+
+	// the copy is sent in the background, without delaying the request,
+	// and it is the request that the hosts receive, rewritten and with
+	// the header rules applied, so that both responses can be compared
+	var shadow *mirroring.Shadow
+	if body.Replayable() {
+		mirrored, _ := withBody(forwarded, body)
+		shadow = h.mirror.Mirror(ctx, service, route, mirrored)
 	}
 
-	responseBody, statusCode, err := h.retryableForwarding(
+	response, err := h.retryableForwarding(
 		ctx,
-		request,
-		service,
+		forwarded,
+		body,
+		service.Target(route, request),
 	)
 
-	return responseBody, statusCode, err
+	response = shadow.Capture(response, err)
+
+	service.ApplyResponse(route, request, response)
+
+	return response, err
 }
 
 // retryableForwarding tries to perform a request to the service instance
@@ -77,60 +137,144 @@ func (h *DefaultHandler) Forward(
 func (h *DefaultHandler) retryableForwarding(
 	ctx context.Context,
 	request *values.Request,
+	body *values.ReplayableBody,
 	service *values.Service,
-) ([]byte, int, error) {
-	var responseBody []byte
-	var statusCode int
+) (*values.Response, error) {
+	var response *values.Response
 	var err error
-	var retryCount int
+	var attempts int
 	shouldRetry := true
 
-	for shouldRetry {
-		// get next service instance to request to
-		host := service.GetNextHost()
+	policy := h.configuration.GetRetryPolicy(service)
 
-		// build downstream service url
-		url := fmt.Sprintf("%s/%s", host.ToURL(), request.Endpoint)
+	// every request earns the service a share of a retry. The HTTP client
+	// sends each attempt once, so that every retry is paid from the budget.
+	h.retryBudget.Deposit(ctx, service)
 
-		// call HTTP client
-		responseBody, statusCode, err = h.httpClient.Request(
-			ctx,
-			request.Method,
-			url,
-			request.Header,
-			request.Parameters,
-			request.Payload,
-		)
+	for shouldRetry {
+		// get the service instance chosen by the load balancer
+		host := h.nextHost(ctx, service, request)
+		if host == nil {
+			return &values.Response{StatusCode: http.StatusServiceUnavailable},
+				fmt.Errorf("service %s has no hosts available", service.Name)
+		}
 
-		// set the next instance to be used, according to the load
-		// balancing algorithm
-		h.loadBalancer.SetNextHost(ctx, service)
+		// send the request, hedging it to a second instance
+		// when the first one is slow to answer
+		response, err = h.hedgedSend(ctx, &policy, service, host, request, body)
 
-		retryCount++
+		attempts++
 
 		// verify if the request should be retried to a different instance
-		shouldRetry = h.shouldRetryForwarding(retryCount, statusCode)
+		// and if the retry budget of the service allows it, waiting a bit
+		// before doing so. A body that was streamed can't be sent again.
+		shouldRetry = body.Replayable() &&
+			h.shouldRetryForwarding(ctx, &policy, request, attempts, response.StatusCode, err) &&
+			h.retryBudget.Withdraw(ctx, service) &&
+			h.backoff(ctx, &policy, attempts)
+
+		if shouldRetry {
+			response.Close()
+		}
 	}
 
-	return responseBody, statusCode, err
+	return response, err
 }
 
-// shouldRetryForwarding checks if a request should be retried to a different
-// instance by verifying if the number of retries has not reached the configured
-// limit, and if the status code is part of the RetryableStatusCodes list.
-func (h *DefaultHandler) shouldRetryForwarding(retryCount int, statusCode int) bool {
-	var shouldRetry bool
+// send forwards the request to an instance of the service, letting the
+// load balancer know that the instance is busy while the request is in
+// flight and reporting the result to the modules that watch the instances.
+// A request is in flight until the body of its response is closed, since
+// the instance is still busy streaming it, so that long downloads keep
+// counting for the load balancer. Its latency is the time until the
+// response headers arrive, so that large or slow downloads don't make
+// the instance look slow.
+func (h *DefaultHandler) send(
+	ctx context.Context,
+	policy *values.RetryPolicy,
+	service *values.Service,
+	host *values.Host,
+	request *values.Request,
+	body *values.ReplayableBody,
+) (*values.Response, error) {
+	request, err := withBody(request, body)
+	if err != nil {
+		return &values.Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	// build downstream service url
+	url := fmt.Sprintf("%s/%s", host.ToURL(), request.Endpoint)
 
-	if retryCount > h.configuration.MaxForwardRetries {
-		return shouldRetry
+	// the attempt lasts until the body of its response is closed
+	attemptCtx, cancel := attemptContext(ctx, policy)
+
+	h.loadBalancer.RequestStarted(ctx, service, host)
+	begin := time.Now()
+	response, err := h.httpClient.Request(
+		attemptCtx,
+		request.Method,
+		url,
+		request.Header,
+		request.Parameters,
+		request.Body,
+	)
+	latency := time.Since(begin)
+
+	finish := func() {
+		cancel()
+		h.loadBalancer.RequestFinished(ctx, service, host, latency)
+	}
+
+	if err != nil || response.Body == nil {
+		finish()
+	} else {
+		response.Body = client.OnClose(response.Body, finish)
+	}
+
+	// eject the instance if it keeps failing requests, and stop
+	// sending requests to it if too many of them fail
+	h.outlierDetector.Record(ctx, service, host, response.StatusCode, err)
+	h.circuitBreaker.Record(ctx, service, host, response.StatusCode, err)
+
+	if err == nil {
+		h.hedger.Observe(ctx, service, latency)
+	}
+
+	return response, err
+}
+
+// withBody returns a copy of a request that reads its body from the start
+func withBody(request *values.Request, body *values.ReplayableBody) (*values.Request, error) {
+	reader, err := body.Reader()
+	if err != nil {
+		return nil, err
 	}
 
-	for _, status := range h.configuration.RetryableStatusCodes {
-		if statusCode == status {
-			shouldRetry = true
-			break
+	sent := *request
+	sent.Body = reader
+
+	return &sent, nil
+}
+
+// nextHost asks the load balancer for an instance whose circuit breaker
+// lets the request through. When every circuit is open the load balancer
+// keeps choosing instances that are refused, so it gives up after asking
+// as many times as there are instances.
+func (h *DefaultHandler) nextHost(
+	ctx context.Context,
+	service *values.Service,
+	request *values.Request,
+) *values.Host {
+	for attempt := 0; attempt < len(service.Hosts); attempt++ {
+		host := h.loadBalancer.NextHost(ctx, service, request)
+		if host == nil {
+			return nil
+		}
+
+		if h.circuitBreaker.Allow(ctx, service, host) {
+			return host
 		}
 	}
 
-	return shouldRetry
+	return nil
 }
@@ -2,19 +2,48 @@ package proxy_test
 
 import (
 	"context"
+	"fmt"
+	"go-reverse-proxy/app/clients/httpclient"
 	"go-reverse-proxy/app/common/log"
 	"go-reverse-proxy/app/common/metrics"
+	"go-reverse-proxy/app/handlers/circuitbreaker"
+	"go-reverse-proxy/app/handlers/hedging"
 	"go-reverse-proxy/app/handlers/loadbalancing"
+	"go-reverse-proxy/app/handlers/mirroring"
+	"go-reverse-proxy/app/handlers/outlierdetection"
 	"go-reverse-proxy/app/handlers/proxy"
+	"go-reverse-proxy/app/handlers/retrybudget"
 	"go-reverse-proxy/app/values"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 
 	http_mock "go-reverse-proxy/mocks/app/clients/httpclient"
+	lb_mock "go-reverse-proxy/mocks/app/handlers/loadbalancing"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// readBody reads and closes the body of a response
+func readBody(t testing.TB, response *values.Response) string {
+	if response.Body == nil {
+		return ""
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	assert.Nil(t, err)
+
+	return string(body)
+}
+
 func newProxyHandler(
 	configuration *values.Configuration,
 ) (proxy.Handler, *http_mock.HttpClientMock, loadbalancing.Handler) {
@@ -29,6 +58,11 @@ func newProxyHandler(
 		*configuration,
 		httpClient,
 		loadBalancer,
+		outlierdetection.New(logger, metrics.New(log.NewNopLogger(), "test")),
+		circuitbreaker.New(logger, metrics.New(log.NewNopLogger(), "test")),
+		retrybudget.New(logger, metrics.New(log.NewNopLogger(), "test")),
+		hedging.New(logger, metrics.New(log.NewNopLogger(), "test")),
+		mirroring.New(logger, metrics.New(log.NewNopLogger(), "test"), httpClient, loadBalancer),
 	), httpClient, loadBalancer
 }
 
@@ -52,7 +86,6 @@ func TestForward(t *testing.T) {
 						Port:    5001,
 					},
 				},
-				NextHostIndex: 0,
 			},
 		},
 		RetryableStatusCodes: []int{},
@@ -69,12 +102,12 @@ func TestForward(t *testing.T) {
 		address string,
 		header http.Header,
 		parameters string,
-		payload []byte,
-	) ([]byte, int, error) {
-		return []byte{}, http.StatusOK, nil
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{StatusCode: http.StatusOK}, nil
 	}
 
-	response, status, err := handler.Forward(
+	response, err := handler.Forward(
 		context.Background(),
 		&values.Request{
 			Method:     "GET",
@@ -82,13 +115,12 @@ func TestForward(t *testing.T) {
 			Header:     http.Header{},
 			HostHeader: "my-domain.com",
 			Parameters: "",
-			Payload:    []byte{},
 		},
 	)
 
 	assert.Nil(t, err)
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, []byte{}, response)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "", readBody(t, response))
 	assert.Equal(t, 1, len(httpClient.RequestCalls()))
 	assert.Equal(t, "127.0.0.1:5000/api/v1", httpClient.RequestCalls()[0].Address)
 	assert.Equal(t, "GET", httpClient.RequestCalls()[0].Method)
@@ -107,7 +139,7 @@ func TestForwardServiceNotFound(t *testing.T) {
 		configuration,
 	)
 
-	response, status, err := handler.Forward(
+	response, err := handler.Forward(
 		context.Background(),
 		&values.Request{
 			Method:     "GET",
@@ -115,13 +147,12 @@ func TestForwardServiceNotFound(t *testing.T) {
 			Header:     http.Header{},
 			HostHeader: "my-domain.com",
 			Parameters: "",
-			Payload:    []byte{},
 		},
 	)
 
 	assert.Nil(t, err)
-	assert.Equal(t, http.StatusNotFound, status)
-	assert.Equal(t, []byte{}, response)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	assert.Equal(t, "", readBody(t, response))
 }
 
 func TestForwardWithRetriesExceeded(t *testing.T) {
@@ -144,7 +175,6 @@ func TestForwardWithRetriesExceeded(t *testing.T) {
 						Port:    5001,
 					},
 				},
-				NextHostIndex: 0,
 			},
 		},
 		RetryableStatusCodes: []int{http.StatusInternalServerError},
@@ -161,12 +191,12 @@ func TestForwardWithRetriesExceeded(t *testing.T) {
 		address string,
 		header http.Header,
 		parameters string,
-		payload []byte,
-	) ([]byte, int, error) {
-		return []byte{}, http.StatusInternalServerError, nil
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{StatusCode: http.StatusInternalServerError}, nil
 	}
 
-	response, status, err := handler.Forward(
+	response, err := handler.Forward(
 		context.Background(),
 		&values.Request{
 			Method:     "GET",
@@ -174,15 +204,1397 @@ func TestForwardWithRetriesExceeded(t *testing.T) {
 			Header:     http.Header{},
 			HostHeader: "my-domain.com",
 			Parameters: "",
-			Payload:    []byte{},
 		},
 	)
 
 	assert.Nil(t, err)
-	assert.Equal(t, http.StatusInternalServerError, status)
-	assert.Equal(t, []byte{}, response)
+	assert.Equal(t, http.StatusInternalServerError, response.StatusCode)
+	assert.Equal(t, "", readBody(t, response))
 	assert.Equal(t, 3, len(httpClient.RequestCalls()))
 	assert.Equal(t, "127.0.0.1:5000/api/v1", httpClient.RequestCalls()[0].Address)
 	assert.Equal(t, "127.0.0.1:5001/api/v1", httpClient.RequestCalls()[1].Address)
 	assert.Equal(t, "127.0.0.1:5000/api/v1", httpClient.RequestCalls()[2].Address)
 }
+
+func TestForwardTracksOutstandingRequests(t *testing.T) {
+	service := &values.Service{
+		Name:   "my-service",
+		Domain: "my-domain.com",
+		Hosts: []*values.Host{
+			{
+				Address: "127.0.0.1",
+				Port:    5000,
+			},
+		},
+	}
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": service,
+		},
+	}
+
+	var calls []string
+	loadBalancer := &lb_mock.HandlerMock{
+		NextHostFunc: func(ctx context.Context, service *values.Service, request *values.Request) *values.Host {
+			calls = append(calls, "NextHost")
+			return service.Hosts[0]
+		},
+		RequestStartedFunc: func(ctx context.Context, service *values.Service, host *values.Host) {
+			calls = append(calls, "RequestStarted")
+		},
+		RequestFinishedFunc: func(ctx context.Context, service *values.Service, host *values.Host, latency time.Duration) {
+			calls = append(calls, "RequestFinished")
+		},
+	}
+	httpClient := &http_mock.HttpClientMock{
+		RequestFunc: func(
+			ctx context.Context,
+			method string,
+			address string,
+			header http.Header,
+			parameters string,
+			body io.Reader,
+		) (*values.Response, error) {
+			calls = append(calls, "Request")
+			return &values.Response{StatusCode: http.StatusOK}, nil
+		},
+	}
+
+	handler := proxy.New(
+		log.NewNopLogger(),
+		metrics.New(log.NewNopLogger(), "test"),
+		*configuration,
+		httpClient,
+		loadBalancer,
+		outlierdetection.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test")),
+		circuitbreaker.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test")),
+		retrybudget.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test")),
+		hedging.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test")),
+		mirroring.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"), httpClient, loadBalancer),
+	)
+
+	response, err := handler.Forward(
+		context.Background(),
+		&values.Request{
+			Method:     "GET",
+			Endpoint:   "api/v1",
+			Header:     http.Header{},
+			HostHeader: "my-domain.com",
+		},
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, []string{"NextHost", "RequestStarted", "Request", "RequestFinished"}, calls)
+	assert.Equal(t, service.Hosts[0], loadBalancer.RequestStartedCalls()[0].Host)
+	assert.Equal(t, service.Hosts[0], loadBalancer.RequestFinishedCalls()[0].Host)
+}
+
+func TestForwardStreamedBodyKeepsRequestInFlight(t *testing.T) {
+	service := &values.Service{
+		Name:   "my-service",
+		Domain: "my-domain.com",
+		Hosts: []*values.Host{
+			{
+				Address: "127.0.0.1",
+				Port:    5000,
+			},
+		},
+	}
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": service,
+		},
+	}
+
+	loadBalancer := &lb_mock.HandlerMock{
+		NextHostFunc: func(ctx context.Context, service *values.Service, request *values.Request) *values.Host {
+			return service.Hosts[0]
+		},
+		RequestStartedFunc: func(ctx context.Context, service *values.Service, host *values.Host) {},
+		RequestFinishedFunc: func(ctx context.Context, service *values.Service, host *values.Host, latency time.Duration) {
+		},
+	}
+	httpClient := &http_mock.HttpClientMock{
+		RequestFunc: func(
+			ctx context.Context,
+			method string,
+			address string,
+			header http.Header,
+			parameters string,
+			body io.Reader,
+		) (*values.Response, error) {
+			return &values.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader("a long download")),
+			}, nil
+		},
+	}
+
+	handler := proxy.New(
+		log.NewNopLogger(),
+		metrics.New(log.NewNopLogger(), "test"),
+		*configuration,
+		httpClient,
+		loadBalancer,
+		outlierdetection.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test")),
+		circuitbreaker.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test")),
+		retrybudget.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test")),
+		hedging.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test")),
+		mirroring.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"), httpClient, loadBalancer),
+	)
+
+	response, err := handler.Forward(
+		context.Background(),
+		&values.Request{
+			Method:     "GET",
+			Endpoint:   "download",
+			Header:     http.Header{},
+			HostHeader: "my-domain.com",
+		},
+	)
+
+	assert.Nil(t, err)
+	assert.Len(t, loadBalancer.RequestStartedCalls(), 1)
+
+	// the host is still streaming the body, so the request is in flight
+	_, err = io.CopyN(ioutil.Discard, response.Body, 6)
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, loadBalancer.RequestFinishedCalls(), 0)
+
+	assert.Equal(t, " download", readBody(t, response))
+
+	// the latency doesn't include the time spent streaming the body
+	if assert.Len(t, loadBalancer.RequestFinishedCalls(), 1) {
+		assert.Less(t, int64(loadBalancer.RequestFinishedCalls()[0].Latency), int64(20*time.Millisecond))
+	}
+}
+
+func TestForwardSlowBodyDoesNotRaiseLatency(t *testing.T) {
+	service := &values.Service{
+		Name:         "my-service",
+		Domain:       "my-domain.com",
+		LoadBalancer: values.PeakEWMA,
+		DecayTime:    10 * time.Second,
+		Hosts: []*values.Host{
+			{
+				Address: "127.0.0.1",
+				Port:    5000,
+				Weight:  1,
+			},
+			{
+				Address: "127.0.0.1",
+				Port:    5001,
+				Weight:  1,
+			},
+		},
+	}
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": service,
+		},
+	}
+
+	loadBalancer := loadbalancing.New(log.NewNopLogger())
+	httpClient := &http_mock.HttpClientMock{
+		RequestFunc: func(
+			ctx context.Context,
+			method string,
+			address string,
+			header http.Header,
+			parameters string,
+			body io.Reader,
+		) (*values.Response, error) {
+			return &values.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader("a long download")),
+			}, nil
+		},
+	}
+
+	handler := proxy.New(
+		log.NewNopLogger(),
+		metrics.New(log.NewNopLogger(), "test"),
+		*configuration,
+		httpClient,
+		loadBalancer,
+		outlierdetection.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test")),
+		circuitbreaker.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test")),
+		retrybudget.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test")),
+		hedging.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test")),
+		mirroring.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"), httpClient, loadBalancer),
+	)
+
+	response, err := handler.Forward(
+		context.Background(),
+		&values.Request{
+			Method:     "GET",
+			Endpoint:   "download",
+			Header:     http.Header{},
+			HostHeader: "my-domain.com",
+		},
+	)
+	assert.Nil(t, err)
+
+	// the client reads the body slowly
+	_, err = io.CopyN(ioutil.Discard, response.Body, 6)
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, " download", readBody(t, response))
+
+	slow, other := service.Hosts[0], service.Hosts[1]
+	if !strings.HasPrefix(httpClient.RequestCalls()[0].Address, slow.ToURL()+"/") {
+		slow, other = other, slow
+	}
+
+	// the other host answers faster than the client read the body, but
+	// slower than the headers of the streamed response arrived
+	loadBalancer.RequestStarted(context.Background(), service, other)
+	loadBalancer.RequestFinished(context.Background(), service, other, 10*time.Millisecond)
+
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, slow, loadBalancer.NextHost(context.Background(), service, &values.Request{}))
+	}
+}
+
+func TestForwardServiceWithoutHosts(t *testing.T) {
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts:  []*values.Host{},
+			},
+		},
+	}
+
+	handler, httpClient, _ := newProxyHandler(
+		configuration,
+	)
+
+	response, err := handler.Forward(
+		context.Background(),
+		&values.Request{
+			Method:     "GET",
+			Endpoint:   "api/v1",
+			Header:     http.Header{},
+			HostHeader: "my-domain.com",
+		},
+	)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	assert.Len(t, httpClient.RequestCalls(), 0)
+}
+
+func newConcurrentConfiguration() *values.Configuration {
+	return &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:         "my-service",
+				Domain:       "my-domain.com",
+				LoadBalancer: values.RoundRobin,
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					{
+						Address: "127.0.0.1",
+						Port:    5001,
+					},
+					{
+						Address: "127.0.0.1",
+						Port:    5002,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestForwardConcurrent(t *testing.T) {
+	handler, httpClient, _ := newProxyHandler(
+		newConcurrentConfiguration(),
+	)
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 32; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 300; i++ {
+				_, _ = handler.Forward(
+					context.Background(),
+					&values.Request{
+						Method:     "GET",
+						Endpoint:   "api/v1",
+						Header:     http.Header{},
+						HostHeader: "my-domain.com",
+					},
+				)
+			}
+		}()
+	}
+	wg.Wait()
+
+	counts := make(map[string]int)
+	for _, call := range httpClient.RequestCalls() {
+		counts[call.Address]++
+	}
+
+	assert.Equal(t, map[string]int{
+		"127.0.0.1:5000/api/v1": 3200,
+		"127.0.0.1:5001/api/v1": 3200,
+		"127.0.0.1:5002/api/v1": 3200,
+	}, counts)
+}
+
+func BenchmarkForwardParallel(b *testing.B) {
+	logger := log.NewNopLogger()
+	httpClient := &http_mock.HttpClientMock{
+		RequestFunc: func(
+			ctx context.Context,
+			method string,
+			address string,
+			header http.Header,
+			parameters string,
+			body io.Reader,
+		) (*values.Response, error) {
+			return &values.Response{StatusCode: http.StatusOK}, nil
+		},
+	}
+
+	loadBalancer := loadbalancing.New(logger)
+	handler := proxy.New(
+		logger,
+		metrics.New(logger, "benchmark"),
+		*newConcurrentConfiguration(),
+		httpClient,
+		loadBalancer,
+		outlierdetection.New(logger, metrics.New(logger, "benchmark")),
+		circuitbreaker.New(logger, metrics.New(logger, "benchmark")),
+		retrybudget.New(logger, metrics.New(logger, "benchmark")),
+		hedging.New(logger, metrics.New(logger, "benchmark")),
+		mirroring.New(logger, metrics.New(logger, "benchmark"), httpClient, loadBalancer),
+	)
+
+	request := &values.Request{
+		Method:     "GET",
+		Endpoint:   "api/v1",
+		Header:     http.Header{},
+		HostHeader: "my-domain.com",
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = handler.Forward(context.Background(), request)
+		}
+	})
+}
+
+func TestForwardEjectsFailingHost(t *testing.T) {
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					{
+						Address: "127.0.0.1",
+						Port:    5001,
+					},
+				},
+				OutlierDetection: &values.OutlierDetection{
+					ConsecutiveErrors:  2,
+					BaseEjectionTime:   time.Minute,
+					MaxEjectionTime:    time.Minute,
+					MaxEjectionPercent: 50,
+				},
+			},
+		},
+	}
+
+	handler, httpClient, _ := newProxyHandler(
+		configuration,
+	)
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		if address == "127.0.0.1:5000/api/v1" {
+			return &values.Response{StatusCode: http.StatusInternalServerError}, fmt.Errorf("connection refused")
+		}
+		return &values.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	for i := 0; i < 6; i++ {
+		_, _ = handler.Forward(
+			context.Background(),
+			&values.Request{
+				Method:     "GET",
+				Endpoint:   "api/v1",
+				Header:     http.Header{},
+				HostHeader: "my-domain.com",
+			},
+		)
+	}
+
+	var addresses []string
+	for _, call := range httpClient.RequestCalls() {
+		addresses = append(addresses, call.Address)
+	}
+
+	// the failing host is only requested until it is ejected
+	assert.Equal(t, []string{
+		"127.0.0.1:5000/api/v1",
+		"127.0.0.1:5001/api/v1",
+		"127.0.0.1:5000/api/v1",
+		"127.0.0.1:5001/api/v1",
+		"127.0.0.1:5001/api/v1",
+		"127.0.0.1:5001/api/v1",
+	}, addresses)
+	assert.True(t, configuration.Services["my-domain.com"].Hosts[0].IsEjected())
+}
+
+func TestForwardOpenCircuitFailsFast(t *testing.T) {
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+				},
+				CircuitBreaker: &values.CircuitBreaker{
+					FailureRateThreshold: 50,
+					MinimumRequests:      2,
+					Window:               time.Minute,
+					OpenTime:             time.Minute,
+					HalfOpenRequests:     1,
+				},
+			},
+		},
+	}
+
+	handler, httpClient, _ := newProxyHandler(
+		configuration,
+	)
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{StatusCode: http.StatusInternalServerError}, fmt.Errorf("connection refused")
+	}
+
+	request := &values.Request{
+		Method:     "GET",
+		Endpoint:   "api/v1",
+		Header:     http.Header{},
+		HostHeader: "my-domain.com",
+	}
+
+	for i := 0; i < 2; i++ {
+		_, _ = handler.Forward(context.Background(), request)
+	}
+
+	response, err := handler.Forward(context.Background(), request)
+
+	// the open circuit refuses the request without calling the host
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	assert.NotNil(t, err)
+	assert.Len(t, httpClient.RequestCalls(), 2)
+}
+
+func TestForwardRoutesToPool(t *testing.T) {
+	v2 := &values.Service{
+		Name:   "my-service/v2",
+		Domain: "my-domain.com",
+		Hosts: []*values.Host{
+			{
+				Address: "127.0.0.2",
+				Port:    5000,
+			},
+		},
+	}
+
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+				},
+				Pools: []*values.Service{v2},
+				Routes: []*values.Route{
+					{
+						Match: values.RouteMatch{Prefix: "/api/v2/"},
+						Pool:  v2,
+					},
+				},
+			},
+		},
+	}
+
+	handler, httpClient, _ := newProxyHandler(
+		configuration,
+	)
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	for _, endpoint := range []string{"api/v2/users", "api/v1/users"} {
+		response, err := handler.Forward(
+			context.Background(),
+			&values.Request{
+				Method:     "GET",
+				Endpoint:   endpoint,
+				Header:     http.Header{},
+				HostHeader: "my-domain.com",
+			},
+		)
+
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+
+	assert.Len(t, httpClient.RequestCalls(), 2)
+	assert.Equal(t, "127.0.0.2:5000/api/v2/users", httpClient.RequestCalls()[0].Address)
+	assert.Equal(t, "127.0.0.1:5000/api/v1/users", httpClient.RequestCalls()[1].Address)
+}
+
+func TestForwardDefaultService(t *testing.T) {
+	fallback := &values.Service{
+		Name:   "fallback",
+		Domain: "fallback.com",
+		Hosts: []*values.Host{
+			{
+				Address: "127.0.0.2",
+				Port:    5000,
+			},
+		},
+	}
+
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+				},
+			},
+			"fallback.com": fallback,
+		},
+		DefaultService: fallback,
+	}
+
+	handler, httpClient, _ := newProxyHandler(
+		configuration,
+	)
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	for _, hostHeader := range []string{"My-Domain.com:8080", "unknown.com"} {
+		response, err := handler.Forward(
+			context.Background(),
+			&values.Request{
+				Method:     "GET",
+				Endpoint:   "api/v1",
+				Header:     http.Header{},
+				HostHeader: hostHeader,
+			},
+		)
+
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+
+	assert.Len(t, httpClient.RequestCalls(), 2)
+	assert.Equal(t, "127.0.0.1:5000/api/v1", httpClient.RequestCalls()[0].Address)
+	assert.Equal(t, "127.0.0.2:5000/api/v1", httpClient.RequestCalls()[1].Address)
+}
+
+func TestForwardMirrorsRequests(t *testing.T) {
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+				},
+				Mirror: &values.Mirror{
+					Service: &values.Service{
+						Name:   "my-service/mirror",
+						Domain: "my-domain.com",
+						Hosts: []*values.Host{
+							{
+								Address: "127.0.0.2",
+								Port:    5000,
+							},
+						},
+					},
+					Percent: 100,
+					Header:  values.DefaultMirrorHeader,
+					Timeout: time.Second,
+				},
+				Routes: []*values.Route{
+					{
+						Match:   values.RouteMatch{Prefix: "/old/"},
+						Rewrite: &values.Rewrite{StripPrefix: "/old"},
+						Pool: &values.Service{
+							Name: "my-service/v2",
+							Hosts: []*values.Host{
+								{
+									Address: "127.0.0.1",
+									Port:    5001,
+								},
+							},
+						},
+					},
+				},
+				Headers: &values.HeaderPolicy{
+					Request: &values.HeaderRules{
+						Set: []values.HeaderValue{{Name: "X-Team", Value: "{service}"}},
+					},
+				},
+			},
+		},
+	}
+
+	handler, httpClient, _ := newProxyHandler(
+		configuration,
+	)
+
+	release := make(chan struct{})
+	mirrored := make(chan http.Header, 1)
+	var mirroredAddress string
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		if strings.HasPrefix(address, "127.0.0.2:5000/") {
+			// the mirror is slow and fails, which the client never sees
+			<-release
+			mirroredAddress = address
+			mirrored <- header
+			return &values.Response{StatusCode: http.StatusInternalServerError}, fmt.Errorf("mirror failed")
+		}
+
+		return &values.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("primary"))}, nil
+	}
+
+	response, err := handler.Forward(
+		context.Background(),
+		&values.Request{
+			Method:     "GET",
+			Endpoint:   "old/api/v1",
+			Header:     http.Header{},
+			HostHeader: "my-domain.com",
+		},
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "primary", readBody(t, response))
+
+	close(release)
+
+	// the copy is the request that is forwarded, after the rewrite
+	// of the route and the header rules of the service
+	header := <-mirrored
+	assert.Equal(t, "127.0.0.2:5000/api/v1", mirroredAddress)
+	assert.Equal(t, "true", header.Get(values.DefaultMirrorHeader))
+	assert.Equal(t, "my-service", header.Get("X-Team"))
+}
+
+func TestForwardRewritesPath(t *testing.T) {
+	v2 := &values.Service{
+		Name:   "my-service/v2",
+		Domain: "my-domain.com",
+		Hosts: []*values.Host{
+			{
+				Address: "127.0.0.2",
+				Port:    5000,
+			},
+		},
+	}
+
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+				},
+				Pools: []*values.Service{v2},
+				Routes: []*values.Route{
+					{
+						Match:   values.RouteMatch{Prefix: "/api/v2/"},
+						Pool:    v2,
+						Rewrite: &values.Rewrite{StripPrefix: "/api/v2", AddPrefix: "/internal"},
+					},
+				},
+			},
+		},
+	}
+
+	handler, httpClient, _ := newProxyHandler(
+		configuration,
+	)
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	response, err := handler.Forward(
+		context.Background(),
+		&values.Request{
+			Method:     "GET",
+			Endpoint:   "api/v2/users/a%2Fb",
+			Header:     http.Header{},
+			HostHeader: "my-domain.com",
+			Parameters: "page=2",
+		},
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "127.0.0.2:5000/internal/users/a%2Fb", httpClient.RequestCalls()[0].Address)
+	assert.Equal(t, "page=2", httpClient.RequestCalls()[0].Parameters)
+}
+
+func TestForwardAnswersWithoutForwarding(t *testing.T) {
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+				},
+				Routes: []*values.Route{
+					{
+						Match:   values.RouteMatch{Prefix: "/old/"},
+						Rewrite: &values.Rewrite{StripPrefix: "/old"},
+						Pool: &values.Service{
+							Name: "my-service/v2",
+							Hosts: []*values.Host{
+								{
+									Address: "127.0.0.1",
+									Port:    5001,
+								},
+							},
+						},
+						Redirect: &values.Redirect{
+							StatusCode: http.StatusMovedPermanently,
+							Target:     "https://{host}/new{path}{query}",
+						},
+					},
+					{
+						Match: values.RouteMatch{Prefix: "/maintenance/"},
+						Response: &values.DirectResponse{
+							StatusCode: http.StatusServiceUnavailable,
+							Header:     http.Header{"Content-Type": []string{"application/json"}},
+							Body:       []byte(`{"status":"maintenance"}`),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	handler, httpClient, _ := newProxyHandler(
+		configuration,
+	)
+
+	t.Run("redirect", func(t *testing.T) {
+		response, err := handler.Forward(
+			context.Background(),
+			&values.Request{
+				Method:     "GET",
+				Endpoint:   "old/users",
+				Header:     http.Header{},
+				HostHeader: "my-domain.com",
+				Parameters: "page=2",
+			},
+		)
+
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusMovedPermanently, response.StatusCode)
+		assert.Equal(t, "https://my-domain.com/new/users?page=2", response.Header.Get("Location"))
+	})
+
+	t.Run("direct response", func(t *testing.T) {
+		response, err := handler.Forward(
+			context.Background(),
+			&values.Request{
+				Method:     "GET",
+				Endpoint:   "maintenance/users",
+				Header:     http.Header{},
+				HostHeader: "my-domain.com",
+			},
+		)
+
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+		assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
+		assert.Equal(t, `{"status":"maintenance"}`, readBody(t, response))
+	})
+
+	assert.Len(t, httpClient.RequestCalls(), 0)
+}
+
+func TestForwardReturnsResponseHeaders(t *testing.T) {
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+				},
+			},
+		},
+	}
+
+	handler, httpClient, _ := newProxyHandler(
+		configuration,
+	)
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Content-Type":  []string{"application/json"},
+				"Cache-Control": []string{"no-cache"},
+				"Set-Cookie":    []string{"session=1"},
+			},
+			Body: ioutil.NopCloser(strings.NewReader(`{}`)),
+		}, nil
+	}
+
+	response, err := handler.Forward(
+		context.Background(),
+		&values.Request{
+			Method:     "GET",
+			Endpoint:   "users",
+			Header:     http.Header{},
+			HostHeader: "my-domain.com",
+		},
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", response.Header.Get("Cache-Control"))
+	assert.Equal(t, "session=1", response.Header.Get("Set-Cookie"))
+}
+
+func TestForwardChangesHeaders(t *testing.T) {
+	route := &values.Route{
+		Match: values.RouteMatch{Prefix: "/status/"},
+		Response: &values.DirectResponse{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       []byte("ok"),
+		},
+		Headers: &values.HeaderPolicy{
+			Response: &values.HeaderRules{
+				Set: []values.HeaderValue{{Name: "Cache-Control", Value: "no-store"}},
+			},
+		},
+	}
+
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+				},
+				Routes: []*values.Route{route},
+				Headers: &values.HeaderPolicy{
+					Request: &values.HeaderRules{
+						Remove: []string{"X-Debug"},
+						Set:    []values.HeaderValue{{Name: "X-Request-Id", Value: "{request_id}"}},
+					},
+					Response: &values.HeaderRules{
+						Remove: []string{"Server", "X-Powered-By"},
+						Set:    []values.HeaderValue{{Name: "Strict-Transport-Security", Value: "max-age=31536000"}},
+					},
+				},
+			},
+		},
+	}
+
+	handler, httpClient, _ := newProxyHandler(
+		configuration,
+	)
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Server":       []string{"nginx"},
+				"X-Powered-By": []string{"php"},
+				"Content-Type": []string{"text/html"},
+			},
+		}, nil
+	}
+
+	t.Run("forwarded request", func(t *testing.T) {
+		response, err := handler.Forward(
+			context.Background(),
+			&values.Request{
+				ID:         "abc",
+				Method:     "GET",
+				Endpoint:   "users",
+				Header:     http.Header{"X-Debug": []string{"true"}},
+				HostHeader: "my-domain.com",
+			},
+		)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "abc", httpClient.RequestCalls()[0].Header.Get("X-Request-Id"))
+		assert.Empty(t, httpClient.RequestCalls()[0].Header.Get("X-Debug"))
+		assert.Equal(t, http.Header{
+			"Content-Type":              []string{"text/html"},
+			"Strict-Transport-Security": []string{"max-age=31536000"},
+		}, response.Header)
+	})
+
+	t.Run("direct response", func(t *testing.T) {
+		response, err := handler.Forward(
+			context.Background(),
+			&values.Request{
+				ID:         "abc",
+				Method:     "GET",
+				Endpoint:   "status/",
+				Header:     http.Header{},
+				HostHeader: "my-domain.com",
+			},
+		)
+
+		assert.Nil(t, err)
+		assert.Equal(t, http.Header{
+			"Cache-Control":             []string{"no-store"},
+			"Strict-Transport-Security": []string{"max-age=31536000"},
+		}, response.Header)
+	})
+}
+
+func TestForwardResolvesClient(t *testing.T) {
+	_, internal, _ := net.ParseCIDR("10.0.0.0/8")
+
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+				},
+				Headers: &values.HeaderPolicy{
+					Request: &values.HeaderRules{
+						Set: []values.HeaderValue{{Name: "X-Real-Ip", Value: "{client_ip}"}},
+					},
+				},
+			},
+		},
+		TrustedProxies: values.TrustedProxies{internal},
+	}
+
+	handler, httpClient, _ := newProxyHandler(
+		configuration,
+	)
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	_, err := handler.Forward(
+		context.Background(),
+		&values.Request{
+			Method:     "GET",
+			Endpoint:   "users",
+			Header:     http.Header{"X-Forwarded-For": []string{"203.0.113.7"}},
+			HostHeader: "my-domain.com",
+			RemoteAddr: "10.0.0.2:51000",
+			Scheme:     "https",
+		},
+	)
+
+	assert.Nil(t, err)
+
+	header := httpClient.RequestCalls()[0].Header
+	assert.Equal(t, "203.0.113.7", header.Get("X-Real-Ip"))
+	assert.Equal(t, "203.0.113.7, 10.0.0.2", header.Get("X-Forwarded-For"))
+	assert.Equal(t, "https", header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "my-domain.com", header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "for=10.0.0.2;proto=https;host=my-domain.com", header.Get("Forwarded"))
+}
+
+type failingBody struct{}
+
+func (failingBody) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("client disconnected")
+}
+
+func TestForwardFailsToReadBody(t *testing.T) {
+	configuration := &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+				},
+			},
+		},
+	}
+
+	handler, httpClient, _ := newProxyHandler(
+		configuration,
+	)
+
+	response, err := handler.Forward(
+		context.Background(),
+		&values.Request{
+			Method:     "POST",
+			Endpoint:   "upload",
+			Header:     http.Header{},
+			HostHeader: "my-domain.com",
+			Body:       failingBody{},
+		},
+	)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	assert.Len(t, httpClient.RequestCalls(), 0)
+}
+
+// bodySizes are the sizes of the bodies streamed by the benchmarks, the
+// memory used by a request must not grow with the size of its body
+var bodySizes = []struct {
+	name string
+	size int64
+}{
+	{name: "1MB", size: 1 << 20},
+	{name: "64MB", size: 64 << 20},
+	{name: "1GB", size: 1 << 30},
+}
+
+// endlessReader is a body of any length that costs nothing to read
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// newUpstream starts a local server that runs the upstream handler and
+// returns it as a host of a service
+func newUpstream(tb testing.TB, upstream http.HandlerFunc) *values.Host {
+	server := httptest.NewServer(upstream)
+	tb.Cleanup(server.Close)
+
+	address, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	portNumber, _ := strconv.ParseInt(port, 10, 32)
+
+	return &values.Host{
+		Address: address,
+		Port:    int32(portNumber),
+	}
+}
+
+// newRealClientHandler returns a proxy that forwards the requests with
+// the real HTTP client instead of a mock, over the given transport or
+// over the network when it is nil
+func newRealClientHandler(configuration *values.Configuration, transport http.RoundTripper) proxy.Handler {
+	logger := log.NewNopLogger()
+
+	var client *http.Client
+	if transport != nil {
+		client = &http.Client{Transport: transport}
+	}
+	httpClient := httpclient.New(logger, time.Minute, client)
+	loadBalancer := loadbalancing.New(logger)
+
+	return proxy.New(
+		logger,
+		metrics.New(logger, "test"),
+		*configuration,
+		httpClient,
+		loadBalancer,
+		outlierdetection.New(logger, metrics.New(logger, "test")),
+		circuitbreaker.New(logger, metrics.New(logger, "test")),
+		retrybudget.New(logger, metrics.New(logger, "test")),
+		hedging.New(logger, metrics.New(logger, "test")),
+		mirroring.New(logger, metrics.New(logger, "test"), httpClient, loadBalancer),
+	)
+}
+
+// newBenchmarkHandler returns a proxy that forwards the requests with
+// the real HTTP client to a local server that runs the upstream handler
+func newBenchmarkHandler(b *testing.B, upstream http.HandlerFunc) proxy.Handler {
+	return newRealClientHandler(&values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts:  []*values.Host{newUpstream(b, upstream)},
+			},
+		},
+	}, nil)
+}
+
+func BenchmarkForwardUpload(b *testing.B) {
+	handler := newBenchmarkHandler(b, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, bodySize := range bodySizes {
+		b.Run(bodySize.name, func(b *testing.B) {
+			b.SetBytes(bodySize.size)
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				response, err := handler.Forward(
+					context.Background(),
+					&values.Request{
+						Method:     "POST",
+						Endpoint:   "upload",
+						Header:     http.Header{"Content-Length": []string{strconv.FormatInt(bodySize.size, 10)}},
+						HostHeader: "my-domain.com",
+						Body:       io.LimitReader(endlessReader{}, bodySize.size),
+					},
+				)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				readBody(b, response)
+			}
+		})
+	}
+}
+
+func BenchmarkForwardDownload(b *testing.B) {
+	handler := newBenchmarkHandler(b, func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		_, _ = io.Copy(w, io.LimitReader(endlessReader{}, size))
+	})
+
+	for _, bodySize := range bodySizes {
+		b.Run(bodySize.name, func(b *testing.B) {
+			b.SetBytes(bodySize.size)
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				response, err := handler.Forward(
+					context.Background(),
+					&values.Request{
+						Method:     "GET",
+						Endpoint:   "download",
+						Header:     http.Header{},
+						HostHeader: "my-domain.com",
+						Parameters: "size=" + strconv.FormatInt(bodySize.size, 10),
+					},
+				)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				n, err := io.Copy(ioutil.Discard, response.Body)
+				response.Body.Close()
+				if err != nil || n != bodySize.size {
+					b.Fatalf("read %d bytes of %d: %v", n, bodySize.size, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	client "go-reverse-proxy/app/clients/httpclient"
+	"go-reverse-proxy/app/values"
+)
+
+// result of a request sent to an instance
+type result struct {
+	response *values.Response
+	err      error
+	launch   int // index of the request that got the result
+}
+
+// hedgedSend forwards the request to the instance and, when the service
+// hedges its requests and the instance doesn't answer within the hedging
+// delay, sends a copy of the request to a different instance. The first
+// answer wins and the other request is cancelled. Only replayable requests
+// are hedged, since the copy could otherwise apply the request twice, and
+// only when their body is held in memory so that it can be sent twice.
+func (h *DefaultHandler) hedgedSend(
+	ctx context.Context,
+	policy *values.RetryPolicy,
+	service *values.Service,
+	host *values.Host,
+	request *values.Request,
+	body *values.ReplayableBody,
+) (*values.Response, error) {
+	if !policy.IsReplayable(request) || !body.Replayable() {
+		return h.send(ctx, policy, service, host, request, body)
+	}
+
+	delay, ok := h.hedger.Delay(ctx, service)
+	if !ok {
+		return h.send(ctx, policy, service, host, request, body)
+	}
+
+	// each request is cancelled on its own, the one that lost the
+	// race once there is a winner and the winner once its response
+	// body is closed
+	var cancels []context.CancelFunc
+	cancelAll := func(except int) {
+		for i, cancel := range cancels {
+			if i != except {
+				cancel()
+			}
+		}
+	}
+
+	// buffered so that the losing request never blocks
+	results := make(chan result, 2)
+	launch := func(host *values.Host) {
+		launchCtx, cancel := context.WithCancel(ctx)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+
+		go func() {
+			response, err := h.send(launchCtx, policy, service, host, request, body)
+			results <- result{response, err, index}
+		}()
+	}
+
+	launch(host)
+	pending := 1
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var last result
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if hedgeHost := h.hedgeHost(ctx, service, request, host); hedgeHost != nil {
+				launch(hedgeHost)
+				pending++
+			}
+		case last = <-results:
+			pending--
+
+			// a failed request may still be won by the other one
+			if last.err == nil {
+				cancelAll(last.launch)
+				go discard(results, pending)
+
+				winner := *last.response
+				if winner.Body != nil {
+					winner.Body = client.OnClose(winner.Body, cancels[last.launch])
+				} else {
+					cancels[last.launch]()
+				}
+
+				return &winner, nil
+			}
+		}
+	}
+
+	cancelAll(-1)
+
+	return last.response, last.err
+}
+
+// discard closes the responses of the requests that lost the race once
+// they arrive, so that their connections are released
+func discard(results <-chan result, pending int) {
+	for ; pending > 0; pending-- {
+		(<-results).response.Close()
+	}
+}
+
+// hedgeHost chooses the instance that receives the copy of a request,
+// which must be different from the one that received the request and
+// is only chosen while the hedging budget of the service allows it
+func (h *DefaultHandler) hedgeHost(
+	ctx context.Context,
+	service *values.Service,
+	request *values.Request,
+	host *values.Host,
+) *values.Host {
+	if !h.hedger.Allow(ctx, service) {
+		return nil
+	}
+
+	hedgeHost := h.loadBalancer.NextHostExcluding(ctx, service, request, host)
+	if hedgeHost == nil || !h.circuitBreaker.Allow(ctx, service, hedgeHost) {
+		return nil
+	}
+
+	return hedgeHost
+}
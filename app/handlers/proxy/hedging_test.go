@@ -0,0 +1,147 @@
+package proxy_test
+
+import (
+	"context"
+	"go-reverse-proxy/app/values"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newHedgingConfiguration() *values.Configuration {
+	return &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					{
+						Address: "127.0.0.1",
+						Port:    5001,
+					},
+				},
+				Hedging: &values.Hedging{
+					Delay:         10 * time.Millisecond,
+					BudgetPercent: 100,
+				},
+			},
+		},
+	}
+}
+
+func TestForwardHedgesSlowRequest(t *testing.T) {
+	handler, httpClient, _ := newProxyHandler(newHedgingConfiguration())
+
+	var cancelled int32
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		if address == "127.0.0.1:5000/api/v1" {
+			<-ctx.Done()
+			atomic.StoreInt32(&cancelled, 1)
+			return &values.Response{StatusCode: http.StatusInternalServerError}, ctx.Err()
+		}
+		return &values.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("fast"))}, nil
+	}
+
+	response, err := handler.Forward(context.Background(), newRetryRequest())
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "fast", readBody(t, response))
+
+	// the slow request is cancelled once the hedge wins
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&cancelled) == 1 }, time.Second, time.Millisecond)
+
+	calls := httpClient.RequestCalls()
+	assert.Equal(t, 2, len(calls))
+	assert.NotEqual(t, calls[0].Address, calls[1].Address)
+}
+
+func TestForwardDoesNotHedgeFastRequest(t *testing.T) {
+	handler, httpClient, _ := newProxyHandler(newHedgingConfiguration())
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	response, _ := handler.Forward(context.Background(), newRetryRequest())
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, 1, len(httpClient.RequestCalls()))
+}
+
+func TestForwardDoesNotHedgeNonIdempotentRequest(t *testing.T) {
+	handler, httpClient, _ := newProxyHandler(newHedgingConfiguration())
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		time.Sleep(30 * time.Millisecond)
+		return &values.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	request := newRetryRequest()
+	request.Method = "POST"
+
+	response, _ := handler.Forward(context.Background(), request)
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, 1, len(httpClient.RequestCalls()))
+}
+
+func TestForwardHedgeWaitsForOtherRequestOnError(t *testing.T) {
+	handler, httpClient, _ := newProxyHandler(newHedgingConfiguration())
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		if address == "127.0.0.1:5000/api/v1" {
+			time.Sleep(50 * time.Millisecond)
+			return &values.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("slow"))}, nil
+		}
+		return &values.Response{StatusCode: http.StatusInternalServerError}, context.DeadlineExceeded
+	}
+
+	response, err := handler.Forward(context.Background(), newRetryRequest())
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "slow", readBody(t, response))
+}
@@ -42,8 +42,7 @@ func (mw InstrumentationMiddleware) Forward(
 	initCtx context.Context,
 	request *values.Request,
 ) (
-	[]byte,
-	int,
+	*values.Response,
 	error,
 ) {
 	var err error
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	client "go-reverse-proxy/app/clients/httpclient"
+	"go-reverse-proxy/app/values"
+)
+
+// shouldRetryForwarding checks if a request should be retried to a different
+// instance by verifying if the number of attempts has not reached the limit
+// of the retry policy, and if the failure is one that the policy retries.
+// Requests that are not replayable are only retried when they failed
+// before connecting, since the instance may have applied them otherwise.
+func (h *DefaultHandler) shouldRetryForwarding(
+	ctx context.Context,
+	policy *values.RetryPolicy,
+	request *values.Request,
+	attempts int,
+	statusCode int,
+	err error,
+) bool {
+	if attempts >= policy.MaxAttempts {
+		return false
+	}
+
+	// the client is gone, there is nobody to reply to
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if !policy.IsReplayable(request) && (err == nil || !client.IsConnectFailure(err)) {
+		return false
+	}
+
+	if err == nil {
+		return policy.IsRetryableStatus(statusCode)
+	}
+
+	switch {
+	case client.IsConnectFailure(err):
+		return policy.RetryOnConnectFailure
+	case client.IsTimeout(err):
+		return policy.RetryOnTimeout
+	case client.IsReset(err):
+		return policy.RetryOnReset
+	default:
+		return false
+	}
+}
+
+// backoff waits before a retry for a random time, up to the base backoff
+// of the policy doubled for each retry so far, so that retries of many
+// requests don't hit the instances all at once. It returns false if the
+// request is cancelled while waiting.
+func (h *DefaultHandler) backoff(
+	ctx context.Context,
+	policy *values.RetryPolicy,
+	attempts int,
+) bool {
+	if policy.BackoffBase <= 0 {
+		return true
+	}
+
+	// the base is only doubled while it stays below the maximum, so that
+	// the shift can't overflow
+	limit := policy.BackoffMax
+	if shift := attempts - 1; shift < 32 && policy.BackoffBase <= policy.BackoffMax>>shift {
+		limit = policy.BackoffBase << shift
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(limit) + 1)))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// attemptContext limits the time of a single attempt when the
+// policy has a per-try timeout
+func attemptContext(
+	ctx context.Context,
+	policy *values.RetryPolicy,
+) (context.Context, context.CancelFunc) {
+	if policy.PerTryTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, policy.PerTryTimeout)
+}
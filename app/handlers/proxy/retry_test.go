@@ -0,0 +1,508 @@
+package proxy_test
+
+import (
+	"context"
+	"fmt"
+	"go-reverse-proxy/app/values"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRetryConfiguration(retry *values.RetryPolicy) *values.Configuration {
+	return &values.Configuration{
+		Host: &values.Host{
+			Address: "127.0.0.1",
+			Port:    8080,
+		},
+		Services: map[string]*values.Service{
+			"my-domain.com": {
+				Name:   "my-service",
+				Domain: "my-domain.com",
+				Hosts: []*values.Host{
+					{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					{
+						Address: "127.0.0.1",
+						Port:    5001,
+					},
+				},
+				Retry: retry,
+			},
+		},
+		RetryableStatusCodes: []int{http.StatusInternalServerError},
+		MaxForwardRetries:    0,
+	}
+}
+
+func newRetryRequest() *values.Request {
+	return &values.Request{
+		Method:     "GET",
+		Endpoint:   "api/v1",
+		Header:     http.Header{},
+		HostHeader: "my-domain.com",
+	}
+}
+
+func TestForwardServiceRetryPolicyOverridesGlobal(t *testing.T) {
+	handler, httpClient, _ := newProxyHandler(newRetryConfiguration(&values.RetryPolicy{
+		RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+		MaxAttempts:          4,
+		RetryableMethods:     values.DefaultRetryableMethods,
+		BackoffBase:          time.Millisecond,
+		BackoffMax:           2 * time.Millisecond,
+	}))
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{StatusCode: http.StatusServiceUnavailable}, nil
+	}
+
+	response, err := handler.Forward(context.Background(), newRetryRequest())
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	assert.Equal(t, 4, len(httpClient.RequestCalls()))
+}
+
+func TestForwardDoesNotRetryOtherStatusCodes(t *testing.T) {
+	handler, httpClient, _ := newProxyHandler(newRetryConfiguration(&values.RetryPolicy{
+		RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+		MaxAttempts:          4,
+		RetryableMethods:     values.DefaultRetryableMethods,
+	}))
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{StatusCode: http.StatusInternalServerError}, nil
+	}
+
+	response, _ := handler.Forward(context.Background(), newRetryRequest())
+
+	assert.Equal(t, http.StatusInternalServerError, response.StatusCode)
+	assert.Equal(t, 1, len(httpClient.RequestCalls()))
+}
+
+func TestForwardRetriesEnabledFailures(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{
+			name:     "connect failure",
+			err:      &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+			expected: 3,
+		},
+		{
+			name:     "reset",
+			err:      fmt.Errorf("read: %w", syscall.ECONNRESET),
+			expected: 3,
+		},
+		{
+			name:     "timeout",
+			err:      fmt.Errorf("request: %w", context.DeadlineExceeded),
+			expected: 1,
+		},
+		{
+			name:     "unknown",
+			err:      fmt.Errorf("something went wrong"),
+			expected: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, httpClient, _ := newProxyHandler(newRetryConfiguration(&values.RetryPolicy{
+				RetryableStatusCodes:  []int{http.StatusInternalServerError},
+				RetryOnConnectFailure: true,
+				RetryOnReset:          true,
+				MaxAttempts:           3,
+				RetryableMethods:      values.DefaultRetryableMethods,
+			}))
+
+			httpClient.RequestFunc = func(
+				ctx context.Context,
+				method string,
+				address string,
+				header http.Header,
+				parameters string,
+				body io.Reader,
+			) (*values.Response, error) {
+				return &values.Response{StatusCode: http.StatusInternalServerError}, tc.err
+			}
+
+			_, err := handler.Forward(context.Background(), newRetryRequest())
+
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expected, len(httpClient.RequestCalls()))
+		})
+	}
+}
+
+func TestForwardPerTryTimeout(t *testing.T) {
+	handler, httpClient, _ := newProxyHandler(newRetryConfiguration(&values.RetryPolicy{
+		RetryOnTimeout:   true,
+		MaxAttempts:      2,
+		RetryableMethods: values.DefaultRetryableMethods,
+		PerTryTimeout:    10 * time.Millisecond,
+	}))
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		<-ctx.Done()
+		return &values.Response{StatusCode: http.StatusInternalServerError}, ctx.Err()
+	}
+
+	begin := time.Now()
+	_, err := handler.Forward(context.Background(), newRetryRequest())
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, len(httpClient.RequestCalls()))
+	assert.Less(t, int64(time.Since(begin)), int64(time.Second))
+}
+
+func TestForwardStopsRetryingWhenCancelled(t *testing.T) {
+	handler, httpClient, _ := newProxyHandler(newRetryConfiguration(&values.RetryPolicy{
+		RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+		MaxAttempts:          3,
+		RetryableMethods:     values.DefaultRetryableMethods,
+		BackoffBase:          time.Minute,
+		BackoffMax:           time.Minute,
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		cancel()
+		return &values.Response{StatusCode: http.StatusServiceUnavailable}, nil
+	}
+
+	response, _ := handler.Forward(ctx, newRetryRequest())
+
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	assert.Equal(t, 1, len(httpClient.RequestCalls()))
+}
+
+func TestForwardBackoffWithLargeBase(t *testing.T) {
+	handler, httpClient, _ := newProxyHandler(newRetryConfiguration(&values.RetryPolicy{
+		RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+		MaxAttempts:          40,
+		RetryableMethods:     values.DefaultRetryableMethods,
+		BackoffBase:          1 << 40,
+		BackoffMax:           time.Millisecond,
+	}))
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{StatusCode: http.StatusServiceUnavailable}, nil
+	}
+
+	var response *values.Response
+	assert.NotPanics(t, func() {
+		response, _ = handler.Forward(context.Background(), newRetryRequest())
+	})
+
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	assert.Equal(t, 40, len(httpClient.RequestCalls()))
+}
+
+func TestForwardStopsRetryingWhenBudgetIsExhausted(t *testing.T) {
+	configuration := newRetryConfiguration(&values.RetryPolicy{
+		RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+		MaxAttempts:          3,
+		RetryableMethods:     values.DefaultRetryableMethods,
+	})
+	configuration.Services["my-domain.com"].RetryBudget = &values.RetryBudget{
+		RetryPercent:        50,
+		MinRetriesPerSecond: 0,
+		Window:              time.Minute,
+	}
+
+	handler, httpClient, _ := newProxyHandler(configuration)
+
+	httpClient.RequestFunc = func(
+		ctx context.Context,
+		method string,
+		address string,
+		header http.Header,
+		parameters string,
+		body io.Reader,
+	) (*values.Response, error) {
+		return &values.Response{StatusCode: http.StatusServiceUnavailable, Body: ioutil.NopCloser(strings.NewReader("unavailable"))}, nil
+	}
+
+	for i := 0; i < 10; i++ {
+		response, _ := handler.Forward(context.Background(), newRetryRequest())
+
+		// the last response is returned when the proxy stops retrying
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+		assert.Equal(t, "unavailable", readBody(t, response))
+	}
+
+	// without the budget every request would be tried 3 times
+	assert.Equal(t, 15, len(httpClient.RequestCalls()))
+}
+
+func TestForwardRetriesNonIdempotentRequests(t *testing.T) {
+	testCases := []struct {
+		name     string
+		method   string
+		header   http.Header
+		err      error
+		expected int
+	}{
+		{
+			name:     "idempotent method",
+			method:   "PUT",
+			header:   http.Header{},
+			expected: 3,
+		},
+		{
+			name:     "non-idempotent method",
+			method:   "POST",
+			header:   http.Header{},
+			expected: 1,
+		},
+		{
+			name:     "non-idempotent method with idempotency key",
+			method:   "POST",
+			header:   http.Header{"Idempotency-Key": []string{"4f6c3a1e"}},
+			expected: 3,
+		},
+		{
+			name:     "non-idempotent method failing before connecting",
+			method:   "PATCH",
+			header:   http.Header{},
+			err:      &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+			expected: 3,
+		},
+		{
+			name:     "non-idempotent method failing after connecting",
+			method:   "PATCH",
+			header:   http.Header{},
+			err:      &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET},
+			expected: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, httpClient, _ := newProxyHandler(newRetryConfiguration(&values.RetryPolicy{
+				RetryableStatusCodes:  []int{http.StatusServiceUnavailable},
+				RetryOnConnectFailure: true,
+				RetryOnReset:          true,
+				MaxAttempts:           3,
+				RetryableMethods:      values.DefaultRetryableMethods,
+				IdempotencyKeyHeader:  values.DefaultIdempotencyKeyHeader,
+			}))
+
+			httpClient.RequestFunc = func(
+				ctx context.Context,
+				method string,
+				address string,
+				header http.Header,
+				parameters string,
+				body io.Reader,
+			) (*values.Response, error) {
+				if tc.err != nil {
+					return &values.Response{StatusCode: http.StatusInternalServerError}, tc.err
+				}
+				return &values.Response{StatusCode: http.StatusServiceUnavailable}, nil
+			}
+
+			request := newRetryRequest()
+			request.Method = tc.method
+			request.Header = tc.header
+
+			_, _ = handler.Forward(context.Background(), request)
+
+			assert.Equal(t, tc.expected, len(httpClient.RequestCalls()))
+		})
+	}
+}
+
+func TestForwardRetriesOnlyReplayableBodies(t *testing.T) {
+	testCases := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{
+			name:     "body that fits in the replay buffer",
+			body:     "small",
+			expected: 3,
+		},
+		{
+			name:     "body larger than the replay buffer",
+			body:     "larger than the replay buffer",
+			expected: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configuration := newRetryConfiguration(&values.RetryPolicy{
+				RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+				MaxAttempts:          3,
+				RetryableMethods:     []string{"PUT"},
+			})
+			configuration.ReplayBufferSize = 8
+
+			handler, httpClient, _ := newProxyHandler(configuration)
+
+			var received []string
+			httpClient.RequestFunc = func(
+				ctx context.Context,
+				method string,
+				address string,
+				header http.Header,
+				parameters string,
+				body io.Reader,
+			) (*values.Response, error) {
+				read, err := ioutil.ReadAll(body)
+				assert.Nil(t, err)
+				received = append(received, string(read))
+
+				return &values.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Body:       ioutil.NopCloser(strings.NewReader("unavailable")),
+				}, nil
+			}
+
+			request := newRetryRequest()
+			request.Method = "PUT"
+			request.Body = strings.NewReader(tc.body)
+
+			response, err := handler.Forward(context.Background(), request)
+
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+			assert.Equal(t, "unavailable", readBody(t, response))
+			assert.Len(t, received, tc.expected)
+			// every attempt sends the whole body
+			for _, body := range received {
+				assert.Equal(t, tc.body, body)
+			}
+		})
+	}
+}
+
+func TestForwardRetriesStatusCodesOnOtherHosts(t *testing.T) {
+	var failingCalls, healthyCalls int32
+	failing := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failingCalls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	healthy := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&healthyCalls, 1)
+		w.Header().Set("X-Host", "healthy")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	configuration := newRetryConfiguration(&values.RetryPolicy{
+		RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+		MaxAttempts:          2,
+		RetryableMethods:     values.DefaultRetryableMethods,
+	})
+	configuration.Services["my-domain.com"].Hosts = []*values.Host{failing, healthy}
+
+	handler := newRealClientHandler(configuration, nil)
+
+	const requests = 4
+	for i := 0; i < requests; i++ {
+		response, err := handler.Forward(context.Background(), newRetryRequest())
+
+		// the failing host is left for the healthy one, whose
+		// response reaches the client as it was sent
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "healthy", response.Header.Get("X-Host"))
+		readBody(t, response)
+	}
+
+	// the failing host is tried at most once per request
+	assert.LessOrEqual(t, atomic.LoadInt32(&failingCalls), int32(requests))
+	assert.Equal(t, int32(requests), atomic.LoadInt32(&healthyCalls))
+}
+
+// dialFailures fails every request before connecting, counting them
+type dialFailures struct {
+	calls int32
+}
+
+func (d *dialFailures) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&d.calls, 1)
+	return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+}
+
+func TestForwardRetriesAreAllPaidFromTheBudget(t *testing.T) {
+	for _, method := range []string{"GET", "POST"} {
+		t.Run(method, func(t *testing.T) {
+			configuration := newRetryConfiguration(&values.RetryPolicy{
+				RetryableStatusCodes:  []int{http.StatusServiceUnavailable},
+				RetryOnConnectFailure: true,
+				MaxAttempts:           3,
+				RetryableMethods:      values.DefaultRetryableMethods,
+			})
+			configuration.Services["my-domain.com"].RetryBudget = &values.RetryBudget{
+				RetryPercent:        0,
+				MinRetriesPerSecond: 0,
+				Window:              time.Minute,
+			}
+
+			transport := &dialFailures{}
+			handler := newRealClientHandler(configuration, transport)
+
+			request := newRetryRequest()
+			request.Method = method
+
+			_, err := handler.Forward(context.Background(), request)
+
+			// without budget the request is sent once, by the HTTP client too
+			assert.NotNil(t, err)
+			assert.Equal(t, int32(1), atomic.LoadInt32(&transport.calls))
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Package retrybudget contains the logic that limits the retries sent to
+// a downstream service to a share of its recent requests, so that a
+// partial outage doesn't multiply the load of the hosts that are still
+// healthy.
+package retrybudget
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"go-reverse-proxy/app/common/metrics"
+	"go-reverse-proxy/app/values"
+)
+
+const RetryBudgetExhausted = "retry_budget_exhausted"
+
+// number of buckets that the sliding window is split into
+const windowBuckets = 10
+
+type Handler interface {
+	// Deposit records a request sent to a service, which adds
+	// a share of a retry to the budget of the service
+	Deposit(ctx context.Context, service *values.Service)
+	// Withdraw takes a retry from the budget of a service,
+	// returning false when the budget is exhausted
+	Withdraw(ctx context.Context, service *values.Service) bool
+}
+
+type DefaultHandler struct {
+	logger     log.Logger
+	metricsCtx *metrics.MetricsContext
+
+	// map of *values.Service to the *budget of the service
+	budgets sync.Map
+}
+
+// budget counts the requests and retries of a service within the window
+type budget struct {
+	mu      sync.Mutex
+	buckets [windowBuckets]bucket
+}
+
+// bucket counts the requests and retries of a slice of the window
+type bucket struct {
+	slot     int64 // slice of time that the counts belong to
+	requests int
+	retries  int
+}
+
+func New(
+	logger log.Logger,
+	metricsCtx *metrics.MetricsContext,
+) Handler {
+	var svc Handler
+	svc = &DefaultHandler{
+		logger:     logger,
+		metricsCtx: metricsCtx,
+	}
+
+	return svc
+}
+
+func (h *DefaultHandler) Deposit(
+	ctx context.Context,
+	service *values.Service,
+) {
+	retryBudget := service.RetryBudget
+	if retryBudget == nil {
+		return
+	}
+
+	budget := h.budget(service)
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	budget.current(retryBudget.Window).requests++
+}
+
+func (h *DefaultHandler) Withdraw(
+	ctx context.Context,
+	service *values.Service,
+) bool {
+	retryBudget := service.RetryBudget
+	if retryBudget == nil {
+		return true
+	}
+
+	budget := h.budget(service)
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	current := budget.current(retryBudget.Window)
+	requests, retries := budget.totals(current.slot)
+
+	allowed := float64(retryBudget.MinRetriesPerSecond)*retryBudget.Window.Seconds() +
+		float64(retryBudget.RetryPercent*requests)/100
+	if float64(retries) >= allowed {
+		h.record(service)
+		return false
+	}
+
+	current.retries++
+	return true
+}
+
+// current returns the bucket of the current slice of the window,
+// the budget lock must be held
+func (b *budget) current(window time.Duration) *bucket {
+	width := int64(window) / windowBuckets
+	if width == 0 {
+		width = 1
+	}
+
+	slot := time.Now().UnixNano() / width
+
+	current := &b.buckets[slot%windowBuckets]
+	if current.slot != slot {
+		*current = bucket{slot: slot}
+	}
+
+	return current
+}
+
+// totals sums the requests and retries within the window that ends in
+// the given slot, the budget lock must be held
+func (b *budget) totals(slot int64) (int, int) {
+	var requests, retries int
+	for _, counts := range b.buckets {
+		if counts.slot > slot-windowBuckets {
+			requests += counts.requests
+			retries += counts.retries
+		}
+	}
+
+	return requests, retries
+}
+
+// budget returns the retry budget of a service, creating
+// it the first time that it is needed
+func (h *DefaultHandler) budget(service *values.Service) *budget {
+	if b, ok := h.budgets.Load(service); ok {
+		return b.(*budget)
+	}
+
+	b, _ := h.budgets.LoadOrStore(service, &budget{})
+	return b.(*budget)
+}
+
+func (h *DefaultHandler) record(service *values.Service) {
+	if err := h.metricsCtx.Record(RetryBudgetExhausted, 1, "service", service.Name); err != nil {
+		h.logger.Log("metrics", RetryBudgetExhausted, "service", service.Name, "err", err)
+	}
+}
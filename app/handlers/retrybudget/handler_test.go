@@ -0,0 +1,122 @@
+package retrybudget_test
+
+import (
+	"context"
+	"go-reverse-proxy/app/common/log"
+	"go-reverse-proxy/app/common/metrics"
+	"go-reverse-proxy/app/handlers/retrybudget"
+	"go-reverse-proxy/app/values"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newService(retryBudget *values.RetryBudget) *values.Service {
+	return &values.Service{
+		Name:        "my-service",
+		Domain:      "my-domain.com",
+		RetryBudget: retryBudget,
+	}
+}
+
+func withdrawAll(handler retrybudget.Handler, service *values.Service) int {
+	var withdrawn int
+	for handler.Withdraw(context.Background(), service) {
+		withdrawn++
+	}
+
+	return withdrawn
+}
+
+func TestWithdrawMinRetriesPerSecond(t *testing.T) {
+	metricsCtx := metrics.New(log.NewNopLogger(), "test")
+	handler := retrybudget.New(log.NewNopLogger(), metricsCtx)
+	service := newService(&values.RetryBudget{
+		RetryPercent:        20,
+		MinRetriesPerSecond: 5,
+		Window:              2 * time.Second,
+	})
+
+	assert.Equal(t, 10, withdrawAll(handler, service))
+	assert.Contains(t, metricsCtx.CounterNames(), retrybudget.RetryBudgetExhausted)
+}
+
+func TestWithdrawPercentOfRequests(t *testing.T) {
+	handler := retrybudget.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(&values.RetryBudget{
+		RetryPercent:        20,
+		MinRetriesPerSecond: 0,
+		Window:              time.Minute,
+	})
+
+	assert.False(t, handler.Withdraw(context.Background(), service))
+
+	for i := 0; i < 100; i++ {
+		handler.Deposit(context.Background(), service)
+	}
+
+	assert.Equal(t, 20, withdrawAll(handler, service))
+}
+
+func TestWithdrawRefillsAfterWindow(t *testing.T) {
+	handler := retrybudget.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(&values.RetryBudget{
+		RetryPercent:        50,
+		MinRetriesPerSecond: 0,
+		Window:              50 * time.Millisecond,
+	})
+
+	for i := 0; i < 4; i++ {
+		handler.Deposit(context.Background(), service)
+	}
+	assert.Equal(t, 2, withdrawAll(handler, service))
+
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		handler.Deposit(context.Background(), service)
+	}
+	assert.Equal(t, 2, withdrawAll(handler, service))
+}
+
+func TestWithdrawConcurrent(t *testing.T) {
+	handler := retrybudget.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(&values.RetryBudget{
+		RetryPercent:        10,
+		MinRetriesPerSecond: 0,
+		Window:              time.Minute,
+	})
+
+	for i := 0; i < 1000; i++ {
+		handler.Deposit(context.Background(), service)
+	}
+
+	var mu sync.Mutex
+	var withdrawn int
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			count := withdrawAll(handler, service)
+
+			mu.Lock()
+			withdrawn += count
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 100, withdrawn)
+}
+
+func TestWithdrawWithoutRetryBudget(t *testing.T) {
+	handler := retrybudget.New(log.NewNopLogger(), metrics.New(log.NewNopLogger(), "test"))
+	service := newService(nil)
+
+	for i := 0; i < 1000; i++ {
+		assert.True(t, handler.Withdraw(context.Background(), service))
+	}
+}
@@ -0,0 +1,74 @@
+package values
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// DefaultReplayBufferSize is the size of the largest request body that is
+// held in memory so that the request can be sent again on retries
+const DefaultReplayBufferSize = 1 << 20
+
+// ErrBodyNotReplayable is returned when a request body that didn't fit in
+// the replay buffer is read again after it was already streamed
+var ErrBodyNotReplayable = errors.New("the request body is too large to be sent again")
+
+// Type ReplayableBody is the body of a request streamed from the client.
+// Bodies up to the size of the replay buffer are held in memory and can be
+// read any number of times, so that the request can be retried, hedged or
+// mirrored. Larger bodies are streamed to a single host, after the part of
+// them that was buffered, and can only be read once.
+type ReplayableBody struct {
+	buffered []byte
+	rest     io.Reader // rest of the body that didn't fit, nil when it all fit
+
+	mu       sync.Mutex
+	streamed bool // whether the rest of the body was handed out
+}
+
+// NewReplayableBody reads a body into the replay buffer, up to its size.
+// A nil body is an empty one.
+func NewReplayableBody(body io.Reader, bufferSize int64) (*ReplayableBody, error) {
+	if body == nil {
+		return &ReplayableBody{buffered: []byte{}}, nil
+	}
+
+	// one more byte than the buffer tells whether the body fits in it
+	buffered, err := ioutil.ReadAll(io.LimitReader(body, bufferSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(buffered)) <= bufferSize {
+		return &ReplayableBody{buffered: buffered}, nil
+	}
+
+	return &ReplayableBody{buffered: buffered, rest: body}, nil
+}
+
+// Replayable checks if the body fits in the replay buffer
+func (b *ReplayableBody) Replayable() bool {
+	return b.rest == nil
+}
+
+// Reader returns a reader of the whole body. A body that doesn't fit in the
+// replay buffer can only be read once, and ErrBodyNotReplayable is returned
+// when it is read again.
+func (b *ReplayableBody) Reader() (io.Reader, error) {
+	if b.rest == nil {
+		return bytes.NewReader(b.buffered), nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.streamed {
+		return nil, ErrBodyNotReplayable
+	}
+	b.streamed = true
+
+	return io.MultiReader(bytes.NewReader(b.buffered), b.rest), nil
+}
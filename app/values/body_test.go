@@ -0,0 +1,70 @@
+package values_test
+
+import (
+	"errors"
+	"go-reverse-proxy/app/values"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayableBody(t *testing.T) {
+	t.Run("body that fits", func(t *testing.T) {
+		body, err := values.NewReplayableBody(strings.NewReader("hello"), 5)
+		assert.Nil(t, err)
+		assert.True(t, body.Replayable())
+
+		// the body can be read any number of times
+		for i := 0; i < 2; i++ {
+			reader, err := body.Reader()
+			assert.Nil(t, err)
+
+			read, err := ioutil.ReadAll(reader)
+			assert.Nil(t, err)
+			assert.Equal(t, "hello", string(read))
+		}
+	})
+
+	t.Run("body larger than the buffer", func(t *testing.T) {
+		body, err := values.NewReplayableBody(strings.NewReader("hello world"), 5)
+		assert.Nil(t, err)
+		assert.False(t, body.Replayable())
+
+		reader, err := body.Reader()
+		assert.Nil(t, err)
+
+		read, err := ioutil.ReadAll(reader)
+		assert.Nil(t, err)
+		assert.Equal(t, "hello world", string(read))
+
+		// the rest of the body was streamed and is gone
+		_, err = body.Reader()
+		assert.Equal(t, values.ErrBodyNotReplayable, err)
+	})
+
+	t.Run("no body", func(t *testing.T) {
+		body, err := values.NewReplayableBody(nil, 5)
+		assert.Nil(t, err)
+		assert.True(t, body.Replayable())
+
+		reader, err := body.Reader()
+		assert.Nil(t, err)
+
+		read, err := ioutil.ReadAll(reader)
+		assert.Nil(t, err)
+		assert.Empty(t, read)
+	})
+
+	t.Run("failed read", func(t *testing.T) {
+		_, err := values.NewReplayableBody(errorReader{}, 5)
+		assert.NotNil(t, err)
+	})
+}
+
+type errorReader struct{}
+
+func (errorReader) Read(p []byte) (int, error) {
+	return 0, errors.New("client disconnected")
+}
@@ -1,6 +1,76 @@
 package values
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// RoundRobin sends requests to each host of a service in turn
+	RoundRobin = "round_robin"
+	// WeightedRoundRobin sends requests to the hosts of a service in
+	// proportion to their weight, interleaving them smoothly
+	WeightedRoundRobin = "weighted_round_robin"
+	// LeastOutstandingRequests sends requests to the host with the fewest
+	// requests in flight, breaking ties randomly
+	LeastOutstandingRequests = "least_outstanding_requests"
+	// ConsistentHash sends requests with the same hash key to the same
+	// host, remapping only a fraction of the keys when hosts change
+	ConsistentHash = "consistent_hash"
+	// PeakEWMA picks two random hosts and sends the request to the one
+	// with the lowest peak-EWMA latency weighted by its requests in flight
+	PeakEWMA = "peak_ewma"
+)
+
+const (
+	// HashKeyClientIP uses the client IP address as the hash key
+	HashKeyClientIP = "client_ip"
+	// HashKeyHeader uses the value of a request header as the hash key
+	HashKeyHeader = "header"
+	// HashKeyCookie uses the value of a request cookie as the hash key
+	HashKeyCookie = "cookie"
+	// HashKeyQuery uses the value of a query parameter as the hash key
+	HashKeyQuery = "query"
+)
+
+// DefaultPathPrefix is the legacy prefix of the paths served by the
+// listener, kept as the default so that existing clients keep working
+const DefaultPathPrefix = "/proxy/"
+
+const (
+	// RetryOnConnectFailure retries requests that failed to connect to the host
+	RetryOnConnectFailure = "connect_failure"
+	// RetryOnTimeout retries requests that the host didn't answer in time
+	RetryOnTimeout = "timeout"
+	// RetryOnReset retries requests whose connection was reset by the host
+	RetryOnReset = "reset"
+)
+
+// DefaultRetryableMethods are the idempotent methods, which can be
+// retried without the risk of applying a request twice
+var DefaultRetryableMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodOptions,
+	http.MethodTrace,
+	http.MethodPut,
+	http.MethodDelete,
+}
+
+// DefaultIdempotencyKeyHeader is the header that clients set on requests
+// that are safe to retry even though their method is not idempotent
+const DefaultIdempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultMirrorHeader is the header set on the copies of
+// the requests sent to the mirror of a service
+const DefaultMirrorHeader = "X-Mirrored-Request"
+
+// DefaultDecayTime is the latency decay time used by the peak-EWMA
+// algorithm when a service doesn't configure one
+const DefaultDecayTime = 10 * time.Second
 
 // Type Configuration is used to represent the configuration
 // of the reverse proxy service.
@@ -8,6 +78,22 @@ type Configuration struct {
 	Host     *Host               // the host configuration of the reverse proxy
 	Services map[string]*Service // map of supported downstream services
 
+	// service that receives the requests whose host matches no
+	// service domain, nil when they are refused
+	DefaultService *Service
+
+	// prefix of the paths served by the listener, which is removed from
+	// the path before forwarding, "/" proxies every path transparently
+	PathPrefix string
+
+	// size of the largest request body that is held in memory so that
+	// the request can be sent again, larger bodies are only streamed
+	ReplayBufferSize int64
+
+	// networks of the proxies in front of the reverse proxy, whose
+	// forwarding headers are trusted to find the client address
+	TrustedProxies TrustedProxies
+
 	// list of status codes that should result in a redirect of the request
 	// to another instance
 	RetryableStatusCodes []int
@@ -15,36 +101,356 @@ type Configuration struct {
 	MaxForwardRetries int
 }
 
-// GetServiceByDomain returns a pointer to a Service given a domain string
+// GetServiceByDomain returns a pointer to the Service that serves a domain,
+// ignoring its case, port and trailing dot. A service configured with the
+// exact domain wins over a wildcard one, and longer wildcard domains win
+// over shorter ones. When no service matches, it returns the default
+// service, which may be nil.
 func (c *Configuration) GetServiceByDomain(domain string) *Service {
-	service, _ := c.Services[domain]
-	return service
+	domain = NormalizeDomain(domain)
+
+	if service, ok := c.Services[domain]; ok {
+		return service
+	}
+
+	for _, wildcard := range wildcardDomains(domain) {
+		if service, ok := c.Services[wildcard]; ok {
+			return service
+		}
+	}
+
+	return c.DefaultService
+}
+
+// GetReplayBufferSize returns the size of the replay buffer of the
+// request bodies, which is DefaultReplayBufferSize when it isn't set
+func (c *Configuration) GetReplayBufferSize() int64 {
+	if c.ReplayBufferSize <= 0 {
+		return DefaultReplayBufferSize
+	}
+
+	return c.ReplayBufferSize
+}
+
+// GetRetryPolicy returns the retry policy of a service, which is the one
+// configured by the service or, when it has none, the global policy built
+// from RetryableStatusCodes and MaxForwardRetries
+func (c *Configuration) GetRetryPolicy(service *Service) RetryPolicy {
+	if service.Retry != nil {
+		return *service.Retry
+	}
+
+	return RetryPolicy{
+		RetryableStatusCodes:  c.RetryableStatusCodes,
+		RetryOnConnectFailure: true,
+		RetryOnTimeout:        true,
+		RetryOnReset:          true,
+		MaxAttempts:           c.MaxForwardRetries + 1,
+		RetryableMethods:      DefaultRetryableMethods,
+		IdempotencyKeyHeader:  DefaultIdempotencyKeyHeader,
+	}
 }
 
 // Type Service is used to represent a downsteam service
 // that the reverse proxy can forward too
 type Service struct {
-	Name   string  // name of the service
-	Domain string  // domain of the service
-	Hosts  []*Host // host list containing the service instances
+	Name         string  // name of the service
+	Domain       string  // domain of the service
+	Hosts        []*Host // host list containing the service instances
+	LoadBalancer string  // load balancing algorithm used to choose the hosts
+	HashKey      HashKey // request attribute used by the consistent hashing
+
+	// time it takes for the latency observed by the peak-EWMA
+	// algorithm to decay, higher values react slower to recoveries
+	DecayTime time.Duration
+
+	// active health check of the service hosts, nil when disabled
+	HealthCheck *HealthCheck
+	// passive detection of failing hosts, nil when disabled
+	OutlierDetection *OutlierDetection
+	// circuit breaker of each service host, nil when disabled
+	CircuitBreaker *CircuitBreaker
+	// retry policy of the service, nil to use the global one
+	Retry *RetryPolicy
+	// limit of the retries sent to the service, nil when unlimited
+	RetryBudget *RetryBudget
+	// hedging of the slow requests to the service, nil when disabled
+	Hedging *Hedging
+	// copies of the requests sent to other hosts, nil when disabled
+	Mirror *Mirror
+	// changes of the headers of the requests and responses,
+	// applied before the ones of the route, nil when disabled
+	Headers *HeaderPolicy
+
+	// named host pools of the service, which share its settings
+	Pools []*Service
+	// ordered routes that send requests to the pools, the first route
+	// that matches a request wins and the service hosts receive the
+	// requests that match no route
+	Routes []*Route
+	// split of the requests that match no route between the pools,
+	// nil when the service hosts receive them
+	Split *Split
+}
+
+// Type HashKey identifies the request attribute that is hashed to
+// choose a host when consistent hashing is used
+type HashKey struct {
+	Source string // one of client_ip, header, cookie or query
+	Name   string // name of the header, cookie or query parameter
+}
+
+// Value extracts the hash key from a request, returning
+// an empty string when the request doesn't carry it
+func (k HashKey) Value(request *Request) string {
+	if request == nil {
+		return ""
+	}
+
+	switch k.Source {
+	case HashKeyHeader:
+		return request.Header.Get(k.Name)
+	case HashKeyCookie:
+		cookie, err := (&http.Request{Header: request.Header}).Cookie(k.Name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	case HashKeyQuery:
+		parameters, _ := url.ParseQuery(request.Parameters)
+		return parameters.Get(k.Name)
+	default:
+		return request.ClientIP()
+	}
+}
+
+// Type HealthCheck describes how the hosts of a service are probed
+// to find out whether they can receive requests
+type HealthCheck struct {
+	Path     string        // endpoint requested on each host
+	Interval time.Duration // time between two checks of a host
+	Timeout  time.Duration // time to wait for the host to reply
+
+	// range of status codes that are considered healthy
+	ExpectedStatusMin int
+	ExpectedStatusMax int
+
+	// consecutive checks needed to change the health of a host
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// IsExpectedStatus checks if a status code is within the healthy range
+func (c *HealthCheck) IsExpectedStatus(statusCode int) bool {
+	return statusCode >= c.ExpectedStatusMin && statusCode <= c.ExpectedStatusMax
+}
 
-	// used to store the index of the next host to use
-	// this value is incrementally updated after each request
-	// in order to apply a Round-Robin load balancing algorithm
-	NextHostIndex int32
+// Type OutlierDetection describes when the hosts of a service that keep
+// failing requests are ejected from the load balancing
+type OutlierDetection struct {
+	// consecutive 5xx responses or connection errors that eject a host
+	ConsecutiveErrors int
+	// time that a host stays ejected, multiplied by the number of
+	// times that it was ejected and limited by MaxEjectionTime
+	BaseEjectionTime time.Duration
+	MaxEjectionTime  time.Duration
+	// maximum percentage of the service hosts ejected at the same time
+	MaxEjectionPercent int
 }
 
-// GetNextHost retrieves a pointer to the next Host to be used
-func (s *Service) GetNextHost() *Host {
-	return s.Hosts[s.NextHostIndex]
+// Type CircuitBreaker describes when the requests to a host of a service
+// are stopped because too many of them failed, and how the host is probed
+// before it receives requests again
+type CircuitBreaker struct {
+	// percentage of failed requests within the window that opens the circuit
+	FailureRateThreshold int
+	// requests needed within the window before the failure rate is trusted
+	MinimumRequests int
+	// rolling period of time over which the failure rate is computed
+	Window time.Duration
+	// time that the circuit stays open before it lets probes through
+	OpenTime time.Duration
+	// probe requests let through while the circuit is half-open,
+	// all of them must succeed for the circuit to close again
+	HalfOpenRequests int
+}
+
+// Type RetryPolicy describes which failed requests to a service are
+// retried on a different host, and how
+type RetryPolicy struct {
+	// status codes answered by a host that are retried
+	RetryableStatusCodes []int
+	// requests that got no answer are only retried when they
+	// failed for one of the enabled reasons
+	RetryOnConnectFailure bool
+	RetryOnTimeout        bool
+	RetryOnReset          bool
+	// maximum number of attempts, including the first one
+	MaxAttempts int
+	// the wait before each retry is random, up to BackoffBase doubled
+	// for each retry so far and limited by BackoffMax
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// time that each attempt may take, zero to only limit the whole request
+	PerTryTimeout time.Duration
+
+	// methods of the requests that may be sent more than once, the
+	// others are only retried when they carry the idempotency key
+	// header or when they failed before connecting to the host
+	RetryableMethods     []string
+	IdempotencyKeyHeader string
+}
+
+// IsRetryableStatus checks if a status code answered by a host is retried
+func (p *RetryPolicy) IsRetryableStatus(statusCode int) bool {
+	for _, status := range p.RetryableStatusCodes {
+		if statusCode == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Type RetryBudget limits the retries sent to a service to a share of
+// its recent requests, so that retries can't multiply the load of the
+// service hosts during an outage
+type RetryBudget struct {
+	// retries allowed as a percentage of the requests within the window
+	RetryPercent int
+	// retries allowed per second within the window regardless of the
+	// requests, so that services with little traffic can still retry
+	MinRetriesPerSecond int
+	// sliding period of time over which requests and retries are counted
+	Window time.Duration
+}
+
+// IsReplayable checks if a request may be sent again after it reached a
+// host, which requires an idempotent method or an idempotency key
+func (p *RetryPolicy) IsReplayable(request *Request) bool {
+	for _, method := range p.RetryableMethods {
+		if request.Method == method {
+			return true
+		}
+	}
+
+	return p.IdempotencyKeyHeader != "" && request.Header.Get(p.IdempotencyKeyHeader) != ""
+}
+
+// Type Hedging describes when a copy of a replayable request is sent to
+// a second host of a service because the first one is slow to answer
+type Hedging struct {
+	// time to wait for the first host before sending the copy, which
+	// is only used until enough latencies are observed when the delay
+	// is derived from a percentile
+	Delay time.Duration
+	// percentile of the observed latencies used as the delay, zero to
+	// always use the fixed delay
+	DelayPercentile int
+	// hedged requests allowed as a percentage of the requests
+	BudgetPercent int
+}
+
+// Type Mirror describes the copies of the requests of a service that are
+// sent to a separate list of hosts, whose responses are discarded
+type Mirror struct {
+	// service with the hosts that receive the copies
+	Service *Service
+	// requests copied as a percentage of the requests
+	Percent int
+	// header set on the copies, so that the hosts can tell them apart
+	Header string
+	// time after which a copy is abandoned
+	Timeout time.Duration
+	// comparison of the responses to the copies with the
+	// responses to the clients, nil when disabled
+	Diff *ShadowDiff
+}
+
+// Type ShadowDiff describes how the response of a mirror host
+// is compared with the response sent to the client
+type ShadowDiff struct {
+	// response headers that must be equal, besides the status code
+	Headers []string
+	// dotted paths of the JSON body fields that are not compared,
+	// such as "meta.request_id", which also apply to the elements
+	// of the arrays on the path
+	IgnoredFields []string
+	// differences written to the log as a percentage of them
+	LogPercent int
 }
 
 type Host struct {
 	Address string // IPv4 address
 	Port    int32  // Port that is listening
+	Weight  int32  // Relative share of the service traffic sent to the host
+
+	// set by the health checker when the host fails its checks,
+	// it must be accessed atomically
+	unhealthy int32
+	// set by the outlier detection while the host is ejected,
+	// it must be accessed atomically
+	ejected int32
+	// set by the circuit breaker while the host can't receive
+	// requests, it must be accessed atomically
+	circuitOpen int32
 }
 
 // ToURL creates the URL representation composed of a Host address and port
 func (h *Host) ToURL() string {
 	return fmt.Sprintf("%s:%d", h.Address, h.Port)
 }
+
+// IsHealthy checks if the host is passing its health checks. Hosts
+// are healthy until a health check says otherwise.
+func (h *Host) IsHealthy() bool {
+	return atomic.LoadInt32(&h.unhealthy) == 0
+}
+
+// SetHealthy records the result of the health checks of the host
+func (h *Host) SetHealthy(healthy bool) {
+	var unhealthy int32
+	if !healthy {
+		unhealthy = 1
+	}
+
+	atomic.StoreInt32(&h.unhealthy, unhealthy)
+}
+
+// IsEjected checks if the host is ejected from the load balancing
+// for failing too many requests in a row
+func (h *Host) IsEjected() bool {
+	return atomic.LoadInt32(&h.ejected) == 1
+}
+
+// SetEjected ejects the host from the load balancing, or readmits it
+func (h *Host) SetEjected(ejected bool) {
+	var value int32
+	if ejected {
+		value = 1
+	}
+
+	atomic.StoreInt32(&h.ejected, value)
+}
+
+// IsCircuitOpen checks if the circuit breaker of the host is
+// stopping the requests to it
+func (h *Host) IsCircuitOpen() bool {
+	return atomic.LoadInt32(&h.circuitOpen) == 1
+}
+
+// SetCircuitOpen stops the requests to the host, or resumes them
+func (h *Host) SetCircuitOpen(open bool) {
+	var value int32
+	if open {
+		value = 1
+	}
+
+	atomic.StoreInt32(&h.circuitOpen, value)
+}
+
+// IsAvailable checks if the host can receive requests, which requires
+// it to be healthy, not ejected and with its circuit closed
+func (h *Host) IsAvailable() bool {
+	return h.IsHealthy() && !h.IsEjected() && !h.IsCircuitOpen()
+}
@@ -29,7 +29,6 @@ func TestGetServiceByDomain(t *testing.T) {
 						Port:    5001,
 					},
 				},
-				NextHostIndex: 0,
 			},
 		},
 		RetryableStatusCodes: []int{},
@@ -41,26 +40,143 @@ func TestGetServiceByDomain(t *testing.T) {
 	assert.Equal(t, configuration.Services[domain], service)
 }
 
-func TestGetNextHost(t *testing.T) {
-	service := &values.Service{
-		Name:   "my-service",
-		Domain: "my-domain.com",
-		Hosts: []*values.Host{
-			{
-				Address: "127.0.0.1",
-				Port:    5000,
+func TestGetServiceByDomainMatching(t *testing.T) {
+	exact := &values.Service{Name: "exact", Domain: "api.test.com"}
+	test := &values.Service{Name: "test", Domain: "test.com"}
+	wildcard := &values.Service{Name: "wildcard", Domain: "*.test.com"}
+	specific := &values.Service{Name: "specific", Domain: "*.eu.test.com"}
+
+	configuration := &values.Configuration{
+		Services: map[string]*values.Service{
+			"api.test.com":  exact,
+			"test.com":      test,
+			"*.test.com":    wildcard,
+			"*.eu.test.com": specific,
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		domain   string
+		expected *values.Service
+	}{
+		{
+			name:     "exact",
+			domain:   "test.com",
+			expected: test,
+		},
+		{
+			name:     "case insensitive",
+			domain:   "Test.COM",
+			expected: test,
+		},
+		{
+			name:     "with port",
+			domain:   "test.com:8080",
+			expected: test,
+		},
+		{
+			name:     "with trailing dot",
+			domain:   "test.com.",
+			expected: test,
+		},
+		{
+			name:     "exact wins over wildcard",
+			domain:   "api.test.com",
+			expected: exact,
+		},
+		{
+			name:     "wildcard",
+			domain:   "www.test.com",
+			expected: wildcard,
+		},
+		{
+			name:     "wildcard matches nested subdomains",
+			domain:   "a.b.test.com",
+			expected: wildcard,
+		},
+		{
+			name:     "longer wildcard wins",
+			domain:   "www.EU.test.com.:443",
+			expected: specific,
+		},
+		{
+			name:     "wildcard doesn't match its own domain",
+			domain:   "eu.test.com",
+			expected: wildcard,
+		},
+		{
+			name:     "no match",
+			domain:   "other.com",
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Same(t, tc.expected, configuration.GetServiceByDomain(tc.domain))
+		})
+	}
+}
+
+func TestGetServiceByDomainDefaultService(t *testing.T) {
+	service := &values.Service{Name: "service", Domain: "test.com"}
+	fallback := &values.Service{Name: "fallback", Domain: "fallback.com"}
+
+	configuration := &values.Configuration{
+		Services: map[string]*values.Service{
+			"test.com":     service,
+			"fallback.com": fallback,
+		},
+		DefaultService: fallback,
+	}
+
+	assert.Same(t, service, configuration.GetServiceByDomain("test.com"))
+	assert.Same(t, fallback, configuration.GetServiceByDomain("other.com"))
+	assert.Same(t, fallback, configuration.GetServiceByDomain(""))
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	assert.Equal(t, "test.com", values.NormalizeDomain("Test.com:8080"))
+	assert.Equal(t, "test.com", values.NormalizeDomain("test.com."))
+	assert.Equal(t, "*.test.com", values.NormalizeDomain("*.Test.com"))
+	assert.Equal(t, "::1", values.NormalizeDomain("[::1]:8080"))
+	assert.Equal(t, "127.0.0.1", values.NormalizeDomain("127.0.0.1:8080"))
+}
+
+func TestGetRetryPolicy(t *testing.T) {
+	retry := &values.RetryPolicy{
+		RetryableStatusCodes: []int{503},
+		MaxAttempts:          5,
+	}
+
+	configuration := &values.Configuration{
+		Services: map[string]*values.Service{
+			"default.com": {
+				Name:   "default",
+				Domain: "default.com",
 			},
-			{
-				Address: "127.0.0.1",
-				Port:    5001,
+			"custom.com": {
+				Name:   "custom",
+				Domain: "custom.com",
+				Retry:  retry,
 			},
 		},
-		NextHostIndex: 1,
+		RetryableStatusCodes: []int{500},
+		MaxForwardRetries:    2,
 	}
 
-	host := service.GetNextHost()
+	assert.Equal(t, values.RetryPolicy{
+		RetryableStatusCodes:  []int{500},
+		RetryOnConnectFailure: true,
+		RetryOnTimeout:        true,
+		RetryOnReset:          true,
+		MaxAttempts:           3,
+		RetryableMethods:      values.DefaultRetryableMethods,
+		IdempotencyKeyHeader:  "Idempotency-Key",
+	}, configuration.GetRetryPolicy(configuration.Services["default.com"]))
 
-	assert.Equal(t, service.Hosts[1], host)
+	assert.Equal(t, *retry, configuration.GetRetryPolicy(configuration.Services["custom.com"]))
 }
 
 func TestToURL(t *testing.T) {
@@ -0,0 +1,41 @@
+package values
+
+import (
+	"net"
+	"strings"
+)
+
+// wildcardPrefix is the label that a wildcard domain starts with,
+// matching any subdomain of the rest of the domain
+const wildcardPrefix = "*."
+
+// NormalizeDomain returns the domain of a Host header or of a configured
+// service in the form that they are compared in, which is lower case and
+// without the port or the trailing dot of fully qualified domains
+func NormalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		domain = host
+	}
+
+	domain = strings.TrimPrefix(domain, "[")
+	domain = strings.TrimSuffix(domain, "]")
+
+	return strings.TrimSuffix(domain, ".")
+}
+
+// wildcardDomains returns the wildcard domains that match a normalized
+// domain, from the most to the least specific one
+func wildcardDomains(domain string) []string {
+	var wildcards []string
+	for {
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			return wildcards
+		}
+
+		domain = domain[dot+1:]
+		wildcards = append(wildcards, wildcardPrefix+domain)
+	}
+}
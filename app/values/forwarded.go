@@ -0,0 +1,187 @@
+package values
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	// ForwardedHeader is the standard header of RFC 7239 that
+	// describes the proxies that a request went through
+	ForwardedHeader = "Forwarded"
+	// XForwardedForHeader lists the client and the proxies
+	// that a request went through
+	XForwardedForHeader = "X-Forwarded-For"
+	// XForwardedProtoHeader is the protocol used by the client
+	XForwardedProtoHeader = "X-Forwarded-Proto"
+	// XForwardedHostHeader is the host requested by the client
+	XForwardedHostHeader = "X-Forwarded-Host"
+)
+
+// Type TrustedProxies is the list of networks of the proxies placed in
+// front of the reverse proxy, whose forwarding headers can be trusted
+type TrustedProxies []*net.IPNet
+
+// Contains checks if an IP address belongs to a trusted proxy
+func (t TrustedProxies) Contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range t {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ResolveClient returns a copy of a request that carries the IP address of
+// the client and the forwarding headers that the hosts receive. When the
+// request comes from a trusted proxy, the client is the last address of
+// its forwarding headers that isn't a trusted proxy, and the reverse proxy
+// is appended to those headers. Otherwise the client is the peer that sent
+// the request, and the forwarding headers that it sent are replaced.
+func (t TrustedProxies) ResolveClient(request *Request) *Request {
+	resolved := *request
+	resolved.Header = request.Header.Clone()
+	if resolved.Header == nil {
+		resolved.Header = http.Header{}
+	}
+
+	peer := request.PeerIP()
+	trusted := t.Contains(peer)
+
+	resolved.RealClientIP = peer
+	if trusted {
+		resolved.RealClientIP = t.clientIP(request.Header, peer)
+	} else {
+		for _, name := range []string{ForwardedHeader, XForwardedForHeader, XForwardedProtoHeader, XForwardedHostHeader} {
+			resolved.Header.Del(name)
+		}
+	}
+
+	var element []string
+	if peer != "" {
+		appendHeader(resolved.Header, XForwardedForHeader, peer)
+		element = append(element, "for="+forwardedNode(peer))
+	}
+
+	if request.Scheme != "" {
+		if resolved.Header.Get(XForwardedProtoHeader) == "" {
+			resolved.Header.Set(XForwardedProtoHeader, request.Scheme)
+		}
+		element = append(element, "proto="+request.Scheme)
+	}
+
+	if request.HostHeader != "" {
+		if resolved.Header.Get(XForwardedHostHeader) == "" {
+			resolved.Header.Set(XForwardedHostHeader, request.HostHeader)
+		}
+		element = append(element, "host="+quoteForwardedValue(request.HostHeader))
+	}
+
+	if len(element) > 0 {
+		appendHeader(resolved.Header, ForwardedHeader, strings.Join(element, ";"))
+	}
+
+	return &resolved
+}
+
+// clientIP walks the addresses of the forwarding headers sent by a trusted
+// proxy from the closest one, returning the first that isn't a trusted
+// proxy, or the farthest one when they all are
+func (t TrustedProxies) clientIP(header http.Header, peer string) string {
+	addresses := forwardedFor(header)
+
+	client := peer
+	for i := len(addresses) - 1; i >= 0; i-- {
+		address := addresses[i]
+		if net.ParseIP(address) == nil {
+			// unknown or obfuscated addresses end the trusted chain
+			break
+		}
+
+		client = address
+		if !t.Contains(address) {
+			break
+		}
+	}
+
+	return client
+}
+
+// forwardedFor returns the addresses that a request went through, from the
+// farthest to the closest, read from X-Forwarded-For or, when missing, from
+// the for parameters of the Forwarded header
+func forwardedFor(header http.Header) []string {
+	var addresses []string
+	for _, value := range header.Values(XForwardedForHeader) {
+		for _, address := range strings.Split(value, ",") {
+			addresses = append(addresses, strings.TrimSpace(address))
+		}
+	}
+
+	if len(addresses) > 0 {
+		return addresses
+	}
+
+	for _, value := range header.Values(ForwardedHeader) {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(name) == 2 && strings.EqualFold(name[0], "for") {
+					addresses = append(addresses, parseForwardedNode(name[1]))
+				}
+			}
+		}
+	}
+
+	return addresses
+}
+
+// parseForwardedNode returns the IP address of a node of the Forwarded
+// header, such as "[2001:db8::1]:4711" or 192.0.2.43
+func parseForwardedNode(node string) string {
+	node = strings.Trim(node, `"`)
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+}
+
+// forwardedNode formats an IP address as a node of the Forwarded
+// header, where IPv6 addresses are quoted and enclosed in brackets
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+
+	return ip
+}
+
+// quoteForwardedValue quotes a value of the Forwarded header
+// when it has characters that a token can't have, such as ":"
+func quoteForwardedValue(value string) string {
+	if strings.ContainsAny(value, ":[]\"") {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+
+	return value
+}
+
+// appendHeader appends a value to a header, joining its lines into a
+// single one, which is how proxies add themselves to the forwarding headers
+func appendHeader(header http.Header, name string, value string) {
+	values := header.Values(name)
+	if len(values) == 0 {
+		header.Set(name, value)
+		return
+	}
+
+	header.Set(name, strings.Join(values, ", ")+", "+value)
+}
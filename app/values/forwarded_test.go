@@ -0,0 +1,143 @@
+package values_test
+
+import (
+	"go-reverse-proxy/app/values"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedProxiesResolveClient(t *testing.T) {
+	_, internal, _ := net.ParseCIDR("10.0.0.0/8")
+	_, loopback, _ := net.ParseCIDR("::1/128")
+	trusted := values.TrustedProxies{internal, loopback}
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		clientIP   string
+		expected   http.Header
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "203.0.113.7:51000",
+			header:     http.Header{},
+			clientIP:   "203.0.113.7",
+			expected: http.Header{
+				"X-Forwarded-For":   []string{"203.0.113.7"},
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Host":  []string{"my-domain.com"},
+				"Forwarded":         []string{"for=203.0.113.7;proto=https;host=my-domain.com"},
+			},
+		},
+		{
+			name:       "spoofed headers from an untrusted peer",
+			remoteAddr: "203.0.113.7:51000",
+			header: http.Header{
+				"X-Forwarded-For":   []string{"1.2.3.4"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Host":  []string{"other-domain.com"},
+				"Forwarded":         []string{"for=1.2.3.4"},
+			},
+			clientIP: "203.0.113.7",
+			expected: http.Header{
+				"X-Forwarded-For":   []string{"203.0.113.7"},
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Host":  []string{"my-domain.com"},
+				"Forwarded":         []string{"for=203.0.113.7;proto=https;host=my-domain.com"},
+			},
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.2:51000",
+			header: http.Header{
+				"X-Forwarded-For":   []string{"1.2.3.4, 203.0.113.7, 10.0.0.3"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Host":  []string{"other-domain.com"},
+				"Forwarded":         []string{"for=203.0.113.7"},
+			},
+			// the addresses before the first untrusted one may be spoofed
+			clientIP: "203.0.113.7",
+			expected: http.Header{
+				"X-Forwarded-For":   []string{"1.2.3.4, 203.0.113.7, 10.0.0.3, 10.0.0.2"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Host":  []string{"other-domain.com"},
+				"Forwarded":         []string{"for=203.0.113.7, for=10.0.0.2;proto=https;host=my-domain.com"},
+			},
+		},
+		{
+			name:       "trusted proxy with the Forwarded header only",
+			remoteAddr: "[::1]:51000",
+			header: http.Header{
+				"Forwarded": []string{`for="[2001:db8::1]:4711";proto=http, for=10.0.0.3`},
+			},
+			clientIP: "2001:db8::1",
+			expected: http.Header{
+				"X-Forwarded-For":   []string{"::1"},
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Host":  []string{"my-domain.com"},
+				"Forwarded":         []string{`for="[2001:db8::1]:4711";proto=http, for=10.0.0.3, for="[::1]";proto=https;host=my-domain.com`},
+			},
+		},
+		{
+			name:       "trusted proxy without forwarding headers",
+			remoteAddr: "10.0.0.2:51000",
+			header:     http.Header{},
+			clientIP:   "10.0.0.2",
+			expected: http.Header{
+				"X-Forwarded-For":   []string{"10.0.0.2"},
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Host":  []string{"my-domain.com"},
+				"Forwarded":         []string{"for=10.0.0.2;proto=https;host=my-domain.com"},
+			},
+		},
+		{
+			name:       "obfuscated address",
+			remoteAddr: "10.0.0.2:51000",
+			header: http.Header{
+				"X-Forwarded-For": []string{"203.0.113.7, unknown, 10.0.0.3"},
+			},
+			clientIP: "10.0.0.3",
+			expected: http.Header{
+				"X-Forwarded-For":   []string{"203.0.113.7, unknown, 10.0.0.3, 10.0.0.2"},
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Host":  []string{"my-domain.com"},
+				"Forwarded":         []string{"for=10.0.0.2;proto=https;host=my-domain.com"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := &values.Request{
+				Header:     tc.header,
+				HostHeader: "my-domain.com",
+				RemoteAddr: tc.remoteAddr,
+				Scheme:     "https",
+			}
+			original := tc.header.Clone()
+
+			resolved := trusted.ResolveClient(request)
+
+			assert.Equal(t, tc.clientIP, resolved.ClientIP())
+			assert.Equal(t, tc.expected, resolved.Header)
+			// the headers of the client request are left untouched
+			assert.Equal(t, original, request.Header)
+		})
+	}
+}
+
+func TestRequestClientIP(t *testing.T) {
+	request := &values.Request{RemoteAddr: "10.0.0.2:51000"}
+
+	assert.Equal(t, "10.0.0.2", request.ClientIP())
+	assert.Equal(t, "10.0.0.2", request.PeerIP())
+
+	request.RealClientIP = "203.0.113.7"
+
+	assert.Equal(t, "203.0.113.7", request.ClientIP())
+	assert.Equal(t, "10.0.0.2", request.PeerIP())
+}
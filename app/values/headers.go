@@ -0,0 +1,117 @@
+package values
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Type HeaderPolicy changes the headers of the requests that are
+// forwarded and of the responses that are sent back to the client
+type HeaderPolicy struct {
+	Request  *HeaderRules // changes of the request headers, nil to keep them
+	Response *HeaderRules // changes of the response headers, nil to keep them
+}
+
+// Type HeaderRules describes the changes of a set of headers. The headers
+// are removed first, then set, replacing their values, and finally added,
+// keeping the values they already had. The values may use the {client_ip},
+// {request_id} and {service} placeholders, which are replaced by the ones
+// of the request.
+type HeaderRules struct {
+	Remove []string
+	Set    []HeaderValue
+	Add    []HeaderValue
+}
+
+// Type HeaderValue is a header and the template of its value
+type HeaderValue struct {
+	Name  string
+	Value string
+}
+
+// ApplyRequest returns the request that is forwarded after changing its
+// headers, which is a copy of the request with the headers of the service
+// and then of the route changed, or the request itself when they have no
+// changes. A nil route stands for the requests that match none.
+func (s *Service) ApplyRequest(route *Route, request *Request) *Request {
+	serviceRules, routeRules := s.headerRules(route, func(policy *HeaderPolicy) *HeaderRules {
+		return policy.Request
+	})
+	if serviceRules == nil && routeRules == nil {
+		return request
+	}
+
+	changed := *request
+	changed.Header = request.Header.Clone()
+	if changed.Header == nil {
+		changed.Header = http.Header{}
+	}
+
+	serviceRules.Apply(changed.Header, request, s)
+	routeRules.Apply(changed.Header, request, s)
+
+	return &changed
+}
+
+// ApplyResponse changes the headers of the response sent to the client for
+// a request, with the headers of the service and then of the route
+func (s *Service) ApplyResponse(route *Route, request *Request, response *Response) {
+	serviceRules, routeRules := s.headerRules(route, func(policy *HeaderPolicy) *HeaderRules {
+		return policy.Response
+	})
+	if serviceRules == nil && routeRules == nil {
+		return
+	}
+
+	// the headers may still be read by the comparison with a mirror
+	response.Header = response.Header.Clone()
+	if response.Header == nil {
+		response.Header = http.Header{}
+	}
+
+	serviceRules.Apply(response.Header, request, s)
+	routeRules.Apply(response.Header, request, s)
+}
+
+// headerRules returns the rules of the service and of the route
+// that the choose function picks from their header policies
+func (s *Service) headerRules(
+	route *Route,
+	choose func(policy *HeaderPolicy) *HeaderRules,
+) (*HeaderRules, *HeaderRules) {
+	var serviceRules, routeRules *HeaderRules
+	if s.Headers != nil {
+		serviceRules = choose(s.Headers)
+	}
+
+	if route != nil && route.Headers != nil {
+		routeRules = choose(route.Headers)
+	}
+
+	return serviceRules, routeRules
+}
+
+// Apply changes the headers for a request sent to a service
+func (r *HeaderRules) Apply(header http.Header, request *Request, service *Service) {
+	if r == nil {
+		return
+	}
+
+	for _, name := range r.Remove {
+		header.Del(name)
+	}
+
+	placeholders := strings.NewReplacer(
+		"{client_ip}", request.ClientIP(),
+		"{request_id}", request.ID,
+		"{service}", service.Name,
+	)
+
+	for _, value := range r.Set {
+		header.Set(value.Name, placeholders.Replace(value.Value))
+	}
+
+	for _, value := range r.Add {
+		header.Add(value.Name, placeholders.Replace(value.Value))
+	}
+}
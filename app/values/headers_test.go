@@ -0,0 +1,120 @@
+package values_test
+
+import (
+	"go-reverse-proxy/app/values"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderRulesApply(t *testing.T) {
+	rules := &values.HeaderRules{
+		Remove: []string{"X-Debug", "X-Internal-Auth"},
+		Set: []values.HeaderValue{
+			{Name: "X-Internal-Auth", Value: "secret"},
+			{Name: "X-Real-Ip", Value: "{client_ip}"},
+		},
+		Add: []values.HeaderValue{
+			{Name: "Via", Value: "proxy {service}/{request_id}"},
+		},
+	}
+
+	header := http.Header{
+		"X-Debug":         []string{"true"},
+		"X-Internal-Auth": []string{"forged"},
+		"Via":             []string{"1.1 cdn"},
+		"Accept":          []string{"application/json"},
+	}
+
+	rules.Apply(
+		header,
+		&values.Request{ID: "abc", RemoteAddr: "10.0.0.1:51000"},
+		&values.Service{Name: "my-service"},
+	)
+
+	assert.Equal(t, http.Header{
+		"X-Internal-Auth": []string{"secret"},
+		"X-Real-Ip":       []string{"10.0.0.1"},
+		"Via":             []string{"1.1 cdn", "proxy my-service/abc"},
+		"Accept":          []string{"application/json"},
+	}, header)
+}
+
+func TestServiceApplyRequest(t *testing.T) {
+	route := &values.Route{
+		Headers: &values.HeaderPolicy{
+			Request: &values.HeaderRules{
+				Set: []values.HeaderValue{{Name: "X-Team", Value: "route"}},
+			},
+		},
+	}
+
+	service := &values.Service{
+		Name: "my-service",
+		Headers: &values.HeaderPolicy{
+			Request: &values.HeaderRules{
+				Set: []values.HeaderValue{
+					{Name: "X-Team", Value: "service"},
+					{Name: "X-Service", Value: "{service}"},
+				},
+			},
+		},
+		Routes: []*values.Route{route},
+	}
+
+	request := &values.Request{Header: http.Header{"Accept": []string{"*/*"}}}
+
+	t.Run("service and route", func(t *testing.T) {
+		changed := service.ApplyRequest(route, request)
+
+		// the route changes are applied after the service ones
+		assert.Equal(t, "route", changed.Header.Get("X-Team"))
+		assert.Equal(t, "my-service", changed.Header.Get("X-Service"))
+		assert.Equal(t, "*/*", changed.Header.Get("Accept"))
+		// the headers of the client request are left untouched
+		assert.Equal(t, http.Header{"Accept": []string{"*/*"}}, request.Header)
+	})
+
+	t.Run("no route", func(t *testing.T) {
+		changed := service.ApplyRequest(nil, request)
+
+		assert.Equal(t, "service", changed.Header.Get("X-Team"))
+	})
+
+	t.Run("no changes", func(t *testing.T) {
+		other := &values.Service{Name: "other-service"}
+
+		assert.Same(t, request, other.ApplyRequest(nil, request))
+	})
+}
+
+func TestServiceApplyResponse(t *testing.T) {
+	service := &values.Service{
+		Name: "my-service",
+		Headers: &values.HeaderPolicy{
+			Response: &values.HeaderRules{
+				Remove: []string{"Server", "X-Powered-By"},
+				Set: []values.HeaderValue{
+					{Name: "Strict-Transport-Security", Value: "max-age=31536000"},
+				},
+			},
+		},
+	}
+
+	upstreamHeader := http.Header{
+		"Server":       []string{"nginx"},
+		"X-Powered-By": []string{"php"},
+		"Content-Type": []string{"text/html"},
+	}
+	response := &values.Response{StatusCode: http.StatusOK, Header: upstreamHeader}
+
+	service.ApplyResponse(nil, &values.Request{}, response)
+
+	assert.Equal(t, http.Header{
+		"Content-Type":              []string{"text/html"},
+		"Strict-Transport-Security": []string{"max-age=31536000"},
+	}, response.Header)
+	// the headers sent by the host are left untouched
+	assert.Equal(t, "nginx", upstreamHeader.Get("Server"))
+}
@@ -1,13 +1,54 @@
 package values
 
-import "net/http"
+import (
+	"io"
+	"net"
+	"net/http"
+)
+
+// RequestIDHeader is the header that carries the ID of a request
+const RequestIDHeader = "X-Request-Id"
 
 // Request is used to represent the client request
 type Request struct {
+	ID         string      // ID of the request, taken from its X-Request-Id header or generated
 	Method     string      // HTTP method
-	Endpoint   string      // Endpoint of the downstream service that is being requested
+	Endpoint   string      // Endpoint of the downstream service that is being requested, escaped
 	Header     http.Header // Request headers
 	HostHeader string      // Host header
 	Parameters string      // URL query parameters
-	Payload    []byte      // Request payload data
+	Body       io.Reader   // Request body streamed from the client, nil when empty
+	RemoteAddr string      // Network address of the peer that sent the request
+	Scheme     string      // Protocol used by the peer, http or https
+
+	// IP address of the client resolved from the forwarding headers
+	// of the trusted proxies, empty until it is resolved
+	RealClientIP string
+}
+
+// Path returns the path of the request on the downstream service
+func (r *Request) Path() string {
+	return "/" + r.Endpoint
+}
+
+// ClientIP returns the IP address of the client that sent the request,
+// which is the one resolved from the trusted proxies in front of the
+// reverse proxy, or the address of the peer until it is resolved
+func (r *Request) ClientIP() string {
+	if r.RealClientIP != "" {
+		return r.RealClientIP
+	}
+
+	return r.PeerIP()
+}
+
+// PeerIP returns the IP address of the peer that sent the request,
+// which may be a proxy in front of the reverse proxy
+func (r *Request) PeerIP() string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
 }
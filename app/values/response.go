@@ -0,0 +1,89 @@
+package values
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Response is used to represent the response that is served to the client
+type Response struct {
+	StatusCode int         // HTTP status code
+	Header     http.Header // Response headers
+	// Response body streamed from the host, which must be closed once
+	// it is read, nil when there is no body
+	Body io.ReadCloser
+}
+
+// Close closes the body of a response, if it has one
+func (r *Response) Close() {
+	if r != nil && r.Body != nil {
+		_ = r.Body.Close()
+	}
+}
+
+// Type Redirect makes a route answer the matching requests
+// with a redirect instead of forwarding them
+type Redirect struct {
+	StatusCode int // one of the redirect status codes, such as 301
+	// target of the redirect, where {host}, {hostname}, {path} and
+	// {query} are replaced by the values of the request
+	Target string
+}
+
+// Type DirectResponse makes a route answer the matching requests
+// with a fixed response instead of forwarding them
+type DirectResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Location returns the target of the redirect of a request. The path is
+// the one of the request after the rewrite of the route, the hostname
+// is the host without its port and the query starts with a question
+// mark, or is empty when the request has no query parameters.
+func (r *Redirect) Location(request *Request) string {
+	hostname := request.HostHeader
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+
+	var query string
+	if request.Parameters != "" {
+		query = "?" + request.Parameters
+	}
+
+	return strings.NewReplacer(
+		"{host}", request.HostHeader,
+		"{hostname}", hostname,
+		"{path}", request.Path(),
+		"{query}", query,
+	).Replace(r.Target)
+}
+
+// Respond returns the response that the route serves for a request
+// without forwarding it, or nil when the requests that match the
+// route are forwarded to a host
+func (r *Route) Respond(request *Request) *Response {
+	switch {
+	case r == nil:
+		return nil
+	case r.Redirect != nil:
+		return &Response{
+			StatusCode: r.Redirect.StatusCode,
+			Header:     http.Header{"Location": []string{r.Redirect.Location(request)}},
+		}
+	case r.Response != nil:
+		return &Response{
+			StatusCode: r.Response.StatusCode,
+			Header:     r.Response.Header.Clone(),
+			Body:       ioutil.NopCloser(bytes.NewReader(r.Response.Body)),
+		}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,107 @@
+package values_test
+
+import (
+	"go-reverse-proxy/app/values"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedirectLocation(t *testing.T) {
+	testCases := []struct {
+		name     string
+		target   string
+		request  values.Request
+		expected string
+	}{
+		{
+			name:     "fixed target",
+			target:   "https://other-domain.com/",
+			request:  values.Request{HostHeader: "my-domain.com", Endpoint: "users"},
+			expected: "https://other-domain.com/",
+		},
+		{
+			name:     "https with path and query",
+			target:   "https://{host}{path}{query}",
+			request:  values.Request{HostHeader: "my-domain.com", Endpoint: "users/a%2Fb", Parameters: "page=2"},
+			expected: "https://my-domain.com/users/a%2Fb?page=2",
+		},
+		{
+			name:     "no query",
+			target:   "https://{host}{path}{query}",
+			request:  values.Request{HostHeader: "my-domain.com", Endpoint: "users"},
+			expected: "https://my-domain.com/users",
+		},
+		{
+			name:     "hostname without the port",
+			target:   "https://{hostname}:8443{path}",
+			request:  values.Request{HostHeader: "my-domain.com:8080", Endpoint: "users"},
+			expected: "https://my-domain.com:8443/users",
+		},
+		{
+			name:     "host with the port",
+			target:   "http://{host}/new{path}",
+			request:  values.Request{HostHeader: "my-domain.com:8080", Endpoint: ""},
+			expected: "http://my-domain.com:8080/new/",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			redirect := values.Redirect{StatusCode: http.StatusMovedPermanently, Target: tc.target}
+
+			assert.Equal(t, tc.expected, redirect.Location(&tc.request))
+		})
+	}
+}
+
+func TestRouteRespond(t *testing.T) {
+	request := &values.Request{HostHeader: "my-domain.com", Endpoint: "old/users", Parameters: "page=2"}
+
+	t.Run("nil route", func(t *testing.T) {
+		var route *values.Route
+
+		assert.Nil(t, route.Respond(request))
+	})
+
+	t.Run("forwarding route", func(t *testing.T) {
+		route := &values.Route{Pool: &values.Service{Name: "my-service/v2"}}
+
+		assert.Nil(t, route.Respond(request))
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		route := &values.Route{
+			Redirect: &values.Redirect{StatusCode: http.StatusFound, Target: "https://{host}{path}{query}"},
+		}
+
+		response := route.Respond(request)
+
+		assert.Equal(t, http.StatusFound, response.StatusCode)
+		assert.Equal(t, "https://my-domain.com/old/users?page=2", response.Header.Get("Location"))
+		assert.Nil(t, response.Body)
+	})
+
+	t.Run("direct response", func(t *testing.T) {
+		route := &values.Route{
+			Response: &values.DirectResponse{
+				StatusCode: http.StatusServiceUnavailable,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       []byte(`{"status":"maintenance"}`),
+			},
+		}
+
+		response := route.Respond(request)
+		response.Header.Set("X-Other", "value")
+
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+		assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(response.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"status":"maintenance"}`, string(body))
+		// every response gets its own headers
+		assert.Empty(t, route.Response.Header.Get("X-Other"))
+	})
+}
@@ -0,0 +1,57 @@
+package values
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Type Rewrite changes the path of the requests matched by a route before
+// they are forwarded. The prefix is stripped first, then the expression
+// is replaced and finally the prefix is added, skipping the empty steps.
+type Rewrite struct {
+	StripPrefix string         // prefix removed from the path
+	Regex       *regexp.Regexp // expression replaced in the path
+	Replacement string         // replacement of the expression, which may use $1 for groups
+	AddPrefix   string         // prefix added to the path
+}
+
+// Apply rewrites a path, which always starts with a slash. The path is
+// kept escaped as the client sent it, and no slashes other than the one
+// between the added prefix and the path are added or removed.
+func (r *Rewrite) Apply(path string) string {
+	if r.StripPrefix != "" && strings.HasPrefix(path, r.StripPrefix) {
+		path = ensureLeadingSlash(strings.TrimPrefix(path, r.StripPrefix))
+	}
+
+	if r.Regex != nil {
+		path = ensureLeadingSlash(r.Regex.ReplaceAllString(path, r.Replacement))
+	}
+
+	if r.AddPrefix != "" {
+		path = strings.TrimSuffix(r.AddPrefix, "/") + path
+	}
+
+	return path
+}
+
+func ensureLeadingSlash(path string) string {
+	if strings.HasPrefix(path, "/") {
+		return path
+	}
+
+	return "/" + path
+}
+
+// RewriteRequest returns the request that is forwarded for a request
+// matched by the route, which is a copy of the request with its path
+// rewritten, or the request itself when the route doesn't rewrite it
+func (r *Route) RewriteRequest(request *Request) *Request {
+	if r == nil || r.Rewrite == nil {
+		return request
+	}
+
+	rewritten := *request
+	rewritten.Endpoint = strings.TrimPrefix(r.Rewrite.Apply(request.Path()), "/")
+
+	return &rewritten
+}
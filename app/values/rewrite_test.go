@@ -0,0 +1,161 @@
+package values_test
+
+import (
+	"go-reverse-proxy/app/values"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteApply(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rewrite  values.Rewrite
+		path     string
+		expected string
+	}{
+		{
+			name:     "strip prefix",
+			rewrite:  values.Rewrite{StripPrefix: "/api/v2"},
+			path:     "/api/v2/users",
+			expected: "/users",
+		},
+		{
+			name:     "strip prefix with trailing slash",
+			rewrite:  values.Rewrite{StripPrefix: "/api/v2/"},
+			path:     "/api/v2/users",
+			expected: "/users",
+		},
+		{
+			name:     "strip the whole path",
+			rewrite:  values.Rewrite{StripPrefix: "/api"},
+			path:     "/api",
+			expected: "/",
+		},
+		{
+			name:     "strip prefix that doesn't match",
+			rewrite:  values.Rewrite{StripPrefix: "/api"},
+			path:     "/static/api",
+			expected: "/static/api",
+		},
+		{
+			name:     "add prefix",
+			rewrite:  values.Rewrite{AddPrefix: "/v2"},
+			path:     "/users",
+			expected: "/v2/users",
+		},
+		{
+			name:     "add prefix with trailing slash",
+			rewrite:  values.Rewrite{AddPrefix: "/v2/"},
+			path:     "/users",
+			expected: "/v2/users",
+		},
+		{
+			name:     "replace prefix",
+			rewrite:  values.Rewrite{StripPrefix: "/api/v1", AddPrefix: "/internal/v2"},
+			path:     "/api/v1/users",
+			expected: "/internal/v2/users",
+		},
+		{
+			name: "regex with capture groups",
+			rewrite: values.Rewrite{
+				Regex:       regexp.MustCompile(`^/users/([0-9]+)/orders/([0-9]+)$`),
+				Replacement: "/orders/$2/users/$1",
+			},
+			path:     "/users/42/orders/7",
+			expected: "/orders/7/users/42",
+		},
+		{
+			name: "regex that doesn't match",
+			rewrite: values.Rewrite{
+				Regex:       regexp.MustCompile(`^/users/([0-9]+)$`),
+				Replacement: "/accounts/$1",
+			},
+			path:     "/users/me",
+			expected: "/users/me",
+		},
+		{
+			name: "regex removing the leading slash",
+			rewrite: values.Rewrite{
+				Regex:       regexp.MustCompile(`^/legacy`),
+				Replacement: "",
+			},
+			path:     "/legacy",
+			expected: "/",
+		},
+		{
+			name: "strip, regex and add",
+			rewrite: values.Rewrite{
+				StripPrefix: "/api",
+				Regex:       regexp.MustCompile(`/v([0-9]+)/`),
+				Replacement: "/version-${1}/",
+				AddPrefix:   "/backend",
+			},
+			path:     "/api/v3/users",
+			expected: "/backend/version-3/users",
+		},
+		{
+			name:     "encoded characters are kept",
+			rewrite:  values.Rewrite{StripPrefix: "/api", AddPrefix: "/files"},
+			path:     "/api/a%2Fb/c%20d",
+			expected: "/files/a%2Fb/c%20d",
+		},
+		{
+			name: "regex on encoded characters",
+			rewrite: values.Rewrite{
+				Regex:       regexp.MustCompile(`%2F`),
+				Replacement: "/",
+			},
+			path:     "/files/a%2Fb",
+			expected: "/files/a/b",
+		},
+		{
+			name:     "double slashes after the stripped prefix are kept",
+			rewrite:  values.Rewrite{StripPrefix: "/api"},
+			path:     "/api//v1//users",
+			expected: "//v1//users",
+		},
+		{
+			name:     "double slashes before the added prefix are kept",
+			rewrite:  values.Rewrite{AddPrefix: "/v2/"},
+			path:     "//users",
+			expected: "/v2//users",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.rewrite.Apply(tc.path))
+		})
+	}
+}
+
+func TestRouteRewriteRequest(t *testing.T) {
+	route := &values.Route{
+		Rewrite: &values.Rewrite{StripPrefix: "/api/v2", AddPrefix: "/v2"},
+	}
+
+	request := &values.Request{
+		Method:     "GET",
+		Endpoint:   "api/v2/users",
+		Header:     http.Header{},
+		Parameters: "page=2&size=10",
+	}
+
+	rewritten := route.RewriteRequest(request)
+
+	assert.Equal(t, "v2/users", rewritten.Endpoint)
+	assert.Equal(t, "page=2&size=10", rewritten.Parameters)
+	// the request of the client is left untouched
+	assert.Equal(t, "api/v2/users", request.Endpoint)
+}
+
+func TestRouteRewriteRequestWithoutRewrite(t *testing.T) {
+	request := &values.Request{Endpoint: "api/v2/users"}
+
+	var noRoute *values.Route
+	assert.Same(t, request, noRoute.RewriteRequest(request))
+	assert.Same(t, request, (&values.Route{}).RewriteRequest(request))
+}
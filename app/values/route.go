@@ -0,0 +1,224 @@
+package values
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Type Route sends the requests of a service that match it
+// to one of the host pools of the service
+type Route struct {
+	Name  string // name of the route in the metrics and logs
+	Match RouteMatch
+	Pool  *Service // pool that receives the matching requests
+	Split *Split   // split of the matching requests when Pool is nil
+
+	// rewrite of the path of the matching requests, nil to keep it
+	Rewrite *Rewrite
+	// changes of the headers of the matching requests and
+	// their responses, nil to keep them
+	Headers *HeaderPolicy
+
+	// redirect or fixed response that answers the matching requests
+	// without forwarding them, used instead of Pool and Split
+	Redirect *Redirect
+	Response *DirectResponse
+}
+
+// DefaultRouteName is the name of the requests
+// that match no route in the metrics and logs
+const DefaultRouteName = "default"
+
+// Type Split shares the requests between host pools in
+// proportion to their weights, which add up to 100
+type Split struct {
+	Pools []WeightedPool
+	// key that makes a client always get the same pool,
+	// nil when every request picks a pool on its own
+	Sticky *HashKey
+}
+
+// Type WeightedPool is a pool that receives a
+// percentage of the requests of a split
+type WeightedPool struct {
+	Pool   *Service
+	Weight int
+}
+
+// Type RouteMatch describes the requests that match a route. The path
+// is compared in at most one of the ways below, and a request matches
+// only when its path and every header, query and cookie matcher match.
+type RouteMatch struct {
+	Path   string         // path that must be equal to the request path
+	Prefix string         // prefix that the request path must start with
+	Regex  *regexp.Regexp // expression that must match the request path
+
+	Headers []ValueMatch // headers that the request must have
+	Query   []ValueMatch // query parameters that the request must have
+	Cookies []ValueMatch // cookies that the request must have
+}
+
+// Type ValueMatch describes a named value of a request, such as a header,
+// that must be present and, optionally, equal to a value or match an
+// expression
+type ValueMatch struct {
+	Name  string
+	Value string         // value that the request value must be equal to
+	Regex *regexp.Regexp // expression that the request value must match
+}
+
+// Matches checks if a request matches the route
+func (m *RouteMatch) Matches(request *Request) bool {
+	if !m.matchesPath(request.Path()) {
+		return false
+	}
+
+	for _, header := range m.Headers {
+		if !header.matchesAny(request.Header.Values(header.Name)) {
+			return false
+		}
+	}
+
+	if len(m.Query) > 0 {
+		parameters, _ := url.ParseQuery(request.Parameters)
+		for _, parameter := range m.Query {
+			if !parameter.matchesAny(parameters[parameter.Name]) {
+				return false
+			}
+		}
+	}
+
+	for _, cookie := range m.Cookies {
+		var value []string
+		if c, err := (&http.Request{Header: request.Header}).Cookie(cookie.Name); err == nil {
+			value = append(value, c.Value)
+		}
+
+		if !cookie.matchesAny(value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesPath checks if a request path matches the route,
+// which is always the case when the route has no path matcher
+func (m *RouteMatch) matchesPath(path string) bool {
+	switch {
+	case m.Regex != nil:
+		return m.Regex.MatchString(path)
+	case m.Prefix != "":
+		return strings.HasPrefix(path, m.Prefix)
+	case m.Path != "":
+		return path == m.Path
+	default:
+		return true
+	}
+}
+
+// matchesAny checks if any of the values that a request has
+// under the name of the matcher matches it
+func (m *ValueMatch) matchesAny(values []string) bool {
+	for _, value := range values {
+		switch {
+		case m.Regex != nil:
+			if m.Regex.MatchString(value) {
+				return true
+			}
+		case m.Value != "":
+			if value == m.Value {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+
+	return false
+}
+
+// Pick chooses the pool that receives a request by weight. Requests that
+// carry the sticky key always get the same pool, as long as the weights
+// don't change, while the others get a random one.
+func (s *Split) Pick(request *Request) *Service {
+	var bucket int
+	if key := s.stickyKey(request); key != "" {
+		hasher := fnv.New32a()
+		_, _ = hasher.Write([]byte(key))
+		bucket = int(hasher.Sum32() % 100)
+	} else {
+		bucket = rand.Intn(100)
+	}
+
+	for _, pool := range s.Pools {
+		if bucket < pool.Weight {
+			return pool.Pool
+		}
+		bucket -= pool.Weight
+	}
+
+	return s.Pools[len(s.Pools)-1].Pool
+}
+
+// stickyKey returns the sticky key of a request, or an empty
+// string when the split isn't sticky
+func (s *Split) stickyKey(request *Request) string {
+	if s.Sticky == nil {
+		return ""
+	}
+
+	return s.Sticky.Value(request)
+}
+
+// Route returns the part of the service that receives a request, which is
+// the pool of the first route that matches the request. When no route
+// matches, the request goes to the split of the service, if it has one,
+// or to the service itself with its own hosts.
+func (s *Service) Route(request *Request) *Service {
+	return s.Target(s.MatchRoute(request), request)
+}
+
+// MatchRoute returns the first route of the service that
+// matches a request, or nil when no route matches it
+func (s *Service) MatchRoute(request *Request) *Route {
+	for _, route := range s.Routes {
+		if route.Match.Matches(request) {
+			return route
+		}
+	}
+
+	return nil
+}
+
+// Target returns the part of the service that receives a request matched
+// by a route, where a nil route stands for the requests that match none
+func (s *Service) Target(route *Route, request *Request) *Service {
+	switch {
+	case route == nil && s.Split != nil:
+		return s.Split.Pick(request)
+	case route == nil:
+		return s
+	case route.Pool != nil:
+		return route.Pool
+	case route.Split != nil:
+		return route.Split.Pick(request)
+	default:
+		// routes that answer the requests themselves have no hosts
+		return nil
+	}
+}
+
+// RouteName returns the name of a route, where a nil route
+// stands for the requests that match none
+func RouteName(route *Route) string {
+	if route == nil {
+		return DefaultRouteName
+	}
+
+	return route.Name
+}
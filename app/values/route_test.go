@@ -0,0 +1,314 @@
+package values_test
+
+import (
+	"fmt"
+	"go-reverse-proxy/app/values"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoute(t *testing.T) {
+	v1 := &values.Service{Name: "service/v1"}
+	v2 := &values.Service{Name: "service/v2"}
+	health := &values.Service{Name: "service/health"}
+
+	service := &values.Service{
+		Name:  "service",
+		Pools: []*values.Service{v1, v2, health},
+		Routes: []*values.Route{
+			{
+				Match: values.RouteMatch{Path: "/health"},
+				Pool:  health,
+			},
+			{
+				Match: values.RouteMatch{Prefix: "/api/v2/"},
+				Pool:  v2,
+			},
+			{
+				Match: values.RouteMatch{Regex: regexp.MustCompile(`^/api/v[0-9]+/`)},
+				Pool:  v1,
+			},
+			{
+				// shadowed by the route above, the first match wins
+				Match: values.RouteMatch{Prefix: "/api/v3/"},
+				Pool:  v2,
+			},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		endpoint string
+		expected *values.Service
+	}{
+		{
+			name:     "exact path",
+			endpoint: "health",
+			expected: health,
+		},
+		{
+			name:     "exact path doesn't match longer paths",
+			endpoint: "health/live",
+			expected: service,
+		},
+		{
+			name:     "prefix",
+			endpoint: "api/v2/users",
+			expected: v2,
+		},
+		{
+			name:     "regex",
+			endpoint: "api/v1/users",
+			expected: v1,
+		},
+		{
+			name:     "first match wins",
+			endpoint: "api/v3/users",
+			expected: v1,
+		},
+		{
+			name:     "no match",
+			endpoint: "static/index.html",
+			expected: service,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			route := service.Route(&values.Request{Endpoint: tc.endpoint})
+
+			assert.Same(t, tc.expected, route)
+		})
+	}
+}
+
+func TestRouteWithoutRoutes(t *testing.T) {
+	service := &values.Service{Name: "service"}
+
+	assert.Same(t, service, service.Route(&values.Request{Endpoint: "api/v1"}))
+}
+
+func TestRouteMatchers(t *testing.T) {
+	canary := &values.Service{Name: "service/canary"}
+	partners := &values.Service{Name: "service/partners"}
+	beta := &values.Service{Name: "service/beta"}
+
+	service := &values.Service{
+		Name:  "service",
+		Pools: []*values.Service{canary, partners, beta},
+		Routes: []*values.Route{
+			{
+				Match: values.RouteMatch{
+					Prefix: "/api/",
+					Headers: []values.ValueMatch{
+						{Name: "X-Canary", Value: "true"},
+					},
+				},
+				Pool: canary,
+			},
+			{
+				Match: values.RouteMatch{
+					Query: []values.ValueMatch{
+						{Name: "partner", Regex: regexp.MustCompile(`^acme-`)},
+						{Name: "token"},
+					},
+				},
+				Pool: partners,
+			},
+			{
+				Match: values.RouteMatch{
+					Headers: []values.ValueMatch{
+						{Name: "X-Beta"},
+					},
+					Cookies: []values.ValueMatch{
+						{Name: "group", Value: "beta"},
+					},
+				},
+				Pool: beta,
+			},
+		},
+	}
+
+	testCases := []struct {
+		name       string
+		endpoint   string
+		header     http.Header
+		parameters string
+		expected   *values.Service
+	}{
+		{
+			name:     "header value",
+			endpoint: "api/v1",
+			header:   http.Header{"X-Canary": {"true"}},
+			expected: canary,
+		},
+		{
+			name:     "header value and path must both match",
+			endpoint: "static/index.html",
+			header:   http.Header{"X-Canary": {"true"}},
+			expected: service,
+		},
+		{
+			name:     "different header value",
+			endpoint: "api/v1",
+			header:   http.Header{"X-Canary": {"false"}},
+			expected: service,
+		},
+		{
+			name:     "any of the header values",
+			endpoint: "api/v1",
+			header:   http.Header{"X-Canary": {"false", "true"}},
+			expected: canary,
+		},
+		{
+			name:       "query regex and presence",
+			endpoint:   "api/v1",
+			parameters: "partner=acme-eu&token=",
+			expected:   partners,
+		},
+		{
+			name:       "query missing a parameter",
+			endpoint:   "api/v1",
+			parameters: "partner=acme-eu",
+			expected:   service,
+		},
+		{
+			name:       "query regex not matching",
+			endpoint:   "api/v1",
+			parameters: "partner=other&token=1",
+			expected:   service,
+		},
+		{
+			name:     "header presence and cookie value",
+			endpoint: "api/v1",
+			header:   http.Header{"X-Beta": {""}, "Cookie": {"session=1; group=beta"}},
+			expected: beta,
+		},
+		{
+			name:     "cookie without the header",
+			endpoint: "api/v1",
+			header:   http.Header{"Cookie": {"group=beta"}},
+			expected: service,
+		},
+		{
+			name:     "first match wins",
+			endpoint: "api/v1",
+			header:   http.Header{"X-Canary": {"true"}, "X-Beta": {"1"}, "Cookie": {"group=beta"}},
+			expected: canary,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := tc.header
+			if header == nil {
+				header = http.Header{}
+			}
+
+			route := service.Route(&values.Request{
+				Endpoint:   tc.endpoint,
+				Header:     header,
+				Parameters: tc.parameters,
+			})
+
+			assert.Same(t, tc.expected, route)
+		})
+	}
+}
+
+func TestSplitPick(t *testing.T) {
+	v1 := &values.Service{Name: "service/v1"}
+	v2 := &values.Service{Name: "service/v2"}
+
+	split := &values.Split{
+		Pools: []values.WeightedPool{
+			{Pool: v1, Weight: 90},
+			{Pool: v2, Weight: 10},
+		},
+	}
+
+	picks := make(map[*values.Service]int)
+	for i := 0; i < 10000; i++ {
+		picks[split.Pick(&values.Request{Header: http.Header{}})]++
+	}
+
+	assert.InDelta(t, 9000, picks[v1], 300)
+	assert.InDelta(t, 1000, picks[v2], 300)
+}
+
+func TestSplitPickWithoutWeight(t *testing.T) {
+	v1 := &values.Service{Name: "service/v1"}
+	v2 := &values.Service{Name: "service/v2"}
+
+	split := &values.Split{
+		Pools: []values.WeightedPool{
+			{Pool: v1, Weight: 0},
+			{Pool: v2, Weight: 100},
+		},
+	}
+
+	for i := 0; i < 100; i++ {
+		assert.Same(t, v2, split.Pick(&values.Request{Header: http.Header{}}))
+	}
+}
+
+func TestSplitPickSticky(t *testing.T) {
+	v1 := &values.Service{Name: "service/v1"}
+	v2 := &values.Service{Name: "service/v2"}
+
+	split := &values.Split{
+		Pools: []values.WeightedPool{
+			{Pool: v1, Weight: 50},
+			{Pool: v2, Weight: 50},
+		},
+		Sticky: &values.HashKey{Source: values.HashKeyHeader, Name: "X-User"},
+	}
+
+	picks := make(map[*values.Service]int)
+	for user := 0; user < 1000; user++ {
+		request := &values.Request{Header: http.Header{"X-User": {fmt.Sprintf("user-%d", user)}}}
+
+		pool := split.Pick(request)
+		picks[pool]++
+
+		// the same client always gets the same pool
+		for i := 0; i < 5; i++ {
+			assert.Same(t, pool, split.Pick(request))
+		}
+	}
+
+	assert.InDelta(t, 500, picks[v1], 100)
+	assert.InDelta(t, 500, picks[v2], 100)
+}
+
+func TestRouteSplit(t *testing.T) {
+	v1 := &values.Service{Name: "service/v1"}
+	v2 := &values.Service{Name: "service/v2"}
+
+	service := &values.Service{
+		Name:  "service",
+		Pools: []*values.Service{v1, v2},
+		Routes: []*values.Route{
+			{
+				Match: values.RouteMatch{Prefix: "/api/v2/"},
+				Split: &values.Split{
+					Pools: []values.WeightedPool{
+						{Pool: v2, Weight: 100},
+					},
+				},
+			},
+		},
+		Split: &values.Split{
+			Pools: []values.WeightedPool{
+				{Pool: v1, Weight: 100},
+				{Pool: v2, Weight: 0},
+			},
+		},
+	}
+
+	assert.Same(t, v2, service.Route(&values.Request{Endpoint: "api/v2/users", Header: http.Header{}}))
+	assert.Same(t, v1, service.Route(&values.Request{Endpoint: "api/v1/users", Header: http.Header{}}))
+}
@@ -1,6 +1,75 @@
 package values
 
-import "fmt"
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHostWeight = 1
+
+	// the load balancers build a schedule and a hash ring whose size grows
+	// with the weights of the hosts, so weights are kept within a bound
+	maxHostWeight = 1000
+
+	defaultHealthCheckPath               = "/"
+	defaultHealthCheckInterval           = 10 * time.Second
+	defaultHealthCheckTimeout            = 2 * time.Second
+	defaultHealthCheckExpectedStatus     = "200-299"
+	defaultHealthCheckHealthyThreshold   = 2
+	defaultHealthCheckUnhealthyThreshold = 3
+
+	// shorter timeouts fail every probe, and durations without a unit
+	// are read as nanoseconds
+	minHealthCheckTimeout = 10 * time.Millisecond
+
+	defaultOutlierConsecutiveErrors  = 5
+	defaultOutlierBaseEjectionTime   = 30 * time.Second
+	defaultOutlierMaxEjectionTime    = 300 * time.Second
+	defaultOutlierMaxEjectionPercent = 10
+
+	defaultCircuitBreakerFailureRateThreshold = 50
+	defaultCircuitBreakerMinimumRequests      = 20
+	defaultCircuitBreakerWindow               = 10 * time.Second
+	defaultCircuitBreakerOpenTime             = 30 * time.Second
+	defaultCircuitBreakerHalfOpenRequests     = 3
+
+	defaultRetryMaxAttempts = 3
+	defaultRetryBackoffBase = 25 * time.Millisecond
+	defaultRetryBackoffMax  = 250 * time.Millisecond
+
+	defaultRetryBudgetRetryPercent        = 20
+	defaultRetryBudgetMinRetriesPerSecond = 10
+	defaultRetryBudgetWindow              = 10 * time.Second
+
+	defaultHedgingDelay         = 50 * time.Millisecond
+	defaultHedgingBudgetPercent = 10
+
+	defaultMirrorPercent = 100
+	defaultMirrorTimeout = 5 * time.Second
+
+	defaultShadowDiffLogPercent = 1
+
+	defaultRedirectStatus       = http.StatusMovedPermanently
+	defaultDirectResponseStatus = http.StatusOK
+)
+
+var defaultRetryableStatusCodes = []int{500, 502, 503, 504}
+
+var (
+	// characters allowed in header names by RFC 7230
+	headerNameRegex = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+	// placeholders of the header values, such as {client_ip}
+	headerPlaceholderRegex = regexp.MustCompile(`\{[^{}]*\}`)
+)
 
 // Type YamlConfig is the structure where the proxy
 // configuration .yaml will be parsed into
@@ -14,19 +83,110 @@ func (y *YamlConfig) ToConfiguration() (*Configuration, error) {
 	services := make(map[string]*Service)
 
 	for _, service := range y.Proxy.Services {
-		var hosts []*Host
-		for _, host := range service.Hosts {
-			hosts = append(hosts, &Host{
-				Address: host.Address,
-				Port:    host.Port,
-			})
+		domain, err := parseDomain(service.Domain)
+		if err != nil {
+			return nil, err
 		}
 
-		services[service.Domain] = &Service{
-			Name:   service.Name,
-			Domain: service.Domain,
-			Hosts:  hosts,
+		if _, ok := services[domain]; ok {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: duplicated domain %s", domain)
 		}
+
+		hosts, err := parseHosts(service.Hosts)
+		if err != nil {
+			return nil, err
+		}
+
+		loadBalancer, err := parseLoadBalancer(service.LoadBalancer)
+		if err != nil {
+			return nil, err
+		}
+
+		hashKey, err := parseHashKey(loadBalancer, service.HashKey)
+		if err != nil {
+			return nil, err
+		}
+
+		decayTime, err := parseDecayTime(loadBalancer, service.DecayTime)
+		if err != nil {
+			return nil, err
+		}
+
+		healthCheck, err := parseHealthCheck(service.HealthCheck)
+		if err != nil {
+			return nil, err
+		}
+
+		outlierDetection, err := parseOutlierDetection(service.OutlierDetection)
+		if err != nil {
+			return nil, err
+		}
+
+		circuitBreaker, err := parseCircuitBreaker(service.CircuitBreaker)
+		if err != nil {
+			return nil, err
+		}
+
+		retry, err := parseRetryPolicy(service.Retry)
+		if err != nil {
+			return nil, err
+		}
+
+		retryBudget, err := parseRetryBudget(service.RetryBudget)
+		if err != nil {
+			return nil, err
+		}
+
+		hedging, err := parseHedging(service.Hedging)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed := &Service{
+			Name:             service.Name,
+			Domain:           service.Domain,
+			Hosts:            hosts,
+			LoadBalancer:     loadBalancer,
+			HashKey:          hashKey,
+			DecayTime:        decayTime,
+			HealthCheck:      healthCheck,
+			OutlierDetection: outlierDetection,
+			CircuitBreaker:   circuitBreaker,
+			Retry:            retry,
+			RetryBudget:      retryBudget,
+			Hedging:          hedging,
+		}
+
+		parsed.Mirror, err = parseMirror(parsed, service.Mirror)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed.Headers, err = parseHeaderPolicy(service.Headers)
+		if err != nil {
+			return nil, err
+		}
+
+		pools, err := parsePools(parsed, service.Pools)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed.Routes, err = parseRoutes(pools, service.Routes)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed.Split, err = parseSplit(pools, service.Split)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pool := range service.Pools {
+			parsed.Pools = append(parsed.Pools, pools[pool.Name])
+		}
+
+		services[domain] = parsed
 	}
 
 	hostAddress := y.Proxy.Listen.Address
@@ -36,26 +196,1171 @@ func (y *YamlConfig) ToConfiguration() (*Configuration, error) {
 		return nil, fmt.Errorf("the .yaml configuration is invalid")
 	}
 
+	defaultService, err := parseDefaultService(services, y.Proxy.DefaultService)
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := parseTrustedProxies(y.Proxy.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	replayBufferSize := y.Proxy.ReplayBufferSize
+	if replayBufferSize < 0 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: negative replay buffer size %d", replayBufferSize)
+	}
+
+	pathPrefix := y.Proxy.Listen.PathPrefix
+	if pathPrefix == "" {
+		pathPrefix = DefaultPathPrefix
+	}
+
 	return &Configuration{
 		Host: &Host{
 			Address: y.Proxy.Listen.Address,
 			Port:    y.Proxy.Listen.Port,
 		},
-		Services: services,
+		PathPrefix:       pathPrefix,
+		Services:         services,
+		DefaultService:   defaultService,
+		TrustedProxies:   trustedProxies,
+		ReplayBufferSize: replayBufferSize,
 	}, nil
 }
 
+// parseTrustedProxies validates the networks of the trusted proxies,
+// where a plain IP address stands for a network with just that address
+func parseTrustedProxies(proxies []string) (TrustedProxies, error) {
+	var parsed TrustedProxies
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("the .yaml configuration is invalid: bad trusted proxy %s", proxy)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			parsed = append(parsed, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: bad trusted proxy %s: %v", proxy, err)
+		}
+
+		parsed = append(parsed, network)
+	}
+
+	return parsed, nil
+}
+
+// parseDomain validates the domain of a service, which is either a plain
+// domain or a wildcard one whose only wildcard is its leading label
+func parseDomain(domain string) (string, error) {
+	domain = NormalizeDomain(domain)
+
+	plain := strings.TrimPrefix(domain, wildcardPrefix)
+	if plain == "" || strings.Contains(plain, "*") {
+		return "", fmt.Errorf("the .yaml configuration is invalid: bad service domain %s", domain)
+	}
+
+	return domain, nil
+}
+
+// parseDefaultService finds the service, by name, that receives
+// the requests whose host matches no service domain
+func parseDefaultService(services map[string]*Service, name string) (*Service, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	for _, service := range services {
+		if service.Name == name {
+			return service, nil
+		}
+	}
+
+	return nil, fmt.Errorf("the .yaml configuration is invalid: unknown default service %s", name)
+}
+
+// parseHosts validates the hosts of a service or pool, falling
+// back to the default weight when a host doesn't set one
+func parseHosts(hosts []HostYamlConfig) ([]*Host, error) {
+	var parsed []*Host
+	for _, host := range hosts {
+		weight := host.Weight
+		if weight == 0 {
+			weight = defaultHostWeight
+		}
+
+		if weight < 0 {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: negative weight on host %s", host.Address)
+		}
+
+		if weight > maxHostWeight {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: weight %d on host %s is above the maximum of %d", weight, host.Address, maxHostWeight)
+		}
+
+		parsed = append(parsed, &Host{
+			Address: host.Address,
+			Port:    host.Port,
+			Weight:  weight,
+		})
+	}
+
+	return parsed, nil
+}
+
+// parseLoadBalancer validates the load balancing algorithm of a service,
+// falling back to Round-Robin when none is configured
+func parseLoadBalancer(loadBalancer string) (string, error) {
+	switch loadBalancer {
+	case "":
+		return RoundRobin, nil
+	case RoundRobin, WeightedRoundRobin, LeastOutstandingRequests, ConsistentHash, PeakEWMA:
+		return loadBalancer, nil
+	default:
+		return "", fmt.Errorf("the .yaml configuration is invalid: unknown load balancer %s", loadBalancer)
+	}
+}
+
+// parseHashKey validates the hash key of a service that uses consistent
+// hashing, falling back to the client IP when none is configured
+func parseHashKey(loadBalancer string, hashKey HashKeyYamlConfig) (HashKey, error) {
+	if loadBalancer != ConsistentHash {
+		return HashKey{}, nil
+	}
+
+	switch hashKey.Source {
+	case "", HashKeyClientIP:
+		return HashKey{Source: HashKeyClientIP}, nil
+	case HashKeyHeader, HashKeyCookie, HashKeyQuery:
+		if hashKey.Name == "" {
+			return HashKey{}, fmt.Errorf("the .yaml configuration is invalid: hash key %s requires a name", hashKey.Source)
+		}
+
+		return HashKey{Source: hashKey.Source, Name: hashKey.Name}, nil
+	default:
+		return HashKey{}, fmt.Errorf("the .yaml configuration is invalid: unknown hash key source %s", hashKey.Source)
+	}
+}
+
+// parseDecayTime validates the latency decay time of a service that uses
+// the peak-EWMA algorithm, falling back to the default when none is set
+func parseDecayTime(loadBalancer string, decayTime time.Duration) (time.Duration, error) {
+	if loadBalancer != PeakEWMA {
+		return 0, nil
+	}
+
+	if decayTime < 0 {
+		return 0, fmt.Errorf("the .yaml configuration is invalid: negative decay time %s", decayTime)
+	}
+
+	if decayTime == 0 {
+		return DefaultDecayTime, nil
+	}
+
+	return decayTime, nil
+}
+
+// parseHealthCheck validates the health check of a service, filling
+// the settings that are not configured with their defaults
+func parseHealthCheck(healthCheck *HealthCheckYamlConfig) (*HealthCheck, error) {
+	if healthCheck == nil {
+		return nil, nil
+	}
+
+	parsed := &HealthCheck{
+		Path:               healthCheck.Path,
+		Interval:           healthCheck.Interval,
+		Timeout:            healthCheck.Timeout,
+		HealthyThreshold:   healthCheck.HealthyThreshold,
+		UnhealthyThreshold: healthCheck.UnhealthyThreshold,
+	}
+
+	if parsed.Path == "" {
+		parsed.Path = defaultHealthCheckPath
+	}
+	if !strings.HasPrefix(parsed.Path, "/") {
+		parsed.Path = "/" + parsed.Path
+	}
+	if parsed.Interval == 0 {
+		parsed.Interval = defaultHealthCheckInterval
+	}
+	// a probe can't outlast the interval between probes
+	if parsed.Timeout == 0 {
+		parsed.Timeout = defaultHealthCheckTimeout
+		if parsed.Interval > 0 && parsed.Interval < parsed.Timeout {
+			parsed.Timeout = parsed.Interval
+		}
+	}
+	if parsed.HealthyThreshold == 0 {
+		parsed.HealthyThreshold = defaultHealthCheckHealthyThreshold
+	}
+	if parsed.UnhealthyThreshold == 0 {
+		parsed.UnhealthyThreshold = defaultHealthCheckUnhealthyThreshold
+	}
+
+	if parsed.Interval < 0 || parsed.Timeout < 0 || parsed.HealthyThreshold < 0 || parsed.UnhealthyThreshold < 0 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: negative health check setting")
+	}
+
+	if parsed.Timeout < minHealthCheckTimeout {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: health check timeout %s is below the minimum of %s", parsed.Timeout, minHealthCheckTimeout)
+	}
+
+	if parsed.Interval < parsed.Timeout {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: health check interval %s is shorter than its timeout %s", parsed.Interval, parsed.Timeout)
+	}
+
+	expectedStatus := healthCheck.ExpectedStatus
+	if expectedStatus == "" {
+		expectedStatus = defaultHealthCheckExpectedStatus
+	}
+
+	var err error
+	parsed.ExpectedStatusMin, parsed.ExpectedStatusMax, err = parseStatusRange(expectedStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+// parseStatusRange parses a single status code such as "200", or
+// an inclusive range of status codes such as "200-399"
+func parseStatusRange(statusRange string) (int, int, error) {
+	bounds := strings.SplitN(statusRange, "-", 2)
+
+	min, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("the .yaml configuration is invalid: bad status range %s", statusRange)
+	}
+
+	max := min
+	if len(bounds) == 2 {
+		max, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if err != nil {
+			return 0, 0, fmt.Errorf("the .yaml configuration is invalid: bad status range %s", statusRange)
+		}
+	}
+
+	if min < 100 || max > 599 || min > max {
+		return 0, 0, fmt.Errorf("the .yaml configuration is invalid: bad status range %s", statusRange)
+	}
+
+	return min, max, nil
+}
+
+// parseOutlierDetection validates the outlier detection of a service,
+// filling the settings that are not configured with their defaults
+func parseOutlierDetection(outlierDetection *OutlierDetectionYamlConfig) (*OutlierDetection, error) {
+	if outlierDetection == nil {
+		return nil, nil
+	}
+
+	parsed := &OutlierDetection{
+		ConsecutiveErrors:  outlierDetection.ConsecutiveErrors,
+		BaseEjectionTime:   outlierDetection.BaseEjectionTime,
+		MaxEjectionTime:    outlierDetection.MaxEjectionTime,
+		MaxEjectionPercent: outlierDetection.MaxEjectionPercent,
+	}
+
+	if parsed.ConsecutiveErrors == 0 {
+		parsed.ConsecutiveErrors = defaultOutlierConsecutiveErrors
+	}
+	if parsed.BaseEjectionTime == 0 {
+		parsed.BaseEjectionTime = defaultOutlierBaseEjectionTime
+	}
+	if parsed.MaxEjectionTime == 0 {
+		parsed.MaxEjectionTime = defaultOutlierMaxEjectionTime
+	}
+	if parsed.MaxEjectionPercent == 0 {
+		parsed.MaxEjectionPercent = defaultOutlierMaxEjectionPercent
+	}
+
+	if parsed.ConsecutiveErrors < 0 || parsed.BaseEjectionTime < 0 || parsed.MaxEjectionTime < parsed.BaseEjectionTime {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: bad outlier detection ejection settings")
+	}
+
+	if parsed.MaxEjectionPercent < 0 || parsed.MaxEjectionPercent > 100 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: max ejection percent must be between 0 and 100")
+	}
+
+	return parsed, nil
+}
+
+// parseCircuitBreaker validates the circuit breaker of a service,
+// filling the settings that are not configured with their defaults
+func parseCircuitBreaker(circuitBreaker *CircuitBreakerYamlConfig) (*CircuitBreaker, error) {
+	if circuitBreaker == nil {
+		return nil, nil
+	}
+
+	parsed := &CircuitBreaker{
+		FailureRateThreshold: circuitBreaker.FailureRateThreshold,
+		MinimumRequests:      circuitBreaker.MinimumRequests,
+		Window:               circuitBreaker.Window,
+		OpenTime:             circuitBreaker.OpenTime,
+		HalfOpenRequests:     circuitBreaker.HalfOpenRequests,
+	}
+
+	if parsed.FailureRateThreshold == 0 {
+		parsed.FailureRateThreshold = defaultCircuitBreakerFailureRateThreshold
+	}
+	if parsed.MinimumRequests == 0 {
+		parsed.MinimumRequests = defaultCircuitBreakerMinimumRequests
+	}
+	if parsed.Window == 0 {
+		parsed.Window = defaultCircuitBreakerWindow
+	}
+	if parsed.OpenTime == 0 {
+		parsed.OpenTime = defaultCircuitBreakerOpenTime
+	}
+	if parsed.HalfOpenRequests == 0 {
+		parsed.HalfOpenRequests = defaultCircuitBreakerHalfOpenRequests
+	}
+
+	if parsed.MinimumRequests < 0 || parsed.Window < 0 || parsed.OpenTime < 0 || parsed.HalfOpenRequests < 0 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: negative circuit breaker setting")
+	}
+
+	if parsed.FailureRateThreshold < 0 || parsed.FailureRateThreshold > 100 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: failure rate threshold must be between 0 and 100")
+	}
+
+	return parsed, nil
+}
+
+// parseRetryPolicy validates the retry policy of a service, filling
+// the settings that are not configured with their defaults
+func parseRetryPolicy(retry *RetryYamlConfig) (*RetryPolicy, error) {
+	if retry == nil {
+		return nil, nil
+	}
+
+	parsed := &RetryPolicy{
+		RetryableStatusCodes: retry.RetryableStatusCodes,
+		MaxAttempts:          retry.MaxAttempts,
+		BackoffBase:          retry.Backoff.Base,
+		BackoffMax:           retry.Backoff.Max,
+		PerTryTimeout:        retry.PerTryTimeout,
+		IdempotencyKeyHeader: retry.IdempotencyKeyHeader,
+	}
+
+	if parsed.RetryableStatusCodes == nil {
+		parsed.RetryableStatusCodes = defaultRetryableStatusCodes
+	}
+	if parsed.MaxAttempts == 0 {
+		parsed.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if parsed.BackoffBase == 0 {
+		parsed.BackoffBase = defaultRetryBackoffBase
+	}
+	// a base above the default maximum is never doubled
+	if parsed.BackoffMax == 0 {
+		parsed.BackoffMax = defaultRetryBackoffMax
+		if parsed.BackoffBase > parsed.BackoffMax {
+			parsed.BackoffMax = parsed.BackoffBase
+		}
+	}
+	if parsed.IdempotencyKeyHeader == "" {
+		parsed.IdempotencyKeyHeader = DefaultIdempotencyKeyHeader
+	}
+
+	parsed.RetryableMethods = DefaultRetryableMethods
+	if retry.RetryableMethods != nil {
+		parsed.RetryableMethods = make([]string, len(retry.RetryableMethods))
+		for index, method := range retry.RetryableMethods {
+			parsed.RetryableMethods[index] = strings.ToUpper(method)
+		}
+	}
+
+	for _, statusCode := range parsed.RetryableStatusCodes {
+		if statusCode < 100 || statusCode > 599 {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: bad retryable status code %d", statusCode)
+		}
+	}
+
+	if parsed.MaxAttempts < 0 || parsed.BackoffBase < 0 || parsed.BackoffMax < parsed.BackoffBase || parsed.PerTryTimeout < 0 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: bad retry settings")
+	}
+
+	retryOn := retry.RetryOn
+	if retryOn == nil {
+		retryOn = []string{RetryOnConnectFailure, RetryOnTimeout, RetryOnReset}
+	}
+
+	for _, reason := range retryOn {
+		switch reason {
+		case RetryOnConnectFailure:
+			parsed.RetryOnConnectFailure = true
+		case RetryOnTimeout:
+			parsed.RetryOnTimeout = true
+		case RetryOnReset:
+			parsed.RetryOnReset = true
+		default:
+			return nil, fmt.Errorf("the .yaml configuration is invalid: unknown retry reason %s", reason)
+		}
+	}
+
+	return parsed, nil
+}
+
+// parseRetryBudget validates the retry budget of a service, filling
+// the settings that are not configured with their defaults
+func parseRetryBudget(retryBudget *RetryBudgetYamlConfig) (*RetryBudget, error) {
+	if retryBudget == nil {
+		return nil, nil
+	}
+
+	parsed := &RetryBudget{
+		RetryPercent:        retryBudget.RetryPercent,
+		MinRetriesPerSecond: retryBudget.MinRetriesPerSecond,
+		Window:              retryBudget.Window,
+	}
+
+	if parsed.RetryPercent == 0 {
+		parsed.RetryPercent = defaultRetryBudgetRetryPercent
+	}
+	if parsed.MinRetriesPerSecond == 0 {
+		parsed.MinRetriesPerSecond = defaultRetryBudgetMinRetriesPerSecond
+	}
+	if parsed.Window == 0 {
+		parsed.Window = defaultRetryBudgetWindow
+	}
+
+	if parsed.RetryPercent < 0 || parsed.MinRetriesPerSecond < 0 || parsed.Window < 0 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: negative retry budget setting")
+	}
+
+	return parsed, nil
+}
+
+// parseHedging validates the hedging of a service, filling the
+// settings that are not configured with their defaults
+func parseHedging(hedging *HedgingYamlConfig) (*Hedging, error) {
+	if hedging == nil {
+		return nil, nil
+	}
+
+	parsed := &Hedging{
+		Delay:           hedging.Delay,
+		DelayPercentile: hedging.DelayPercentile,
+		BudgetPercent:   hedging.BudgetPercent,
+	}
+
+	if parsed.Delay == 0 {
+		parsed.Delay = defaultHedgingDelay
+	}
+	if parsed.BudgetPercent == 0 {
+		parsed.BudgetPercent = defaultHedgingBudgetPercent
+	}
+
+	if parsed.Delay < 0 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: negative hedging delay %s", parsed.Delay)
+	}
+
+	if parsed.DelayPercentile < 0 || parsed.DelayPercentile > 100 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: hedging delay percentile must be between 0 and 100")
+	}
+
+	if parsed.BudgetPercent < 0 || parsed.BudgetPercent > 100 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: hedging budget percent must be between 0 and 100")
+	}
+
+	return parsed, nil
+}
+
+// parseMirror validates the mirror of a service, filling the
+// settings that are not configured with their defaults
+func parseMirror(service *Service, mirror *MirrorYamlConfig) (*Mirror, error) {
+	if mirror == nil {
+		return nil, nil
+	}
+
+	if len(mirror.Hosts) == 0 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: mirror of service %s without hosts", service.Name)
+	}
+
+	hosts, err := parseHosts(mirror.Hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &Mirror{
+		Service: &Service{
+			Name:         fmt.Sprintf("%s/mirror", service.Name),
+			Domain:       service.Domain,
+			Hosts:        hosts,
+			LoadBalancer: RoundRobin,
+		},
+		Percent: mirror.Percent,
+		Header:  mirror.Header,
+		Timeout: mirror.Timeout,
+	}
+
+	if parsed.Percent == 0 {
+		parsed.Percent = defaultMirrorPercent
+	}
+	if parsed.Header == "" {
+		parsed.Header = DefaultMirrorHeader
+	}
+	if parsed.Timeout == 0 {
+		parsed.Timeout = defaultMirrorTimeout
+	}
+
+	if parsed.Percent < 0 || parsed.Percent > 100 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: mirror percent must be between 0 and 100")
+	}
+
+	if parsed.Timeout < 0 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: negative mirror timeout %s", parsed.Timeout)
+	}
+
+	parsed.Diff, err = parseShadowDiff(mirror.Diff)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+// parseShadowDiff validates the comparison of the mirrored responses,
+// filling the settings that are not configured with their defaults
+func parseShadowDiff(diff *ShadowDiffYamlConfig) (*ShadowDiff, error) {
+	if diff == nil {
+		return nil, nil
+	}
+
+	parsed := &ShadowDiff{
+		Headers:       diff.Headers,
+		IgnoredFields: diff.IgnoredFields,
+		LogPercent:    diff.LogPercent,
+	}
+
+	if parsed.LogPercent == 0 {
+		parsed.LogPercent = defaultShadowDiffLogPercent
+	}
+
+	if parsed.LogPercent < 0 || parsed.LogPercent > 100 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: shadow diff log percent must be between 0 and 100")
+	}
+
+	for _, field := range parsed.IgnoredFields {
+		if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: bad ignored field %q", field)
+		}
+	}
+
+	return parsed, nil
+}
+
+// parsePools builds the host pools of a service, by name, which share
+// every setting of the service except for their hosts and, optionally,
+// their load balancing algorithm
+func parsePools(service *Service, pools []PoolYamlConfig) (map[string]*Service, error) {
+	parsed := make(map[string]*Service, len(pools))
+
+	for _, pool := range pools {
+		if pool.Name == "" {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: pool of service %s without a name", service.Name)
+		}
+
+		if _, ok := parsed[pool.Name]; ok {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: duplicated pool %s", pool.Name)
+		}
+
+		hosts, err := parseHosts(pool.Hosts)
+		if err != nil {
+			return nil, err
+		}
+
+		parsedPool := *service
+		parsedPool.Name = fmt.Sprintf("%s/%s", service.Name, pool.Name)
+		parsedPool.Hosts = hosts
+
+		if pool.LoadBalancer != "" {
+			parsedPool.LoadBalancer, err = parseLoadBalancer(pool.LoadBalancer)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// the hash key and decay time of the service are kept unless the
+		// pool sets its own or uses a different load balancer
+		changed := parsedPool.LoadBalancer != service.LoadBalancer
+
+		if changed || pool.HashKey != (HashKeyYamlConfig{}) {
+			parsedPool.HashKey, err = parseHashKey(parsedPool.LoadBalancer, pool.HashKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if changed || pool.DecayTime != 0 {
+			parsedPool.DecayTime, err = parseDecayTime(parsedPool.LoadBalancer, pool.DecayTime)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		parsed[pool.Name] = &parsedPool
+	}
+
+	return parsed, nil
+}
+
+// parseRoutes builds the ordered routes that send the
+// requests of a service to its pools
+func parseRoutes(pools map[string]*Service, routes []RouteYamlConfig) ([]*Route, error) {
+	var parsed []*Route
+	for i, route := range routes {
+		actions := 0
+		for _, set := range []bool{route.Pool != "", route.Split != nil, route.Redirect != nil, route.Response != nil} {
+			if set {
+				actions++
+			}
+		}
+
+		if actions != 1 {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: a route must have exactly one of a pool, a split, a redirect or a response")
+		}
+
+		match, err := parseRouteMatch(route.Match)
+		if err != nil {
+			return nil, err
+		}
+
+		rewrite, err := parseRewrite(route.Rewrite)
+		if err != nil {
+			return nil, err
+		}
+
+		headers, err := parseHeaderPolicy(route.Headers)
+		if err != nil {
+			return nil, err
+		}
+
+		parsedRoute := &Route{Name: route.Name, Match: match, Rewrite: rewrite, Headers: headers}
+		if parsedRoute.Name == "" {
+			parsedRoute.Name = fmt.Sprintf("route_%d", i+1)
+		}
+
+		if route.Pool != "" {
+			pool, ok := pools[route.Pool]
+			if !ok {
+				return nil, fmt.Errorf("the .yaml configuration is invalid: route to unknown pool %s", route.Pool)
+			}
+
+			parsedRoute.Pool = pool
+		}
+
+		switch {
+		case route.Split != nil:
+			parsedRoute.Split, err = parseSplit(pools, route.Split)
+		case route.Redirect != nil:
+			parsedRoute.Redirect, err = parseRedirect(route.Redirect)
+		case route.Response != nil:
+			parsedRoute.Response, err = parseDirectResponse(route.Response)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		parsed = append(parsed, parsedRoute)
+	}
+
+	return parsed, nil
+}
+
+// parseRedirect validates the redirect that a route answers with,
+// which is permanent unless another redirect status is configured
+func parseRedirect(redirect *RedirectYamlConfig) (*Redirect, error) {
+	if redirect.Target == "" {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: redirect without a target")
+	}
+
+	parsed := &Redirect{StatusCode: redirect.Status, Target: redirect.Target}
+	if parsed.StatusCode == 0 {
+		parsed.StatusCode = defaultRedirectStatus
+	}
+
+	switch parsed.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("the .yaml configuration is invalid: bad redirect status %d", parsed.StatusCode)
+	}
+
+	return parsed, nil
+}
+
+// parseDirectResponse validates the fixed response that a route answers
+// with, whose body is either set inline or read from a file once, when
+// the configuration is loaded
+func parseDirectResponse(response *DirectResponseYamlConfig) (*DirectResponse, error) {
+	parsed := &DirectResponse{StatusCode: response.Status, Header: http.Header{}, Body: []byte(response.Body)}
+	if parsed.StatusCode == 0 {
+		parsed.StatusCode = defaultDirectResponseStatus
+	}
+
+	if parsed.StatusCode < 100 || parsed.StatusCode > 599 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: bad response status %d", parsed.StatusCode)
+	}
+
+	for name, value := range response.Headers {
+		parsed.Header.Set(name, value)
+	}
+
+	if response.BodyFile != "" {
+		if response.Body != "" {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: a response must have either a body or a body file")
+		}
+
+		body, err := ioutil.ReadFile(response.BodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: cannot read response body file %s: %v", response.BodyFile, err)
+		}
+
+		parsed.Body = body
+	}
+
+	return parsed, nil
+}
+
+// parseHeaderPolicy validates the changes of the headers of the
+// requests and responses of a service or route
+func parseHeaderPolicy(policy *HeaderPolicyYamlConfig) (*HeaderPolicy, error) {
+	if policy == nil {
+		return nil, nil
+	}
+
+	request, err := parseHeaderRules(policy.Request)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := parseHeaderRules(policy.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	if request == nil && response == nil {
+		return nil, nil
+	}
+
+	return &HeaderPolicy{Request: request, Response: response}, nil
+}
+
+// parseHeaderRules validates the names of the headers that are changed
+// and the templates of their values. The environment variables that the
+// values use are read once, when the configuration is loaded.
+func parseHeaderRules(rules *HeaderRulesYamlConfig) (*HeaderRules, error) {
+	if rules == nil || (len(rules.Remove) == 0 && len(rules.Set) == 0 && len(rules.Add) == 0) {
+		return nil, nil
+	}
+
+	for _, name := range rules.Remove {
+		if !headerNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: bad header name %q", name)
+		}
+	}
+
+	set, err := parseHeaderValues(rules.Set)
+	if err != nil {
+		return nil, err
+	}
+
+	add, err := parseHeaderValues(rules.Add)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HeaderRules{Remove: rules.Remove, Set: set, Add: add}, nil
+}
+
+// parseHeaderValues validates the headers that are set or added, which
+// are sorted by name so that they are always applied in the same order
+func parseHeaderValues(values map[string]string) ([]HeaderValue, error) {
+	var parsed []HeaderValue
+	for name, value := range values {
+		if !headerNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: bad header name %q", name)
+		}
+
+		value, err := parseHeaderTemplate(value)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed = append(parsed, HeaderValue{Name: name, Value: value})
+	}
+
+	sort.Slice(parsed, func(i, j int) bool {
+		return parsed[i].Name < parsed[j].Name
+	})
+
+	return parsed, nil
+}
+
+// parseHeaderTemplate checks that a header value only uses the known
+// placeholders, replacing the environment variables by their values
+func parseHeaderTemplate(value string) (string, error) {
+	var err error
+	value = headerPlaceholderRegex.ReplaceAllStringFunc(value, func(placeholder string) string {
+		switch {
+		case placeholder == "{client_ip}", placeholder == "{request_id}", placeholder == "{service}":
+			return placeholder
+		case strings.HasPrefix(placeholder, "{env:"):
+			name := strings.TrimSuffix(strings.TrimPrefix(placeholder, "{env:"), "}")
+			env, ok := os.LookupEnv(name)
+			if !ok && err == nil {
+				err = fmt.Errorf("the .yaml configuration is invalid: environment variable %s is not set", name)
+			}
+			return env
+		default:
+			if err == nil {
+				err = fmt.Errorf("the .yaml configuration is invalid: unknown header placeholder %s", placeholder)
+			}
+			return placeholder
+		}
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	if strings.ContainsAny(value, "\r\n") {
+		return "", fmt.Errorf("the .yaml configuration is invalid: header value with a line break")
+	}
+
+	return value, nil
+}
+
+// parseRewrite validates the rewrite of the path of the requests
+// matched by a route, whose prefixes must be absolute paths
+func parseRewrite(rewrite *RewriteYamlConfig) (*Rewrite, error) {
+	if rewrite == nil {
+		return nil, nil
+	}
+
+	for _, prefix := range []string{rewrite.StripPrefix, rewrite.AddPrefix} {
+		if prefix != "" && !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: rewrite prefix %s must start with /", prefix)
+		}
+	}
+
+	if rewrite.Regex == "" && rewrite.Replacement != "" {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: rewrite replacement without a regex")
+	}
+
+	parsed := &Rewrite{
+		StripPrefix: rewrite.StripPrefix,
+		Replacement: rewrite.Replacement,
+		AddPrefix:   rewrite.AddPrefix,
+	}
+
+	if rewrite.Regex != "" {
+		regex, err := regexp.Compile(rewrite.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: bad rewrite regex %s: %v", rewrite.Regex, err)
+		}
+
+		parsed.Regex = regex
+	}
+
+	if parsed.StripPrefix == "" && parsed.Regex == nil && parsed.AddPrefix == "" {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: rewrite without changes")
+	}
+
+	return parsed, nil
+}
+
+// parseSplit validates the weights of a split between pools, which are
+// percentages and must add up to 100, and the key that makes it sticky
+func parseSplit(pools map[string]*Service, split *SplitYamlConfig) (*Split, error) {
+	if split == nil {
+		return nil, nil
+	}
+
+	if len(split.Pools) == 0 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: split without pools")
+	}
+
+	parsed := &Split{}
+
+	var total int
+	for _, weighted := range split.Pools {
+		pool, ok := pools[weighted.Pool]
+		if !ok {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: split to unknown pool %s", weighted.Pool)
+		}
+
+		if weighted.Weight < 0 {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: negative weight on pool %s", weighted.Pool)
+		}
+
+		total += weighted.Weight
+		parsed.Pools = append(parsed.Pools, WeightedPool{Pool: pool, Weight: weighted.Weight})
+	}
+
+	if total != 100 {
+		return nil, fmt.Errorf("the .yaml configuration is invalid: split weights add up to %d instead of 100", total)
+	}
+
+	if split.Sticky != nil {
+		sticky, err := parseHashKey(ConsistentHash, *split.Sticky)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed.Sticky = &sticky
+	}
+
+	return parsed, nil
+}
+
+// parseRouteMatch validates that a route matches the request path in at
+// most one way, and that it matches the requests on something at all
+func parseRouteMatch(match RouteMatchYamlConfig) (RouteMatch, error) {
+	var ways int
+	for _, value := range []string{match.Path, match.Prefix, match.Regex} {
+		if value != "" {
+			ways++
+		}
+	}
+
+	if ways > 1 {
+		return RouteMatch{}, fmt.Errorf("the .yaml configuration is invalid: a route must match at most one of path, prefix or regex")
+	}
+
+	if ways == 0 && len(match.Headers)+len(match.Query)+len(match.Cookies) == 0 {
+		return RouteMatch{}, fmt.Errorf("the .yaml configuration is invalid: a route must match the path, headers, query or cookies")
+	}
+
+	parsed := RouteMatch{Path: match.Path, Prefix: match.Prefix}
+
+	if match.Regex != "" {
+		regex, err := regexp.Compile(match.Regex)
+		if err != nil {
+			return RouteMatch{}, fmt.Errorf("the .yaml configuration is invalid: bad route regex %s: %v", match.Regex, err)
+		}
+
+		parsed.Regex = regex
+	}
+
+	var err error
+	if parsed.Headers, err = parseValueMatches("header", match.Headers); err != nil {
+		return RouteMatch{}, err
+	}
+
+	if parsed.Query, err = parseValueMatches("query", match.Query); err != nil {
+		return RouteMatch{}, err
+	}
+
+	if parsed.Cookies, err = parseValueMatches("cookie", match.Cookies); err != nil {
+		return RouteMatch{}, err
+	}
+
+	return parsed, nil
+}
+
+// parseValueMatches validates the header, query or cookie matchers of a
+// route, each of which checks either the presence, the value or a regex
+func parseValueMatches(kind string, matches []ValueMatchYamlConfig) ([]ValueMatch, error) {
+	var parsed []ValueMatch
+	for _, match := range matches {
+		if match.Name == "" {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: route %s matcher without a name", kind)
+		}
+
+		if match.Value != "" && match.Regex != "" {
+			return nil, fmt.Errorf("the .yaml configuration is invalid: route %s matcher %s with both a value and a regex", kind, match.Name)
+		}
+
+		valueMatch := ValueMatch{Name: match.Name, Value: match.Value}
+
+		if match.Regex != "" {
+			regex, err := regexp.Compile(match.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("the .yaml configuration is invalid: bad route %s regex %s: %v", kind, match.Regex, err)
+			}
+
+			valueMatch.Regex = regex
+		}
+
+		parsed = append(parsed, valueMatch)
+	}
+
+	return parsed, nil
+}
+
 type ProxyYamlConfig struct {
-	Listen   HostYamlConfig
-	Services []ServiceYamlConfig `yaml:",flow"`
+	Listen         ListenYamlConfig
+	Services       []ServiceYamlConfig `yaml:",flow"`
+	DefaultService string              `yaml:"default_service"`
+	TrustedProxies []string            `yaml:"trusted_proxies,flow"`
+	// size of the replay buffer of the request bodies, in bytes
+	ReplayBufferSize int64 `yaml:"replay_buffer_size"`
 }
 
 type ServiceYamlConfig struct {
-	Name   string
-	Domain string
-	Hosts  []HostYamlConfig `yaml:",flow"`
+	Name             string
+	Domain           string
+	Hosts            []HostYamlConfig            `yaml:",flow"`
+	LoadBalancer     string                      `yaml:"load_balancer"`
+	HashKey          HashKeyYamlConfig           `yaml:"hash_key"`
+	DecayTime        time.Duration               `yaml:"decay_time"`
+	HealthCheck      *HealthCheckYamlConfig      `yaml:"health_check"`
+	OutlierDetection *OutlierDetectionYamlConfig `yaml:"outlier_detection"`
+	CircuitBreaker   *CircuitBreakerYamlConfig   `yaml:"circuit_breaker"`
+	Retry            *RetryYamlConfig
+	RetryBudget      *RetryBudgetYamlConfig `yaml:"retry_budget"`
+	Hedging          *HedgingYamlConfig
+	Pools            []PoolYamlConfig  `yaml:",flow"`
+	Routes           []RouteYamlConfig `yaml:",flow"`
+	Split            *SplitYamlConfig
+	Mirror           *MirrorYamlConfig
+	Headers          *HeaderPolicyYamlConfig
+}
+type ListenYamlConfig struct {
+	Address    string
+	Port       int32
+	PathPrefix string `yaml:"path_prefix"`
 }
 type HostYamlConfig struct {
 	Address string
 	Port    int32
+	Weight  int32
+}
+type HashKeyYamlConfig struct {
+	Source string
+	Name   string
+}
+type HealthCheckYamlConfig struct {
+	Path               string
+	Interval           time.Duration
+	Timeout            time.Duration
+	ExpectedStatus     string `yaml:"expected_status"`
+	HealthyThreshold   int    `yaml:"healthy_threshold"`
+	UnhealthyThreshold int    `yaml:"unhealthy_threshold"`
+}
+type OutlierDetectionYamlConfig struct {
+	ConsecutiveErrors  int           `yaml:"consecutive_errors"`
+	BaseEjectionTime   time.Duration `yaml:"base_ejection_time"`
+	MaxEjectionTime    time.Duration `yaml:"max_ejection_time"`
+	MaxEjectionPercent int           `yaml:"max_ejection_percent"`
+}
+type CircuitBreakerYamlConfig struct {
+	FailureRateThreshold int `yaml:"failure_rate_threshold"`
+	MinimumRequests      int `yaml:"minimum_requests"`
+	Window               time.Duration
+	OpenTime             time.Duration `yaml:"open_time"`
+	HalfOpenRequests     int           `yaml:"half_open_requests"`
+}
+type RetryYamlConfig struct {
+	RetryableStatusCodes []int    `yaml:"retryable_status_codes"`
+	RetryOn              []string `yaml:"retry_on"`
+	MaxAttempts          int      `yaml:"max_attempts"`
+	Backoff              BackoffYamlConfig
+	PerTryTimeout        time.Duration `yaml:"per_try_timeout"`
+	RetryableMethods     []string      `yaml:"retryable_methods"`
+	IdempotencyKeyHeader string        `yaml:"idempotency_key_header"`
+}
+type BackoffYamlConfig struct {
+	Base time.Duration
+	Max  time.Duration
+}
+type RetryBudgetYamlConfig struct {
+	RetryPercent        int `yaml:"retry_percent"`
+	MinRetriesPerSecond int `yaml:"min_retries_per_second"`
+	Window              time.Duration
+}
+type HedgingYamlConfig struct {
+	Delay           time.Duration
+	DelayPercentile int `yaml:"delay_percentile"`
+	BudgetPercent   int `yaml:"budget_percent"`
+}
+type MirrorYamlConfig struct {
+	Hosts   []HostYamlConfig `yaml:",flow"`
+	Percent int
+	Header  string
+	Timeout time.Duration
+	Diff    *ShadowDiffYamlConfig
+}
+type ShadowDiffYamlConfig struct {
+	Headers       []string `yaml:",flow"`
+	IgnoredFields []string `yaml:"ignored_fields,flow"`
+	LogPercent    int      `yaml:"log_percent"`
+}
+type PoolYamlConfig struct {
+	Name         string
+	Hosts        []HostYamlConfig  `yaml:",flow"`
+	LoadBalancer string            `yaml:"load_balancer"`
+	HashKey      HashKeyYamlConfig `yaml:"hash_key"`
+	DecayTime    time.Duration     `yaml:"decay_time"`
+}
+type RouteYamlConfig struct {
+	Name     string
+	Match    RouteMatchYamlConfig
+	Pool     string
+	Split    *SplitYamlConfig
+	Rewrite  *RewriteYamlConfig
+	Redirect *RedirectYamlConfig
+	Response *DirectResponseYamlConfig
+	Headers  *HeaderPolicyYamlConfig
+}
+type HeaderPolicyYamlConfig struct {
+	Request  *HeaderRulesYamlConfig
+	Response *HeaderRulesYamlConfig
+}
+type HeaderRulesYamlConfig struct {
+	Remove []string `yaml:",flow"`
+	Set    map[string]string
+	Add    map[string]string
+}
+type RedirectYamlConfig struct {
+	Status int
+	Target string
+}
+type DirectResponseYamlConfig struct {
+	Status   int
+	Headers  map[string]string
+	Body     string
+	BodyFile string `yaml:"body_file"`
+}
+type RewriteYamlConfig struct {
+	StripPrefix string `yaml:"strip_prefix"`
+	Regex       string
+	Replacement string
+	AddPrefix   string `yaml:"add_prefix"`
+}
+type SplitYamlConfig struct {
+	Pools  []WeightedPoolYamlConfig `yaml:",flow"`
+	Sticky *HashKeyYamlConfig
+}
+type WeightedPoolYamlConfig struct {
+	Pool   string
+	Weight int
+}
+type RouteMatchYamlConfig struct {
+	Path    string
+	Prefix  string
+	Regex   string
+	Headers []ValueMatchYamlConfig `yaml:",flow"`
+	Query   []ValueMatchYamlConfig `yaml:",flow"`
+	Cookies []ValueMatchYamlConfig `yaml:",flow"`
+}
+type ValueMatchYamlConfig struct {
+	Name  string
+	Value string
+	Regex string
 }
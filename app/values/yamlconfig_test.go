@@ -1,8 +1,14 @@
 package values_test
 
 import (
+	"fmt"
 	"go-reverse-proxy/app/values"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -11,7 +17,7 @@ func TestToConfiguration(t *testing.T) {
 
 	yamlConfig := &values.YamlConfig{
 		Proxy: values.ProxyYamlConfig{
-			Listen: values.HostYamlConfig{
+			Listen: values.ListenYamlConfig{
 				Address: "127.0.0.1",
 				Port:    5000,
 			},
@@ -49,15 +55,18 @@ func TestToConfiguration(t *testing.T) {
 					{
 						Address: "127.0.0.2",
 						Port:    5001,
+						Weight:  1,
 					},
 					{
 						Address: "127.0.0.3",
 						Port:    5002,
+						Weight:  1,
 					},
 				},
-				NextHostIndex: 0,
+				LoadBalancer: values.RoundRobin,
 			},
 		},
+		PathPrefix:        values.DefaultPathPrefix,
 		MaxForwardRetries: 0,
 	}
 
@@ -65,11 +74,66 @@ func TestToConfiguration(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestToConfigurationPathPrefix(t *testing.T) {
+	testCases := []struct {
+		name       string
+		pathPrefix string
+		expected   string
+	}{
+		{
+			name:       "legacy prefix by default",
+			pathPrefix: "",
+			expected:   "/proxy/",
+		},
+		{
+			name:       "transparent",
+			pathPrefix: "/",
+			expected:   "/",
+		},
+		{
+			name:       "custom prefix",
+			pathPrefix: "/gateway/",
+			expected:   "/gateway/",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address:    "127.0.0.1",
+						Port:       5000,
+						PathPrefix: tc.pathPrefix,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:   "service",
+							Domain: "service.com",
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.PathPrefix)
+		})
+	}
+}
+
 func TestToConfigurationNoServices(t *testing.T) {
 
 	yamlConfig := &values.YamlConfig{
 		Proxy: values.ProxyYamlConfig{
-			Listen: values.HostYamlConfig{
+			Listen: values.ListenYamlConfig{
 				Address: "127.0.0.1",
 				Port:    5000,
 			},
@@ -87,7 +151,7 @@ func TestToConfigurationNoHostAddress(t *testing.T) {
 
 	yamlConfig := &values.YamlConfig{
 		Proxy: values.ProxyYamlConfig{
-			Listen: values.HostYamlConfig{
+			Listen: values.ListenYamlConfig{
 				Address: "",
 				Port:    5000,
 			},
@@ -115,3 +179,2454 @@ func TestToConfigurationNoHostAddress(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Nil(t, configuration)
 }
+
+func TestToConfigurationWeightedRoundRobin(t *testing.T) {
+
+	yamlConfig := &values.YamlConfig{
+		Proxy: values.ProxyYamlConfig{
+			Listen: values.ListenYamlConfig{
+				Address: "127.0.0.1",
+				Port:    5000,
+			},
+			Services: []values.ServiceYamlConfig{
+				{
+					Name:         "service",
+					Domain:       "service.com",
+					LoadBalancer: values.WeightedRoundRobin,
+					Hosts: []values.HostYamlConfig{
+						{
+							Address: "127.0.0.2",
+							Port:    5001,
+							Weight:  3,
+						},
+						{
+							Address: "127.0.0.3",
+							Port:    5002,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	configuration, err := yamlConfig.ToConfiguration()
+
+	assert.Nil(t, err)
+	service := configuration.Services["service.com"]
+	assert.Equal(t, values.WeightedRoundRobin, service.LoadBalancer)
+	assert.Equal(t, int32(3), service.Hosts[0].Weight)
+	assert.Equal(t, int32(1), service.Hosts[1].Weight)
+}
+
+func TestToConfigurationUnknownLoadBalancer(t *testing.T) {
+
+	yamlConfig := &values.YamlConfig{
+		Proxy: values.ProxyYamlConfig{
+			Listen: values.ListenYamlConfig{
+				Address: "127.0.0.1",
+				Port:    5000,
+			},
+			Services: []values.ServiceYamlConfig{
+				{
+					Name:         "service",
+					Domain:       "service.com",
+					LoadBalancer: "random",
+					Hosts: []values.HostYamlConfig{
+						{
+							Address: "127.0.0.2",
+							Port:    5001,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	configuration, err := yamlConfig.ToConfiguration()
+
+	assert.NotNil(t, err)
+	assert.Nil(t, configuration)
+}
+
+func TestToConfigurationNegativeWeight(t *testing.T) {
+
+	yamlConfig := &values.YamlConfig{
+		Proxy: values.ProxyYamlConfig{
+			Listen: values.ListenYamlConfig{
+				Address: "127.0.0.1",
+				Port:    5000,
+			},
+			Services: []values.ServiceYamlConfig{
+				{
+					Name:         "service",
+					Domain:       "service.com",
+					LoadBalancer: values.WeightedRoundRobin,
+					Hosts: []values.HostYamlConfig{
+						{
+							Address: "127.0.0.2",
+							Port:    5001,
+							Weight:  -1,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	configuration, err := yamlConfig.ToConfiguration()
+
+	assert.NotNil(t, err)
+	assert.Nil(t, configuration)
+}
+
+func TestToConfigurationWeightAboveMaximum(t *testing.T) {
+	testCases := []struct {
+		name   string
+		weight int32
+		valid  bool
+	}{
+		{
+			name:   "maximum weight",
+			weight: 1000,
+			valid:  true,
+		},
+		{
+			name:   "weight above the maximum",
+			weight: 1001,
+			valid:  false,
+		},
+		{
+			name:   "huge weight",
+			weight: 2000000000,
+			valid:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:         "service",
+							Domain:       "service.com",
+							LoadBalancer: values.WeightedRoundRobin,
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+									Weight:  tc.weight,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if tc.valid {
+				assert.Nil(t, err)
+				assert.Equal(t, tc.weight, configuration.Services["service.com"].Hosts[0].Weight)
+			} else {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+			}
+		})
+	}
+}
+
+func TestToConfigurationConsistentHash(t *testing.T) {
+	testCases := []struct {
+		name     string
+		hashKey  values.HashKeyYamlConfig
+		expected values.HashKey
+		valid    bool
+	}{
+		{
+			name:     "defaults to client ip",
+			hashKey:  values.HashKeyYamlConfig{},
+			expected: values.HashKey{Source: values.HashKeyClientIP},
+			valid:    true,
+		},
+		{
+			name:     "header",
+			hashKey:  values.HashKeyYamlConfig{Source: values.HashKeyHeader, Name: "X-User-Id"},
+			expected: values.HashKey{Source: values.HashKeyHeader, Name: "X-User-Id"},
+			valid:    true,
+		},
+		{
+			name:    "cookie without name",
+			hashKey: values.HashKeyYamlConfig{Source: values.HashKeyCookie},
+			valid:   false,
+		},
+		{
+			name:    "unknown source",
+			hashKey: values.HashKeyYamlConfig{Source: "body", Name: "user"},
+			valid:   false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:         "service",
+							Domain:       "service.com",
+							LoadBalancer: values.ConsistentHash,
+							HashKey:      tc.hashKey,
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.Services["service.com"].HashKey)
+		})
+	}
+}
+
+func TestToConfigurationPeakEWMA(t *testing.T) {
+	testCases := []struct {
+		name      string
+		decayTime time.Duration
+		expected  time.Duration
+		valid     bool
+	}{
+		{
+			name:      "default decay time",
+			decayTime: 0,
+			expected:  values.DefaultDecayTime,
+			valid:     true,
+		},
+		{
+			name:      "configured decay time",
+			decayTime: 30 * time.Second,
+			expected:  30 * time.Second,
+			valid:     true,
+		},
+		{
+			name:      "negative decay time",
+			decayTime: -time.Second,
+			valid:     false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:         "service",
+							Domain:       "service.com",
+							LoadBalancer: values.PeakEWMA,
+							DecayTime:    tc.decayTime,
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.Services["service.com"].DecayTime)
+		})
+	}
+}
+
+func TestToConfigurationHealthCheck(t *testing.T) {
+	testCases := []struct {
+		name        string
+		healthCheck *values.HealthCheckYamlConfig
+		expected    *values.HealthCheck
+		valid       bool
+	}{
+		{
+			name:        "disabled",
+			healthCheck: nil,
+			expected:    nil,
+			valid:       true,
+		},
+		{
+			name:        "defaults",
+			healthCheck: &values.HealthCheckYamlConfig{},
+			expected: &values.HealthCheck{
+				Path:               "/",
+				Interval:           10 * time.Second,
+				Timeout:            2 * time.Second,
+				ExpectedStatusMin:  200,
+				ExpectedStatusMax:  299,
+				HealthyThreshold:   2,
+				UnhealthyThreshold: 3,
+			},
+			valid: true,
+		},
+		{
+			name: "configured",
+			healthCheck: &values.HealthCheckYamlConfig{
+				Path:               "health",
+				Interval:           5 * time.Second,
+				Timeout:            time.Second,
+				ExpectedStatus:     "200-399",
+				HealthyThreshold:   1,
+				UnhealthyThreshold: 5,
+			},
+			expected: &values.HealthCheck{
+				Path:               "/health",
+				Interval:           5 * time.Second,
+				Timeout:            time.Second,
+				ExpectedStatusMin:  200,
+				ExpectedStatusMax:  399,
+				HealthyThreshold:   1,
+				UnhealthyThreshold: 5,
+			},
+			valid: true,
+		},
+		{
+			name: "single expected status",
+			healthCheck: &values.HealthCheckYamlConfig{
+				Path:           "/health",
+				ExpectedStatus: "204",
+			},
+			expected: &values.HealthCheck{
+				Path:               "/health",
+				Interval:           10 * time.Second,
+				Timeout:            2 * time.Second,
+				ExpectedStatusMin:  204,
+				ExpectedStatusMax:  204,
+				HealthyThreshold:   2,
+				UnhealthyThreshold: 3,
+			},
+			valid: true,
+		},
+		{
+			name: "invalid expected status",
+			healthCheck: &values.HealthCheckYamlConfig{
+				ExpectedStatus: "399-200",
+			},
+			valid: false,
+		},
+		{
+			name: "negative interval",
+			healthCheck: &values.HealthCheckYamlConfig{
+				Interval: -time.Second,
+			},
+			valid: false,
+		},
+		{
+			name: "interval below the default timeout",
+			healthCheck: &values.HealthCheckYamlConfig{
+				Interval: time.Second,
+			},
+			expected: &values.HealthCheck{
+				Path:               "/",
+				Interval:           time.Second,
+				Timeout:            time.Second,
+				ExpectedStatusMin:  200,
+				ExpectedStatusMax:  299,
+				HealthyThreshold:   2,
+				UnhealthyThreshold: 3,
+			},
+			valid: true,
+		},
+		{
+			name: "minimum timeout",
+			healthCheck: &values.HealthCheckYamlConfig{
+				Interval: 10 * time.Millisecond,
+				Timeout:  10 * time.Millisecond,
+			},
+			expected: &values.HealthCheck{
+				Path:               "/",
+				Interval:           10 * time.Millisecond,
+				Timeout:            10 * time.Millisecond,
+				ExpectedStatusMin:  200,
+				ExpectedStatusMax:  299,
+				HealthyThreshold:   2,
+				UnhealthyThreshold: 3,
+			},
+			valid: true,
+		},
+		{
+			name: "interval without a unit",
+			healthCheck: &values.HealthCheckYamlConfig{
+				Interval: 10,
+			},
+			valid: false,
+		},
+		{
+			name: "timeout without a unit",
+			healthCheck: &values.HealthCheckYamlConfig{
+				Timeout: 1,
+			},
+			valid: false,
+		},
+		{
+			name: "interval shorter than the timeout",
+			healthCheck: &values.HealthCheckYamlConfig{
+				Interval: time.Second,
+				Timeout:  2 * time.Second,
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:        "service",
+							Domain:      "service.com",
+							HealthCheck: tc.healthCheck,
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.Services["service.com"].HealthCheck)
+		})
+	}
+}
+
+func TestToConfigurationOutlierDetection(t *testing.T) {
+	testCases := []struct {
+		name             string
+		outlierDetection *values.OutlierDetectionYamlConfig
+		expected         *values.OutlierDetection
+		valid            bool
+	}{
+		{
+			name:             "disabled",
+			outlierDetection: nil,
+			expected:         nil,
+			valid:            true,
+		},
+		{
+			name:             "defaults",
+			outlierDetection: &values.OutlierDetectionYamlConfig{},
+			expected: &values.OutlierDetection{
+				ConsecutiveErrors:  5,
+				BaseEjectionTime:   30 * time.Second,
+				MaxEjectionTime:    300 * time.Second,
+				MaxEjectionPercent: 10,
+			},
+			valid: true,
+		},
+		{
+			name: "configured",
+			outlierDetection: &values.OutlierDetectionYamlConfig{
+				ConsecutiveErrors:  3,
+				BaseEjectionTime:   10 * time.Second,
+				MaxEjectionTime:    time.Minute,
+				MaxEjectionPercent: 50,
+			},
+			expected: &values.OutlierDetection{
+				ConsecutiveErrors:  3,
+				BaseEjectionTime:   10 * time.Second,
+				MaxEjectionTime:    time.Minute,
+				MaxEjectionPercent: 50,
+			},
+			valid: true,
+		},
+		{
+			name: "max ejection time below base",
+			outlierDetection: &values.OutlierDetectionYamlConfig{
+				BaseEjectionTime: time.Minute,
+				MaxEjectionTime:  time.Second,
+			},
+			valid: false,
+		},
+		{
+			name: "max ejection percent above 100",
+			outlierDetection: &values.OutlierDetectionYamlConfig{
+				MaxEjectionPercent: 150,
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:             "service",
+							Domain:           "service.com",
+							OutlierDetection: tc.outlierDetection,
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.Services["service.com"].OutlierDetection)
+		})
+	}
+}
+
+func TestToConfigurationCircuitBreaker(t *testing.T) {
+	testCases := []struct {
+		name           string
+		circuitBreaker *values.CircuitBreakerYamlConfig
+		expected       *values.CircuitBreaker
+		valid          bool
+	}{
+		{
+			name:           "disabled",
+			circuitBreaker: nil,
+			expected:       nil,
+			valid:          true,
+		},
+		{
+			name:           "defaults",
+			circuitBreaker: &values.CircuitBreakerYamlConfig{},
+			expected: &values.CircuitBreaker{
+				FailureRateThreshold: 50,
+				MinimumRequests:      20,
+				Window:               10 * time.Second,
+				OpenTime:             30 * time.Second,
+				HalfOpenRequests:     3,
+			},
+			valid: true,
+		},
+		{
+			name: "configured",
+			circuitBreaker: &values.CircuitBreakerYamlConfig{
+				FailureRateThreshold: 25,
+				MinimumRequests:      100,
+				Window:               time.Minute,
+				OpenTime:             5 * time.Second,
+				HalfOpenRequests:     1,
+			},
+			expected: &values.CircuitBreaker{
+				FailureRateThreshold: 25,
+				MinimumRequests:      100,
+				Window:               time.Minute,
+				OpenTime:             5 * time.Second,
+				HalfOpenRequests:     1,
+			},
+			valid: true,
+		},
+		{
+			name: "negative open time",
+			circuitBreaker: &values.CircuitBreakerYamlConfig{
+				OpenTime: -time.Second,
+			},
+			valid: false,
+		},
+		{
+			name: "failure rate threshold above 100",
+			circuitBreaker: &values.CircuitBreakerYamlConfig{
+				FailureRateThreshold: 101,
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:           "service",
+							Domain:         "service.com",
+							CircuitBreaker: tc.circuitBreaker,
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.Services["service.com"].CircuitBreaker)
+		})
+	}
+}
+
+func TestToConfigurationRetry(t *testing.T) {
+	testCases := []struct {
+		name     string
+		retry    *values.RetryYamlConfig
+		expected *values.RetryPolicy
+		valid    bool
+	}{
+		{
+			name:     "global policy",
+			retry:    nil,
+			expected: nil,
+			valid:    true,
+		},
+		{
+			name:  "defaults",
+			retry: &values.RetryYamlConfig{},
+			expected: &values.RetryPolicy{
+				RetryableStatusCodes:  []int{500, 502, 503, 504},
+				RetryOnConnectFailure: true,
+				RetryOnTimeout:        true,
+				RetryOnReset:          true,
+				MaxAttempts:           3,
+				BackoffBase:           25 * time.Millisecond,
+				BackoffMax:            250 * time.Millisecond,
+				RetryableMethods:      values.DefaultRetryableMethods,
+				IdempotencyKeyHeader:  "Idempotency-Key",
+			},
+			valid: true,
+		},
+		{
+			name: "configured",
+			retry: &values.RetryYamlConfig{
+				RetryableStatusCodes: []int{503},
+				RetryOn:              []string{"connect_failure"},
+				MaxAttempts:          2,
+				Backoff: values.BackoffYamlConfig{
+					Base: 10 * time.Millisecond,
+					Max:  time.Second,
+				},
+				PerTryTimeout:        500 * time.Millisecond,
+				RetryableMethods:     []string{"get", "post"},
+				IdempotencyKeyHeader: "X-Request-Id",
+			},
+			expected: &values.RetryPolicy{
+				RetryableStatusCodes:  []int{503},
+				RetryOnConnectFailure: true,
+				MaxAttempts:           2,
+				BackoffBase:           10 * time.Millisecond,
+				BackoffMax:            time.Second,
+				PerTryTimeout:         500 * time.Millisecond,
+				RetryableMethods:      []string{"GET", "POST"},
+				IdempotencyKeyHeader:  "X-Request-Id",
+			},
+			valid: true,
+		},
+		{
+			name: "no failures retried",
+			retry: &values.RetryYamlConfig{
+				RetryableStatusCodes: []int{},
+				RetryOn:              []string{},
+			},
+			expected: &values.RetryPolicy{
+				RetryableStatusCodes: []int{},
+				MaxAttempts:          3,
+				BackoffBase:          25 * time.Millisecond,
+				BackoffMax:           250 * time.Millisecond,
+				RetryableMethods:     values.DefaultRetryableMethods,
+				IdempotencyKeyHeader: "Idempotency-Key",
+			},
+			valid: true,
+		},
+		{
+			name: "unknown retry reason",
+			retry: &values.RetryYamlConfig{
+				RetryOn: []string{"always"},
+			},
+			valid: false,
+		},
+		{
+			name: "bad status code",
+			retry: &values.RetryYamlConfig{
+				RetryableStatusCodes: []int{1000},
+			},
+			valid: false,
+		},
+		{
+			name: "base backoff above the default max",
+			retry: &values.RetryYamlConfig{
+				Backoff: values.BackoffYamlConfig{
+					Base: time.Second,
+				},
+			},
+			expected: &values.RetryPolicy{
+				RetryableStatusCodes:  []int{500, 502, 503, 504},
+				RetryOnConnectFailure: true,
+				RetryOnTimeout:        true,
+				RetryOnReset:          true,
+				MaxAttempts:           3,
+				BackoffBase:           time.Second,
+				BackoffMax:            time.Second,
+				RetryableMethods:      values.DefaultRetryableMethods,
+				IdempotencyKeyHeader:  "Idempotency-Key",
+			},
+			valid: true,
+		},
+		{
+			name: "max backoff below base",
+			retry: &values.RetryYamlConfig{
+				Backoff: values.BackoffYamlConfig{
+					Base: time.Second,
+					Max:  time.Millisecond,
+				},
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:   "service",
+							Domain: "service.com",
+							Retry:  tc.retry,
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.Services["service.com"].Retry)
+		})
+	}
+}
+
+func TestToConfigurationRetryBudget(t *testing.T) {
+	testCases := []struct {
+		name        string
+		retryBudget *values.RetryBudgetYamlConfig
+		expected    *values.RetryBudget
+		valid       bool
+	}{
+		{
+			name:        "unlimited",
+			retryBudget: nil,
+			expected:    nil,
+			valid:       true,
+		},
+		{
+			name:        "defaults",
+			retryBudget: &values.RetryBudgetYamlConfig{},
+			expected: &values.RetryBudget{
+				RetryPercent:        20,
+				MinRetriesPerSecond: 10,
+				Window:              10 * time.Second,
+			},
+			valid: true,
+		},
+		{
+			name: "configured",
+			retryBudget: &values.RetryBudgetYamlConfig{
+				RetryPercent:        5,
+				MinRetriesPerSecond: 1,
+				Window:              time.Minute,
+			},
+			expected: &values.RetryBudget{
+				RetryPercent:        5,
+				MinRetriesPerSecond: 1,
+				Window:              time.Minute,
+			},
+			valid: true,
+		},
+		{
+			name: "negative retry percent",
+			retryBudget: &values.RetryBudgetYamlConfig{
+				RetryPercent: -1,
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:        "service",
+							Domain:      "service.com",
+							RetryBudget: tc.retryBudget,
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.Services["service.com"].RetryBudget)
+		})
+	}
+}
+
+func TestToConfigurationHedging(t *testing.T) {
+	testCases := []struct {
+		name     string
+		hedging  *values.HedgingYamlConfig
+		expected *values.Hedging
+		valid    bool
+	}{
+		{
+			name:     "disabled",
+			hedging:  nil,
+			expected: nil,
+			valid:    true,
+		},
+		{
+			name:    "defaults",
+			hedging: &values.HedgingYamlConfig{},
+			expected: &values.Hedging{
+				Delay:         50 * time.Millisecond,
+				BudgetPercent: 10,
+			},
+			valid: true,
+		},
+		{
+			name: "percentile delay",
+			hedging: &values.HedgingYamlConfig{
+				Delay:           100 * time.Millisecond,
+				DelayPercentile: 95,
+				BudgetPercent:   5,
+			},
+			expected: &values.Hedging{
+				Delay:           100 * time.Millisecond,
+				DelayPercentile: 95,
+				BudgetPercent:   5,
+			},
+			valid: true,
+		},
+		{
+			name: "percentile above 100",
+			hedging: &values.HedgingYamlConfig{
+				DelayPercentile: 101,
+			},
+			valid: false,
+		},
+		{
+			name: "negative delay",
+			hedging: &values.HedgingYamlConfig{
+				Delay: -time.Millisecond,
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:    "service",
+							Domain:  "service.com",
+							Hedging: tc.hedging,
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.Services["service.com"].Hedging)
+		})
+	}
+}
+
+func TestToConfigurationPoolLoadBalancer(t *testing.T) {
+	testCases := []struct {
+		name      string
+		service   values.ServiceYamlConfig
+		pool      values.PoolYamlConfig
+		hashKey   values.HashKey
+		decayTime time.Duration
+		valid     bool
+	}{
+		{
+			name: "inherits the hash key of the service",
+			service: values.ServiceYamlConfig{
+				LoadBalancer: values.ConsistentHash,
+				HashKey:      values.HashKeyYamlConfig{Source: values.HashKeyHeader, Name: "X-User"},
+			},
+			pool:    values.PoolYamlConfig{},
+			hashKey: values.HashKey{Source: values.HashKeyHeader, Name: "X-User"},
+			valid:   true,
+		},
+		{
+			name: "own hash key with the load balancer of the service",
+			service: values.ServiceYamlConfig{
+				LoadBalancer: values.ConsistentHash,
+				HashKey:      values.HashKeyYamlConfig{Source: values.HashKeyHeader, Name: "X-User"},
+			},
+			pool: values.PoolYamlConfig{
+				HashKey: values.HashKeyYamlConfig{Source: values.HashKeyCookie, Name: "session"},
+			},
+			hashKey: values.HashKey{Source: values.HashKeyCookie, Name: "session"},
+			valid:   true,
+		},
+		{
+			name: "own hash key with the same load balancer as the service",
+			service: values.ServiceYamlConfig{
+				LoadBalancer: values.ConsistentHash,
+			},
+			pool: values.PoolYamlConfig{
+				LoadBalancer: values.ConsistentHash,
+				HashKey:      values.HashKeyYamlConfig{Source: values.HashKeyCookie, Name: "session"},
+			},
+			hashKey: values.HashKey{Source: values.HashKeyCookie, Name: "session"},
+			valid:   true,
+		},
+		{
+			name: "invalid hash key",
+			service: values.ServiceYamlConfig{
+				LoadBalancer: values.ConsistentHash,
+			},
+			pool: values.PoolYamlConfig{
+				HashKey: values.HashKeyYamlConfig{Source: values.HashKeyHeader},
+			},
+			valid: false,
+		},
+		{
+			name: "inherits the decay time of the service",
+			service: values.ServiceYamlConfig{
+				LoadBalancer: values.PeakEWMA,
+				DecayTime:    20 * time.Second,
+			},
+			pool:      values.PoolYamlConfig{},
+			decayTime: 20 * time.Second,
+			valid:     true,
+		},
+		{
+			name: "own decay time with the load balancer of the service",
+			service: values.ServiceYamlConfig{
+				LoadBalancer: values.PeakEWMA,
+				DecayTime:    20 * time.Second,
+			},
+			pool: values.PoolYamlConfig{
+				DecayTime: 5 * time.Second,
+			},
+			decayTime: 5 * time.Second,
+			valid:     true,
+		},
+		{
+			name:    "own decay time with a different load balancer",
+			service: values.ServiceYamlConfig{},
+			pool: values.PoolYamlConfig{
+				LoadBalancer: values.PeakEWMA,
+				DecayTime:    30 * time.Second,
+			},
+			decayTime: 30 * time.Second,
+			valid:     true,
+		},
+		{
+			name:    "default decay time with a different load balancer",
+			service: values.ServiceYamlConfig{},
+			pool: values.PoolYamlConfig{
+				LoadBalancer: values.PeakEWMA,
+			},
+			decayTime: values.DefaultDecayTime,
+			valid:     true,
+		},
+		{
+			name: "negative decay time",
+			service: values.ServiceYamlConfig{
+				LoadBalancer: values.PeakEWMA,
+			},
+			pool: values.PoolYamlConfig{
+				DecayTime: -time.Second,
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := tc.service
+			service.Name = "service"
+			service.Domain = "service.com"
+			service.Hosts = []values.HostYamlConfig{
+				{
+					Address: "127.0.0.2",
+					Port:    5001,
+				},
+			}
+
+			pool := tc.pool
+			pool.Name = "v1"
+			pool.Hosts = []values.HostYamlConfig{
+				{
+					Address: "127.0.0.3",
+					Port:    5001,
+				},
+			}
+			service.Pools = []values.PoolYamlConfig{pool}
+
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{service},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+
+			parsed := configuration.Services["service.com"].Pools[0]
+			assert.Equal(t, tc.hashKey, parsed.HashKey)
+			assert.Equal(t, tc.decayTime, parsed.DecayTime)
+		})
+	}
+}
+
+func TestToConfigurationRoutes(t *testing.T) {
+	pools := []values.PoolYamlConfig{
+		{
+			Name: "v1",
+			Hosts: []values.HostYamlConfig{
+				{
+					Address: "127.0.0.3",
+					Port:    5001,
+				},
+			},
+		},
+		{
+			Name:         "v2",
+			LoadBalancer: values.LeastOutstandingRequests,
+			Hosts: []values.HostYamlConfig{
+				{
+					Address: "127.0.0.4",
+					Port:    5001,
+					Weight:  2,
+				},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name   string
+		pools  []values.PoolYamlConfig
+		routes []values.RouteYamlConfig
+		valid  bool
+	}{
+		{
+			name:  "prefix, path and regex routes",
+			pools: pools,
+			routes: []values.RouteYamlConfig{
+				{
+					Match: values.RouteMatchYamlConfig{Prefix: "/api/v2/"},
+					Pool:  "v2",
+				},
+				{
+					Name:  "health",
+					Match: values.RouteMatchYamlConfig{Path: "/health"},
+					Pool:  "v1",
+				},
+				{
+					Match: values.RouteMatchYamlConfig{Regex: "^/api/v1/"},
+					Pool:  "v1",
+				},
+			},
+			valid: true,
+		},
+		{
+			name:  "unknown pool",
+			pools: pools,
+			routes: []values.RouteYamlConfig{
+				{
+					Match: values.RouteMatchYamlConfig{Prefix: "/api/v3/"},
+					Pool:  "v3",
+				},
+			},
+			valid: false,
+		},
+		{
+			name:  "route without matcher",
+			pools: pools,
+			routes: []values.RouteYamlConfig{
+				{
+					Pool: "v1",
+				},
+			},
+			valid: false,
+		},
+		{
+			name:  "route with two matchers",
+			pools: pools,
+			routes: []values.RouteYamlConfig{
+				{
+					Match: values.RouteMatchYamlConfig{Path: "/health", Prefix: "/api/"},
+					Pool:  "v1",
+				},
+			},
+			valid: false,
+		},
+		{
+			name:  "invalid regex",
+			pools: pools,
+			routes: []values.RouteYamlConfig{
+				{
+					Match: values.RouteMatchYamlConfig{Regex: "^/api/(v1"},
+					Pool:  "v1",
+				},
+			},
+			valid: false,
+		},
+		{
+			name:  "header matcher without a name",
+			pools: pools,
+			routes: []values.RouteYamlConfig{
+				{
+					Match: values.RouteMatchYamlConfig{
+						Headers: []values.ValueMatchYamlConfig{{Value: "true"}},
+					},
+					Pool: "v1",
+				},
+			},
+			valid: false,
+		},
+		{
+			name:  "query matcher with a value and a regex",
+			pools: pools,
+			routes: []values.RouteYamlConfig{
+				{
+					Match: values.RouteMatchYamlConfig{
+						Query: []values.ValueMatchYamlConfig{{Name: "partner", Value: "acme", Regex: "^acme"}},
+					},
+					Pool: "v1",
+				},
+			},
+			valid: false,
+		},
+		{
+			name:  "invalid cookie regex",
+			pools: pools,
+			routes: []values.RouteYamlConfig{
+				{
+					Match: values.RouteMatchYamlConfig{
+						Cookies: []values.ValueMatchYamlConfig{{Name: "group", Regex: "(beta"}},
+					},
+					Pool: "v1",
+				},
+			},
+			valid: false,
+		},
+		{
+			name:  "duplicated pool",
+			pools: append(pools, values.PoolYamlConfig{Name: "v1"}),
+			valid: false,
+		},
+		{
+			name: "pool with negative weight",
+			pools: []values.PoolYamlConfig{
+				{
+					Name: "v1",
+					Hosts: []values.HostYamlConfig{
+						{
+							Address: "127.0.0.3",
+							Port:    5001,
+							Weight:  -1,
+						},
+					},
+				},
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:   "service",
+							Domain: "service.com",
+							Pools:  tc.pools,
+							Routes: tc.routes,
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+
+			service := configuration.Services["service.com"]
+			assert.Len(t, service.Pools, 2)
+			assert.Len(t, service.Routes, 3)
+			assert.Equal(t, "route_1", service.Routes[0].Name)
+			assert.Equal(t, "health", service.Routes[1].Name)
+
+			v1, v2 := service.Pools[0], service.Pools[1]
+			assert.Equal(t, "service/v1", v1.Name)
+			assert.Equal(t, "127.0.0.3", v1.Hosts[0].Address)
+			assert.Equal(t, service.LoadBalancer, v1.LoadBalancer)
+			assert.Equal(t, "service/v2", v2.Name)
+			assert.Equal(t, int32(2), v2.Hosts[0].Weight)
+			assert.Equal(t, values.LeastOutstandingRequests, v2.LoadBalancer)
+
+			assert.Same(t, v2, service.Route(&values.Request{Endpoint: "api/v2/users"}))
+			assert.Same(t, v1, service.Route(&values.Request{Endpoint: "health"}))
+			assert.Same(t, v1, service.Route(&values.Request{Endpoint: "api/v1/users"}))
+			assert.Same(t, service, service.Route(&values.Request{Endpoint: "api/v3/users"}))
+		})
+	}
+}
+
+func TestToConfigurationDomains(t *testing.T) {
+	testCases := []struct {
+		name           string
+		domains        []string
+		defaultService string
+		expected       []string
+		valid          bool
+	}{
+		{
+			name:     "normalized domains",
+			domains:  []string{"Service.com.", "*.Service.com"},
+			expected: []string{"service.com", "*.service.com"},
+			valid:    true,
+		},
+		{
+			name:           "default service",
+			domains:        []string{"service.com", "other.com"},
+			defaultService: "service-1",
+			expected:       []string{"service.com", "other.com"},
+			valid:          true,
+		},
+		{
+			name:           "unknown default service",
+			domains:        []string{"service.com"},
+			defaultService: "unknown",
+			valid:          false,
+		},
+		{
+			name:    "duplicated domain",
+			domains: []string{"service.com", "SERVICE.com"},
+			valid:   false,
+		},
+		{
+			name:    "wildcard that isn't the leading label",
+			domains: []string{"api.*.service.com"},
+			valid:   false,
+		},
+		{
+			name:    "empty domain",
+			domains: []string{""},
+			valid:   false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var services []values.ServiceYamlConfig
+			for i, domain := range tc.domains {
+				services = append(services, values.ServiceYamlConfig{
+					Name:   fmt.Sprintf("service-%d", i),
+					Domain: domain,
+					Hosts: []values.HostYamlConfig{
+						{
+							Address: "127.0.0.2",
+							Port:    5001,
+						},
+					},
+				})
+			}
+
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services:       services,
+					DefaultService: tc.defaultService,
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+			for i, domain := range tc.expected {
+				assert.Equal(t, fmt.Sprintf("service-%d", i), configuration.Services[domain].Name)
+			}
+
+			if tc.defaultService == "" {
+				assert.Nil(t, configuration.DefaultService)
+			} else {
+				assert.Equal(t, tc.defaultService, configuration.DefaultService.Name)
+			}
+		})
+	}
+}
+
+func TestToConfigurationRouteMatchers(t *testing.T) {
+	yamlConfig := &values.YamlConfig{
+		Proxy: values.ProxyYamlConfig{
+			Listen: values.ListenYamlConfig{
+				Address: "127.0.0.1",
+				Port:    5000,
+			},
+			Services: []values.ServiceYamlConfig{
+				{
+					Name:   "service",
+					Domain: "service.com",
+					Hosts: []values.HostYamlConfig{
+						{
+							Address: "127.0.0.2",
+							Port:    5001,
+						},
+					},
+					Pools: []values.PoolYamlConfig{
+						{
+							Name: "canary",
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.3",
+									Port:    5001,
+								},
+							},
+						},
+					},
+					Routes: []values.RouteYamlConfig{
+						{
+							Match: values.RouteMatchYamlConfig{
+								Prefix:  "/api/",
+								Headers: []values.ValueMatchYamlConfig{{Name: "X-Canary", Value: "true"}},
+								Query:   []values.ValueMatchYamlConfig{{Name: "partner", Regex: "^acme-"}},
+								Cookies: []values.ValueMatchYamlConfig{{Name: "group"}},
+							},
+							Pool: "canary",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	configuration, err := yamlConfig.ToConfiguration()
+
+	assert.Nil(t, err)
+
+	match := configuration.Services["service.com"].Routes[0].Match
+	assert.Equal(t, "/api/", match.Prefix)
+	assert.Equal(t, []values.ValueMatch{{Name: "X-Canary", Value: "true"}}, match.Headers)
+	assert.Equal(t, "partner", match.Query[0].Name)
+	assert.Equal(t, "^acme-", match.Query[0].Regex.String())
+	assert.Equal(t, []values.ValueMatch{{Name: "group"}}, match.Cookies)
+}
+
+func TestToConfigurationSplit(t *testing.T) {
+	pools := []values.PoolYamlConfig{
+		{
+			Name: "v1",
+			Hosts: []values.HostYamlConfig{
+				{
+					Address: "127.0.0.3",
+					Port:    5001,
+				},
+			},
+		},
+		{
+			Name: "v2",
+			Hosts: []values.HostYamlConfig{
+				{
+					Address: "127.0.0.4",
+					Port:    5001,
+				},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name   string
+		split  *values.SplitYamlConfig
+		routes []values.RouteYamlConfig
+		sticky *values.HashKey
+		valid  bool
+	}{
+		{
+			name: "service split",
+			split: &values.SplitYamlConfig{
+				Pools: []values.WeightedPoolYamlConfig{
+					{Pool: "v1", Weight: 99},
+					{Pool: "v2", Weight: 1},
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "sticky by client ip by default",
+			split: &values.SplitYamlConfig{
+				Pools: []values.WeightedPoolYamlConfig{
+					{Pool: "v1", Weight: 50},
+					{Pool: "v2", Weight: 50},
+				},
+				Sticky: &values.HashKeyYamlConfig{},
+			},
+			sticky: &values.HashKey{Source: values.HashKeyClientIP},
+			valid:  true,
+		},
+		{
+			name: "sticky by cookie",
+			split: &values.SplitYamlConfig{
+				Pools: []values.WeightedPoolYamlConfig{
+					{Pool: "v1", Weight: 50},
+					{Pool: "v2", Weight: 50},
+				},
+				Sticky: &values.HashKeyYamlConfig{Source: values.HashKeyCookie, Name: "user"},
+			},
+			sticky: &values.HashKey{Source: values.HashKeyCookie, Name: "user"},
+			valid:  true,
+		},
+		{
+			name: "route split",
+			routes: []values.RouteYamlConfig{
+				{
+					Match: values.RouteMatchYamlConfig{Prefix: "/api/"},
+					Split: &values.SplitYamlConfig{
+						Pools: []values.WeightedPoolYamlConfig{
+							{Pool: "v1", Weight: 90},
+							{Pool: "v2", Weight: 10},
+						},
+					},
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "route with a pool and a split",
+			routes: []values.RouteYamlConfig{
+				{
+					Match: values.RouteMatchYamlConfig{Prefix: "/api/"},
+					Pool:  "v1",
+					Split: &values.SplitYamlConfig{
+						Pools: []values.WeightedPoolYamlConfig{
+							{Pool: "v1", Weight: 100},
+						},
+					},
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "weights not adding up to 100",
+			split: &values.SplitYamlConfig{
+				Pools: []values.WeightedPoolYamlConfig{
+					{Pool: "v1", Weight: 90},
+					{Pool: "v2", Weight: 1},
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "negative weight",
+			split: &values.SplitYamlConfig{
+				Pools: []values.WeightedPoolYamlConfig{
+					{Pool: "v1", Weight: 110},
+					{Pool: "v2", Weight: -10},
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "unknown pool",
+			split: &values.SplitYamlConfig{
+				Pools: []values.WeightedPoolYamlConfig{
+					{Pool: "v3", Weight: 100},
+				},
+			},
+			valid: false,
+		},
+		{
+			name:  "split without pools",
+			split: &values.SplitYamlConfig{},
+			valid: false,
+		},
+		{
+			name: "sticky key without a name",
+			split: &values.SplitYamlConfig{
+				Pools: []values.WeightedPoolYamlConfig{
+					{Pool: "v1", Weight: 100},
+				},
+				Sticky: &values.HashKeyYamlConfig{Source: values.HashKeyHeader},
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:   "service",
+							Domain: "service.com",
+							Pools:  pools,
+							Routes: tc.routes,
+							Split:  tc.split,
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+
+			service := configuration.Services["service.com"]
+			split := service.Split
+			if tc.split == nil {
+				assert.Nil(t, split)
+				split = service.Routes[0].Split
+			}
+
+			assert.Len(t, split.Pools, 2)
+			assert.Same(t, service.Pools[0], split.Pools[0].Pool)
+			assert.Same(t, service.Pools[1], split.Pools[1].Pool)
+			assert.Equal(t, tc.sticky, split.Sticky)
+		})
+	}
+}
+
+func TestToConfigurationMirror(t *testing.T) {
+	hosts := []values.HostYamlConfig{
+		{
+			Address: "127.0.0.3",
+			Port:    5001,
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		mirror   *values.MirrorYamlConfig
+		expected *values.Mirror
+		valid    bool
+	}{
+		{
+			name:     "disabled",
+			mirror:   nil,
+			expected: nil,
+			valid:    true,
+		},
+		{
+			name: "defaults",
+			mirror: &values.MirrorYamlConfig{
+				Hosts: hosts,
+			},
+			expected: &values.Mirror{
+				Service: &values.Service{
+					Name:   "service/mirror",
+					Domain: "service.com",
+					Hosts: []*values.Host{
+						{
+							Address: "127.0.0.3",
+							Port:    5001,
+							Weight:  1,
+						},
+					},
+					LoadBalancer: values.RoundRobin,
+				},
+				Percent: 100,
+				Header:  values.DefaultMirrorHeader,
+				Timeout: 5 * time.Second,
+			},
+			valid: true,
+		},
+		{
+			name: "sampled",
+			mirror: &values.MirrorYamlConfig{
+				Hosts:   hosts,
+				Percent: 5,
+				Header:  "X-Shadow",
+				Timeout: time.Second,
+			},
+			expected: &values.Mirror{
+				Service: &values.Service{
+					Name:   "service/mirror",
+					Domain: "service.com",
+					Hosts: []*values.Host{
+						{
+							Address: "127.0.0.3",
+							Port:    5001,
+							Weight:  1,
+						},
+					},
+					LoadBalancer: values.RoundRobin,
+				},
+				Percent: 5,
+				Header:  "X-Shadow",
+				Timeout: time.Second,
+			},
+			valid: true,
+		},
+		{
+			name: "with diff",
+			mirror: &values.MirrorYamlConfig{
+				Hosts: hosts,
+				Diff: &values.ShadowDiffYamlConfig{
+					Headers:       []string{"Content-Type"},
+					IgnoredFields: []string{"meta.request_id"},
+				},
+			},
+			expected: &values.Mirror{
+				Service: &values.Service{
+					Name:   "service/mirror",
+					Domain: "service.com",
+					Hosts: []*values.Host{
+						{
+							Address: "127.0.0.3",
+							Port:    5001,
+							Weight:  1,
+						},
+					},
+					LoadBalancer: values.RoundRobin,
+				},
+				Percent: 100,
+				Header:  values.DefaultMirrorHeader,
+				Timeout: 5 * time.Second,
+				Diff: &values.ShadowDiff{
+					Headers:       []string{"Content-Type"},
+					IgnoredFields: []string{"meta.request_id"},
+					LogPercent:    1,
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "diff with a bad ignored field",
+			mirror: &values.MirrorYamlConfig{
+				Hosts: hosts,
+				Diff: &values.ShadowDiffYamlConfig{
+					IgnoredFields: []string{"meta..request_id"},
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "diff log percent above 100",
+			mirror: &values.MirrorYamlConfig{
+				Hosts: hosts,
+				Diff: &values.ShadowDiffYamlConfig{
+					LogPercent: 101,
+				},
+			},
+			valid: false,
+		},
+		{
+			name:   "without hosts",
+			mirror: &values.MirrorYamlConfig{},
+			valid:  false,
+		},
+		{
+			name: "percent above 100",
+			mirror: &values.MirrorYamlConfig{
+				Hosts:   hosts,
+				Percent: 101,
+			},
+			valid: false,
+		},
+		{
+			name: "negative timeout",
+			mirror: &values.MirrorYamlConfig{
+				Hosts:   hosts,
+				Timeout: -time.Second,
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:   "service",
+							Domain: "service.com",
+							Mirror: tc.mirror,
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.Services["service.com"].Mirror)
+		})
+	}
+}
+
+func TestToConfigurationRewrite(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rewrite  *values.RewriteYamlConfig
+		expected *values.Rewrite
+		valid    bool
+	}{
+		{
+			name:     "no rewrite",
+			rewrite:  nil,
+			expected: nil,
+			valid:    true,
+		},
+		{
+			name: "prefixes",
+			rewrite: &values.RewriteYamlConfig{
+				StripPrefix: "/api/v2",
+				AddPrefix:   "/v2",
+			},
+			expected: &values.Rewrite{
+				StripPrefix: "/api/v2",
+				AddPrefix:   "/v2",
+			},
+			valid: true,
+		},
+		{
+			name: "regex",
+			rewrite: &values.RewriteYamlConfig{
+				Regex:       "^/users/([0-9]+)$",
+				Replacement: "/accounts/$1",
+			},
+			expected: &values.Rewrite{
+				Regex:       regexp.MustCompile("^/users/([0-9]+)$"),
+				Replacement: "/accounts/$1",
+			},
+			valid: true,
+		},
+		{
+			name: "relative prefix",
+			rewrite: &values.RewriteYamlConfig{
+				AddPrefix: "v2",
+			},
+			valid: false,
+		},
+		{
+			name: "replacement without a regex",
+			rewrite: &values.RewriteYamlConfig{
+				Replacement: "/accounts",
+			},
+			valid: false,
+		},
+		{
+			name: "invalid regex",
+			rewrite: &values.RewriteYamlConfig{
+				Regex: "^/users/([0-9]+$",
+			},
+			valid: false,
+		},
+		{
+			name:    "empty rewrite",
+			rewrite: &values.RewriteYamlConfig{},
+			valid:   false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:   "service",
+							Domain: "service.com",
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+							Pools: []values.PoolYamlConfig{
+								{
+									Name: "v2",
+									Hosts: []values.HostYamlConfig{
+										{
+											Address: "127.0.0.3",
+											Port:    5001,
+										},
+									},
+								},
+							},
+							Routes: []values.RouteYamlConfig{
+								{
+									Match:   values.RouteMatchYamlConfig{Prefix: "/api/"},
+									Pool:    "v2",
+									Rewrite: tc.rewrite,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.Services["service.com"].Routes[0].Rewrite)
+		})
+	}
+}
+
+func TestToConfigurationRedirectAndResponse(t *testing.T) {
+	bodyFile, err := ioutil.TempFile("", "maintenance-*.json")
+	assert.Nil(t, err)
+	defer os.Remove(bodyFile.Name())
+
+	_, err = bodyFile.WriteString(`{"status":"maintenance"}`)
+	assert.Nil(t, err)
+	assert.Nil(t, bodyFile.Close())
+
+	testCases := []struct {
+		name     string
+		route    values.RouteYamlConfig
+		expected *values.Route
+		valid    bool
+	}{
+		{
+			name: "redirect",
+			route: values.RouteYamlConfig{
+				Redirect: &values.RedirectYamlConfig{Status: 308, Target: "https://{host}{path}{query}"},
+			},
+			expected: &values.Route{
+				Name:     "route_1",
+				Redirect: &values.Redirect{StatusCode: 308, Target: "https://{host}{path}{query}"},
+			},
+			valid: true,
+		},
+		{
+			name: "permanent redirect by default",
+			route: values.RouteYamlConfig{
+				Redirect: &values.RedirectYamlConfig{Target: "https://{host}{path}{query}"},
+			},
+			expected: &values.Route{
+				Name:     "route_1",
+				Redirect: &values.Redirect{StatusCode: 301, Target: "https://{host}{path}{query}"},
+			},
+			valid: true,
+		},
+		{
+			name: "response with inline body",
+			route: values.RouteYamlConfig{
+				Response: &values.DirectResponseYamlConfig{
+					Status:  503,
+					Headers: map[string]string{"content-type": "application/json"},
+					Body:    `{"status":"maintenance"}`,
+				},
+			},
+			expected: &values.Route{
+				Name: "route_1",
+				Response: &values.DirectResponse{
+					StatusCode: 503,
+					Header:     http.Header{"Content-Type": []string{"application/json"}},
+					Body:       []byte(`{"status":"maintenance"}`),
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "response with body file",
+			route: values.RouteYamlConfig{
+				Response: &values.DirectResponseYamlConfig{BodyFile: bodyFile.Name()},
+			},
+			expected: &values.Route{
+				Name: "route_1",
+				Response: &values.DirectResponse{
+					StatusCode: 200,
+					Header:     http.Header{},
+					Body:       []byte(`{"status":"maintenance"}`),
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "redirect without a target",
+			route: values.RouteYamlConfig{
+				Redirect: &values.RedirectYamlConfig{Status: 301},
+			},
+			valid: false,
+		},
+		{
+			name: "redirect with a status that isn't a redirect",
+			route: values.RouteYamlConfig{
+				Redirect: &values.RedirectYamlConfig{Status: 200, Target: "https://{host}{path}"},
+			},
+			valid: false,
+		},
+		{
+			name: "response with an invalid status",
+			route: values.RouteYamlConfig{
+				Response: &values.DirectResponseYamlConfig{Status: 600},
+			},
+			valid: false,
+		},
+		{
+			name: "response with body and body file",
+			route: values.RouteYamlConfig{
+				Response: &values.DirectResponseYamlConfig{Body: "{}", BodyFile: bodyFile.Name()},
+			},
+			valid: false,
+		},
+		{
+			name: "response with a missing body file",
+			route: values.RouteYamlConfig{
+				Response: &values.DirectResponseYamlConfig{BodyFile: bodyFile.Name() + ".missing"},
+			},
+			valid: false,
+		},
+		{
+			name: "redirect and pool",
+			route: values.RouteYamlConfig{
+				Pool:     "v2",
+				Redirect: &values.RedirectYamlConfig{Target: "https://{host}{path}"},
+			},
+			valid: false,
+		},
+		{
+			name: "redirect and response",
+			route: values.RouteYamlConfig{
+				Redirect: &values.RedirectYamlConfig{Target: "https://{host}{path}"},
+				Response: &values.DirectResponseYamlConfig{},
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			route := tc.route
+			route.Match = values.RouteMatchYamlConfig{Prefix: "/old/"}
+
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:   "service",
+							Domain: "service.com",
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+							Pools: []values.PoolYamlConfig{
+								{
+									Name: "v2",
+									Hosts: []values.HostYamlConfig{
+										{
+											Address: "127.0.0.3",
+											Port:    5001,
+										},
+									},
+								},
+							},
+							Routes: []values.RouteYamlConfig{route},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			tc.expected.Match = values.RouteMatch{Prefix: "/old/"}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.Services["service.com"].Routes[0])
+		})
+	}
+}
+
+func TestToConfigurationHeaders(t *testing.T) {
+	assert.Nil(t, os.Setenv("PROXY_TEST_AUTH_TOKEN", "secret"))
+	defer os.Unsetenv("PROXY_TEST_AUTH_TOKEN")
+
+	testCases := []struct {
+		name     string
+		headers  *values.HeaderPolicyYamlConfig
+		expected *values.HeaderPolicy
+		valid    bool
+	}{
+		{
+			name:     "no headers",
+			headers:  nil,
+			expected: nil,
+			valid:    true,
+		},
+		{
+			name: "request and response",
+			headers: &values.HeaderPolicyYamlConfig{
+				Request: &values.HeaderRulesYamlConfig{
+					Set: map[string]string{
+						"X-Request-Id":    "{request_id}",
+						"X-Internal-Auth": "Bearer {env:PROXY_TEST_AUTH_TOKEN}",
+					},
+					Add: map[string]string{"X-Client-Ip": "{client_ip}"},
+				},
+				Response: &values.HeaderRulesYamlConfig{
+					Remove: []string{"Server", "X-Powered-By"},
+					Set:    map[string]string{"Strict-Transport-Security": "max-age=31536000"},
+				},
+			},
+			expected: &values.HeaderPolicy{
+				Request: &values.HeaderRules{
+					Set: []values.HeaderValue{
+						{Name: "X-Internal-Auth", Value: "Bearer secret"},
+						{Name: "X-Request-Id", Value: "{request_id}"},
+					},
+					Add: []values.HeaderValue{
+						{Name: "X-Client-Ip", Value: "{client_ip}"},
+					},
+				},
+				Response: &values.HeaderRules{
+					Remove: []string{"Server", "X-Powered-By"},
+					Set: []values.HeaderValue{
+						{Name: "Strict-Transport-Security", Value: "max-age=31536000"},
+					},
+				},
+			},
+			valid: true,
+		},
+		{
+			name:     "empty rules",
+			headers:  &values.HeaderPolicyYamlConfig{Request: &values.HeaderRulesYamlConfig{}},
+			expected: nil,
+			valid:    true,
+		},
+		{
+			name: "unknown placeholder",
+			headers: &values.HeaderPolicyYamlConfig{
+				Request: &values.HeaderRulesYamlConfig{
+					Set: map[string]string{"X-User": "{user}"},
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "unset environment variable",
+			headers: &values.HeaderPolicyYamlConfig{
+				Request: &values.HeaderRulesYamlConfig{
+					Set: map[string]string{"X-Internal-Auth": "{env:PROXY_TEST_UNSET_VARIABLE}"},
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "invalid header name",
+			headers: &values.HeaderPolicyYamlConfig{
+				Response: &values.HeaderRulesYamlConfig{
+					Remove: []string{"X Powered By"},
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "line break in a value",
+			headers: &values.HeaderPolicyYamlConfig{
+				Response: &values.HeaderRulesYamlConfig{
+					Add: map[string]string{"X-Other": "a\r\nSet-Cookie: b"},
+				},
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:   "service",
+							Domain: "service.com",
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+							Headers: tc.headers,
+							Routes: []values.RouteYamlConfig{
+								{
+									Match:    values.RouteMatchYamlConfig{Prefix: "/status/"},
+									Response: &values.DirectResponseYamlConfig{},
+									Headers:  tc.headers,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.Services["service.com"].Headers)
+			assert.Equal(t, tc.expected, configuration.Services["service.com"].Routes[0].Headers)
+		})
+	}
+}
+
+func TestToConfigurationTrustedProxies(t *testing.T) {
+	testCases := []struct {
+		name     string
+		proxies  []string
+		expected []string
+		valid    bool
+	}{
+		{
+			name:     "no trusted proxies",
+			proxies:  nil,
+			expected: nil,
+			valid:    true,
+		},
+		{
+			name:     "networks and addresses",
+			proxies:  []string{"10.0.0.0/8", "192.168.1.10", "2001:db8::/32", "::1"},
+			expected: []string{"10.0.0.0/8", "192.168.1.10/32", "2001:db8::/32", "::1/128"},
+			valid:    true,
+		},
+		{
+			name:    "invalid network",
+			proxies: []string{"10.0.0.0/33"},
+			valid:   false,
+		},
+		{
+			name:    "invalid address",
+			proxies: []string{"load-balancer"},
+			valid:   false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:   "service",
+							Domain: "service.com",
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+						},
+					},
+					TrustedProxies: tc.proxies,
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			var networks []string
+			for _, network := range configuration.TrustedProxies {
+				networks = append(networks, network.String())
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, networks)
+		})
+	}
+}
+
+func TestToConfigurationReplayBufferSize(t *testing.T) {
+	testCases := []struct {
+		name     string
+		size     int64
+		expected int64
+		valid    bool
+	}{
+		{
+			name:     "default size",
+			size:     0,
+			expected: values.DefaultReplayBufferSize,
+			valid:    true,
+		},
+		{
+			name:     "custom size",
+			size:     64 << 10,
+			expected: 64 << 10,
+			valid:    true,
+		},
+		{
+			name:  "negative size",
+			size:  -1,
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			yamlConfig := &values.YamlConfig{
+				Proxy: values.ProxyYamlConfig{
+					Listen: values.ListenYamlConfig{
+						Address: "127.0.0.1",
+						Port:    5000,
+					},
+					Services: []values.ServiceYamlConfig{
+						{
+							Name:   "service",
+							Domain: "service.com",
+							Hosts: []values.HostYamlConfig{
+								{
+									Address: "127.0.0.2",
+									Port:    5001,
+								},
+							},
+						},
+					},
+					ReplayBufferSize: tc.size,
+				},
+			}
+
+			configuration, err := yamlConfig.ToConfiguration()
+
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, configuration)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, configuration.GetReplayBufferSize())
+		})
+	}
+}
@@ -9,9 +9,15 @@ import (
 	"go-reverse-proxy/app/clients/httpclient"
 	"go-reverse-proxy/app/common/log"
 	"go-reverse-proxy/app/common/metrics"
+	"go-reverse-proxy/app/handlers/circuitbreaker"
 	config "go-reverse-proxy/app/handlers/configuration"
+	"go-reverse-proxy/app/handlers/healthcheck"
+	"go-reverse-proxy/app/handlers/hedging"
 	"go-reverse-proxy/app/handlers/loadbalancing"
+	"go-reverse-proxy/app/handlers/mirroring"
+	"go-reverse-proxy/app/handlers/outlierdetection"
 	"go-reverse-proxy/app/handlers/proxy"
+	"go-reverse-proxy/app/handlers/retrybudget"
 	"net"
 	"net/http"
 	"os"
@@ -76,6 +82,7 @@ func main() {
 		os.Exit(1)
 	}
 
+	// global retry policy, used by the services without a retry block
 	configuration.MaxForwardRetries = *maxForwardRetries
 	configuration.RetryableStatusCodes = []int{http.StatusInternalServerError}
 
@@ -98,12 +105,18 @@ func main() {
 	}
 
 	// instantiate the proxy requests handler
+	loadBalancer := loadbalancing.New(logger)
 	proxyHandler := proxy.New(
 		logger,
 		metricsCtx,
 		*configuration,
 		httpClient,
-		loadbalancing.New(logger),
+		loadBalancer,
+		outlierdetection.New(logger, metricsCtx),
+		circuitbreaker.New(logger, metricsCtx),
+		retrybudget.New(logger, metricsCtx),
+		hedging.New(logger, metricsCtx),
+		mirroring.New(logger, metricsCtx, httpClient, loadBalancer),
 	)
 
 	prometheusStart, prometheusClose, err := preparePrometheus(
@@ -114,6 +127,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	// create start/end handler functions of the hosts health checker
+	healthCheckStart, healthCheckClose := prepareHealthCheck(
+		logger,
+		healthcheck.New(logger, *configuration, nil),
+	)
+
 	// create start/end handler functions of the HTTP server
 	httpAddr := fmt.Sprintf(
 		"%s:%s",
@@ -125,6 +144,7 @@ func main() {
 		logger,
 		httpAddr,
 		proxyHandler,
+		configuration.PathPrefix,
 	)
 
 	// create shutdown handler functions
@@ -132,6 +152,7 @@ func main() {
 		logger,
 		httpServerClose,
 		prometheusClose,
+		healthCheckClose,
 	)
 
 	var g group.Group
@@ -139,6 +160,10 @@ func main() {
 		// create Prometheus metrics server
 		g.Add(prometheusStart, prometheusClose)
 	}
+	{
+		// create hosts health checker
+		g.Add(healthCheckStart, healthCheckClose)
+	}
 	{
 		// create HTTP server
 		g.Add(httpServerStart, httpServerClose)
@@ -178,12 +203,34 @@ func preparePrometheus(
 	return startFunc, closeFunc, nil
 }
 
+// prepareHealthCheck creates the start and close functions that
+// are served to the hosts health checker goroutine
+func prepareHealthCheck(
+	logger klog.Logger,
+	checker healthcheck.Handler,
+) (func() error, func(error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	startFunc := func() error {
+		logger.Log("start", "healthcheck")
+		return checker.Run(ctx)
+	}
+
+	closeFunc := func(error) {
+		logger.Log("shutdown", "healthcheck")
+		cancel()
+	}
+
+	return startFunc, closeFunc
+}
+
 // prepareHTTPServer creates the start and close functions that
 // are served to the proxy HTTP server goroutine
 func prepareHTTPServer(
 	logger klog.Logger,
 	addr string,
 	svc proxy.Handler,
+	pathPrefix string,
 ) (func() error, func(error), error) {
 
 	listener, err := net.Listen("tcp", addr)
@@ -199,6 +246,7 @@ func prepareHTTPServer(
 			APIHandler(
 				logger,
 				svc,
+				pathPrefix,
 			),
 		)
 	}
@@ -241,18 +289,18 @@ func prepareShutdown(logger klog.Logger, closers ...func(error)) (func() error,
 	return startFunc, closeFunc
 }
 
+// APIHandler returns the router of the proxy, which is served directly
+// instead of through http.DefaultServeMux, since the mux would clean the
+// paths and redirect them before the router forwards them as they were sent
 func APIHandler(
 	logger glog.Logger,
 	svc proxy.Handler,
+	pathPrefix string,
 ) http.Handler {
-	http.Handle(
-		"/",
-		api.New(
-			logger,
-			transport.BuildEndpointRegister(
-				logger, svc,
-			),
+	return api.New(
+		logger,
+		transport.BuildEndpointRegister(
+			logger, svc, pathPrefix,
 		),
 	)
-	return http.DefaultServeMux
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"go-reverse-proxy/app/common/log"
+	"go-reverse-proxy/app/values"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	proxyMock "go-reverse-proxy/mocks/app/handlers/proxy"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIHandlerKeepsPaths(t *testing.T) {
+	testCases := []struct {
+		name       string
+		pathPrefix string
+		path       string
+		endpoint   string
+	}{
+		{
+			name:       "double slashes",
+			pathPrefix: "/",
+			path:       "/api//v1",
+			endpoint:   "api//v1",
+		},
+		{
+			name:       "dot segments",
+			pathPrefix: "/",
+			path:       "/api/../v1",
+			endpoint:   "api/../v1",
+		},
+		{
+			name:       "double slashes after the legacy prefix",
+			pathPrefix: "/proxy/",
+			path:       "/proxy/api//v1",
+			endpoint:   "api//v1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			forwardRequestProviderMock := &proxyMock.HandlerMock{
+				ForwardFunc: func(ctx context.Context, request *values.Request) (*values.Response, error) {
+					return &values.Response{StatusCode: http.StatusOK}, nil
+				},
+			}
+
+			handler := APIHandler(log.NewNopLogger(), forwardRequestProviderMock, tc.pathPrefix)
+
+			req := httptest.NewRequest("GET", "http://127.0.0.1:8080"+tc.path, nil)
+			req.Host = "service.com"
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			// the path reaches the host as the client sent it, without a redirect
+			assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+			if assert.Len(t, forwardRequestProviderMock.ForwardCalls(), 1) {
+				assert.Equal(t, tc.endpoint, forwardRequestProviderMock.ForwardCalls()[0].Request.Endpoint)
+			}
+		})
+	}
+}
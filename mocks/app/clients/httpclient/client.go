@@ -6,6 +6,8 @@ package db_mock
 import (
 	"context"
 	"go-reverse-proxy/app/clients/httpclient"
+	"go-reverse-proxy/app/values"
+	"io"
 	"net/http"
 	"sync"
 )
@@ -23,7 +25,7 @@ var _ httpclient.HttpClient = &HttpClientMock{}
 // 			GetHttpClientFunc: func() *http.Client {
 // 				panic("mock out the GetHttpClient method")
 // 			},
-// 			RequestFunc: func(ctx context.Context, method string, address string, header http.Header, parameters string, payload []byte) ([]byte, int, error) {
+// 			RequestFunc: func(ctx context.Context, method string, address string, header http.Header, parameters string, body io.Reader) (*values.Response, error) {
 // 				panic("mock out the Request method")
 // 			},
 // 		}
@@ -37,7 +39,7 @@ type HttpClientMock struct {
 	GetHttpClientFunc func() *http.Client
 
 	// RequestFunc mocks the Request method.
-	RequestFunc func(ctx context.Context, method string, address string, header http.Header, parameters string, payload []byte) ([]byte, int, error)
+	RequestFunc func(ctx context.Context, method string, address string, header http.Header, parameters string, body io.Reader) (*values.Response, error)
 
 	// calls tracks calls to the methods.
 	calls struct {
@@ -56,8 +58,8 @@ type HttpClientMock struct {
 			Header http.Header
 			// Parameters is the parameters argument value.
 			Parameters string
-			// Payload is the payload argument value.
-			Payload []byte
+			// Body is the body argument value.
+			Body io.Reader
 		}
 	}
 	lockGetHttpClient sync.RWMutex
@@ -91,7 +93,7 @@ func (mock *HttpClientMock) GetHttpClientCalls() []struct {
 }
 
 // Request calls RequestFunc.
-func (mock *HttpClientMock) Request(ctx context.Context, method string, address string, header http.Header, parameters string, payload []byte) ([]byte, int, error) {
+func (mock *HttpClientMock) Request(ctx context.Context, method string, address string, header http.Header, parameters string, body io.Reader) (*values.Response, error) {
 	if mock.RequestFunc == nil {
 		panic("HttpClientMock.RequestFunc: method is nil but HttpClient.Request was just called")
 	}
@@ -101,19 +103,19 @@ func (mock *HttpClientMock) Request(ctx context.Context, method string, address
 		Address    string
 		Header     http.Header
 		Parameters string
-		Payload    []byte
+		Body       io.Reader
 	}{
 		Ctx:        ctx,
 		Method:     method,
 		Address:    address,
 		Header:     header,
 		Parameters: parameters,
-		Payload:    payload,
+		Body:       body,
 	}
 	mock.lockRequest.Lock()
 	mock.calls.Request = append(mock.calls.Request, callInfo)
 	mock.lockRequest.Unlock()
-	return mock.RequestFunc(ctx, method, address, header, parameters, payload)
+	return mock.RequestFunc(ctx, method, address, header, parameters, body)
 }
 
 // RequestCalls gets all the calls that were made to Request.
@@ -125,7 +127,7 @@ func (mock *HttpClientMock) RequestCalls() []struct {
 	Address    string
 	Header     http.Header
 	Parameters string
-	Payload    []byte
+	Body       io.Reader
 } {
 	var calls []struct {
 		Ctx        context.Context
@@ -133,7 +135,7 @@ func (mock *HttpClientMock) RequestCalls() []struct {
 		Address    string
 		Header     http.Header
 		Parameters string
-		Payload    []byte
+		Body       io.Reader
 	}
 	mock.lockRequest.RLock()
 	calls = mock.calls.Request
@@ -8,6 +8,7 @@ import (
 	"go-reverse-proxy/app/handlers/loadbalancing"
 	"go-reverse-proxy/app/values"
 	"sync"
+	"time"
 )
 
 // Ensure, that HandlerMock does implement loadbalancing.Handler.
@@ -20,8 +21,17 @@ var _ loadbalancing.Handler = &HandlerMock{}
 //
 // 		// make and configure a mocked loadbalancing.Handler
 // 		mockedHandler := &HandlerMock{
-// 			SetNextHostFunc: func(ctx context.Context, service *values.Service)  {
-// 				panic("mock out the SetNextHost method")
+// 			NextHostFunc: func(ctx context.Context, service *values.Service, request *values.Request) *values.Host {
+// 				panic("mock out the NextHost method")
+// 			},
+// 			NextHostExcludingFunc: func(ctx context.Context, service *values.Service, request *values.Request, excluded *values.Host) *values.Host {
+// 				panic("mock out the NextHostExcluding method")
+// 			},
+// 			RequestFinishedFunc: func(ctx context.Context, service *values.Service, host *values.Host, latency time.Duration)  {
+// 				panic("mock out the RequestFinished method")
+// 			},
+// 			RequestStartedFunc: func(ctx context.Context, service *values.Service, host *values.Host)  {
+// 				panic("mock out the RequestStarted method")
 // 			},
 // 		}
 //
@@ -30,53 +40,227 @@ var _ loadbalancing.Handler = &HandlerMock{}
 //
 // 	}
 type HandlerMock struct {
-	// SetNextHostFunc mocks the SetNextHost method.
-	SetNextHostFunc func(ctx context.Context, service *values.Service)
+	// NextHostFunc mocks the NextHost method.
+	NextHostFunc func(ctx context.Context, service *values.Service, request *values.Request) *values.Host
+
+	// NextHostExcludingFunc mocks the NextHostExcluding method.
+	NextHostExcludingFunc func(ctx context.Context, service *values.Service, request *values.Request, excluded *values.Host) *values.Host
+
+	// RequestFinishedFunc mocks the RequestFinished method.
+	RequestFinishedFunc func(ctx context.Context, service *values.Service, host *values.Host, latency time.Duration)
+
+	// RequestStartedFunc mocks the RequestStarted method.
+	RequestStartedFunc func(ctx context.Context, service *values.Service, host *values.Host)
 
 	// calls tracks calls to the methods.
 	calls struct {
-		// SetNextHost holds details about calls to the SetNextHost method.
-		SetNextHost []struct {
+		// NextHost holds details about calls to the NextHost method.
+		NextHost []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Service is the service argument value.
+			Service *values.Service
+			// Request is the request argument value.
+			Request *values.Request
+		}
+		// NextHostExcluding holds details about calls to the NextHostExcluding method.
+		NextHostExcluding []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Service is the service argument value.
+			Service *values.Service
+			// Request is the request argument value.
+			Request *values.Request
+			// Excluded is the excluded argument value.
+			Excluded *values.Host
+		}
+		// RequestFinished holds details about calls to the RequestFinished method.
+		RequestFinished []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Service is the service argument value.
+			Service *values.Service
+			// Host is the host argument value.
+			Host *values.Host
+			// Latency is the latency argument value.
+			Latency time.Duration
+		}
+		// RequestStarted holds details about calls to the RequestStarted method.
+		RequestStarted []struct {
 			// Ctx is the ctx argument value.
 			Ctx context.Context
 			// Service is the service argument value.
 			Service *values.Service
+			// Host is the host argument value.
+			Host *values.Host
 		}
 	}
-	lockSetNextHost sync.RWMutex
+	lockNextHost          sync.RWMutex
+	lockNextHostExcluding sync.RWMutex
+	lockRequestFinished   sync.RWMutex
+	lockRequestStarted    sync.RWMutex
+}
+
+// NextHost calls NextHostFunc.
+func (mock *HandlerMock) NextHost(ctx context.Context, service *values.Service, request *values.Request) *values.Host {
+	if mock.NextHostFunc == nil {
+		panic("HandlerMock.NextHostFunc: method is nil but Handler.NextHost was just called")
+	}
+	callInfo := struct {
+		Ctx     context.Context
+		Service *values.Service
+		Request *values.Request
+	}{
+		Ctx:     ctx,
+		Service: service,
+		Request: request,
+	}
+	mock.lockNextHost.Lock()
+	mock.calls.NextHost = append(mock.calls.NextHost, callInfo)
+	mock.lockNextHost.Unlock()
+	return mock.NextHostFunc(ctx, service, request)
+}
+
+// NextHostCalls gets all the calls that were made to NextHost.
+// Check the length with:
+//     len(mockedHandler.NextHostCalls())
+func (mock *HandlerMock) NextHostCalls() []struct {
+	Ctx     context.Context
+	Service *values.Service
+	Request *values.Request
+} {
+	var calls []struct {
+		Ctx     context.Context
+		Service *values.Service
+		Request *values.Request
+	}
+	mock.lockNextHost.RLock()
+	calls = mock.calls.NextHost
+	mock.lockNextHost.RUnlock()
+	return calls
+}
+
+// NextHostExcluding calls NextHostExcludingFunc.
+func (mock *HandlerMock) NextHostExcluding(ctx context.Context, service *values.Service, request *values.Request, excluded *values.Host) *values.Host {
+	if mock.NextHostExcludingFunc == nil {
+		panic("HandlerMock.NextHostExcludingFunc: method is nil but Handler.NextHostExcluding was just called")
+	}
+	callInfo := struct {
+		Ctx      context.Context
+		Service  *values.Service
+		Request  *values.Request
+		Excluded *values.Host
+	}{
+		Ctx:      ctx,
+		Service:  service,
+		Request:  request,
+		Excluded: excluded,
+	}
+	mock.lockNextHostExcluding.Lock()
+	mock.calls.NextHostExcluding = append(mock.calls.NextHostExcluding, callInfo)
+	mock.lockNextHostExcluding.Unlock()
+	return mock.NextHostExcludingFunc(ctx, service, request, excluded)
+}
+
+// NextHostExcludingCalls gets all the calls that were made to NextHostExcluding.
+// Check the length with:
+//     len(mockedHandler.NextHostExcludingCalls())
+func (mock *HandlerMock) NextHostExcludingCalls() []struct {
+	Ctx      context.Context
+	Service  *values.Service
+	Request  *values.Request
+	Excluded *values.Host
+} {
+	var calls []struct {
+		Ctx      context.Context
+		Service  *values.Service
+		Request  *values.Request
+		Excluded *values.Host
+	}
+	mock.lockNextHostExcluding.RLock()
+	calls = mock.calls.NextHostExcluding
+	mock.lockNextHostExcluding.RUnlock()
+	return calls
+}
+
+// RequestFinished calls RequestFinishedFunc.
+func (mock *HandlerMock) RequestFinished(ctx context.Context, service *values.Service, host *values.Host, latency time.Duration) {
+	if mock.RequestFinishedFunc == nil {
+		panic("HandlerMock.RequestFinishedFunc: method is nil but Handler.RequestFinished was just called")
+	}
+	callInfo := struct {
+		Ctx     context.Context
+		Service *values.Service
+		Host    *values.Host
+		Latency time.Duration
+	}{
+		Ctx:     ctx,
+		Service: service,
+		Host:    host,
+		Latency: latency,
+	}
+	mock.lockRequestFinished.Lock()
+	mock.calls.RequestFinished = append(mock.calls.RequestFinished, callInfo)
+	mock.lockRequestFinished.Unlock()
+	mock.RequestFinishedFunc(ctx, service, host, latency)
+}
+
+// RequestFinishedCalls gets all the calls that were made to RequestFinished.
+// Check the length with:
+//     len(mockedHandler.RequestFinishedCalls())
+func (mock *HandlerMock) RequestFinishedCalls() []struct {
+	Ctx     context.Context
+	Service *values.Service
+	Host    *values.Host
+	Latency time.Duration
+} {
+	var calls []struct {
+		Ctx     context.Context
+		Service *values.Service
+		Host    *values.Host
+		Latency time.Duration
+	}
+	mock.lockRequestFinished.RLock()
+	calls = mock.calls.RequestFinished
+	mock.lockRequestFinished.RUnlock()
+	return calls
 }
 
-// SetNextHost calls SetNextHostFunc.
-func (mock *HandlerMock) SetNextHost(ctx context.Context, service *values.Service) {
-	if mock.SetNextHostFunc == nil {
-		panic("HandlerMock.SetNextHostFunc: method is nil but Handler.SetNextHost was just called")
+// RequestStarted calls RequestStartedFunc.
+func (mock *HandlerMock) RequestStarted(ctx context.Context, service *values.Service, host *values.Host) {
+	if mock.RequestStartedFunc == nil {
+		panic("HandlerMock.RequestStartedFunc: method is nil but Handler.RequestStarted was just called")
 	}
 	callInfo := struct {
 		Ctx     context.Context
 		Service *values.Service
+		Host    *values.Host
 	}{
 		Ctx:     ctx,
 		Service: service,
+		Host:    host,
 	}
-	mock.lockSetNextHost.Lock()
-	mock.calls.SetNextHost = append(mock.calls.SetNextHost, callInfo)
-	mock.lockSetNextHost.Unlock()
-	mock.SetNextHostFunc(ctx, service)
+	mock.lockRequestStarted.Lock()
+	mock.calls.RequestStarted = append(mock.calls.RequestStarted, callInfo)
+	mock.lockRequestStarted.Unlock()
+	mock.RequestStartedFunc(ctx, service, host)
 }
 
-// SetNextHostCalls gets all the calls that were made to SetNextHost.
+// RequestStartedCalls gets all the calls that were made to RequestStarted.
 // Check the length with:
-//     len(mockedHandler.SetNextHostCalls())
-func (mock *HandlerMock) SetNextHostCalls() []struct {
+//     len(mockedHandler.RequestStartedCalls())
+func (mock *HandlerMock) RequestStartedCalls() []struct {
 	Ctx     context.Context
 	Service *values.Service
+	Host    *values.Host
 } {
 	var calls []struct {
 		Ctx     context.Context
 		Service *values.Service
+		Host    *values.Host
 	}
-	mock.lockSetNextHost.RLock()
-	calls = mock.calls.SetNextHost
-	mock.lockSetNextHost.RUnlock()
+	mock.lockRequestStarted.RLock()
+	calls = mock.calls.RequestStarted
+	mock.lockRequestStarted.RUnlock()
 	return calls
 }
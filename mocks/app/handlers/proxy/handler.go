@@ -20,7 +20,7 @@ var _ proxy.Handler = &HandlerMock{}
 //
 // 		// make and configure a mocked proxy.Handler
 // 		mockedHandler := &HandlerMock{
-// 			ForwardFunc: func(ctx context.Context, request *values.Request) ([]byte, int, error) {
+// 			ForwardFunc: func(ctx context.Context, request *values.Request) (*values.Response, error) {
 // 				panic("mock out the Forward method")
 // 			},
 // 		}
@@ -31,7 +31,7 @@ var _ proxy.Handler = &HandlerMock{}
 // 	}
 type HandlerMock struct {
 	// ForwardFunc mocks the Forward method.
-	ForwardFunc func(ctx context.Context, request *values.Request) ([]byte, int, error)
+	ForwardFunc func(ctx context.Context, request *values.Request) (*values.Response, error)
 
 	// calls tracks calls to the methods.
 	calls struct {
@@ -47,7 +47,7 @@ type HandlerMock struct {
 }
 
 // Forward calls ForwardFunc.
-func (mock *HandlerMock) Forward(ctx context.Context, request *values.Request) ([]byte, int, error) {
+func (mock *HandlerMock) Forward(ctx context.Context, request *values.Request) (*values.Response, error) {
 	if mock.ForwardFunc == nil {
 		panic("HandlerMock.ForwardFunc: method is nil but Handler.Forward was just called")
 	}